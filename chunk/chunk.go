@@ -0,0 +1,312 @@
+// Package chunk implements a key/value store wrapper which splits large values into chunks, for
+// backends which limit the size of values or handle large values poorly.
+package chunk
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+)
+
+// DefaultSize is the chunk size used if Options.Size isn't set. It keeps chunks below the 1MB
+// entity limit of the App Engine datastore.
+const DefaultSize = 512 * 1024
+
+const (
+	// kindInline marks a value stored as is under its key
+	kindInline byte = iota
+	// kindManifest marks a value stored in chunks, described by the manifest under its key
+	kindManifest
+)
+
+var (
+	// ErrMissingChunk is returned when a chunk of a value is missing from the underlying store,
+	// for example because it was evicted from a cache
+	ErrMissingChunk = errors.New("chunk: chunk is missing")
+	// ErrInvalidValue is returned for values under a key which weren't written by a chunk Store
+	ErrInvalidValue = errors.New("chunk: invalid value")
+
+	// ensure struct implements the kv.Store interface
+	_ kv.Store = (*Store)(nil)
+)
+
+// Options configures a chunk Store
+type Options struct {
+	// Size is the maximum number of bytes stored under a single key. Values above it are split
+	// into chunks. Defaults to DefaultSize.
+	Size int
+}
+
+// value is stored under the key and chunk keys. It's a struct rather than a []byte so drivers
+// which only store structs, such as the App Engine datastore, can hold it too. Data isn't indexed
+// since the datastore limits indexed values to 1500 bytes.
+type value struct {
+	Data []byte `datastore:",noindex"`
+}
+
+// manifest describes a value split into chunks. Chunks are stored under keys derived from the
+// ID, which is new for every write, so readers never mix the chunks of different values.
+type manifest struct {
+	ID     string `json:"id"`
+	Chunks int    `json:"chunks"`
+	Size   int64  `json:"size"`
+}
+
+// Store is a key/value store wrapping another store, such as a driver or a gokv.Client. Values
+// larger than the chunk size are split into numbered chunk keys, and the key holds a manifest
+// listing them. The underlying store only ever receives values of an unexported struct type
+// holding the bytes.
+//
+// A chunked value is replaced by writing its new chunks first and then the manifest, so readers
+// see either the old or the new value. Chunks of a replaced or deleted value are deleted
+// afterwards, and are left behind if that fails. Concurrent writes to the same key may also leave
+// unused chunks behind.
+type Store struct {
+	s     kv.Store
+	size  int
+	codec codec.Codec
+}
+
+// New returns a store splitting the values stored in s into chunks. Values are encoded before
+// they are chunked with the codec set with kv.WithCodec, which defaults to codec.Gob. io.Reader
+// values are always stored as they are read.
+func New(s kv.Store, options Options, opts ...kv.Option) *Store {
+	st := &Store{s: s, size: options.Size, codec: kv.NewOptions(opts...).CodecOr(codec.Gob)}
+	if st.size <= 0 {
+		st.size = DefaultSize
+	}
+	return st
+}
+
+// chunkKey returns the key of the nth chunk of the value with the manifest. Only characters valid
+// in the keys of all drivers are used, memcached being the most restrictive.
+func chunkKey(key string, m *manifest, n int) string {
+	return fmt.Sprintf("%s.chunk-%s-%d", key, m.ID, n)
+}
+
+// chunkKeys returns the keys of all chunks of the value with the manifest
+func chunkKeys(key string, m *manifest) []string {
+	keys := make([]string, m.Chunks)
+	for i := range keys {
+		keys[i] = chunkKey(key, m, i)
+	}
+	return keys
+}
+
+// Set implements the "kv.Store".Set() interface. io.Reader values are streamed like SetReader does.
+func (s *Store) Set(key string, value interface{}) error {
+	if r, ok := value.(io.Reader); ok {
+		return s.SetReader(key, r)
+	}
+	b, err := s.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.SetReader(key, bytes.NewReader(b))
+}
+
+// SetReader sets the value of the key to the contents of r, which are read one chunk at a time
+// so large values don't need to fit in memory
+func (s *Store) SetReader(key string, r io.Reader) error {
+	old, err := s.manifest(key)
+	if err != nil && err != kv.ErrNotFound && err != ErrInvalidValue {
+		return err
+	}
+
+	buf := make([]byte, s.size)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		if err := s.s.Set(key, &value{Data: append([]byte{kindInline}, buf[:n]...)}); err != nil {
+			return err
+		}
+		s.delChunks(key, old)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	m := &manifest{}
+	if m.ID, err = newID(); err != nil {
+		return err
+	}
+	for n > 0 {
+		if err := s.s.Set(chunkKey(key, m, m.Chunks), &value{Data: buf[:n]}); err != nil {
+			s.delChunks(key, m)
+			return err
+		}
+		m.Chunks++
+		m.Size += int64(n)
+		buf = make([]byte, s.size)
+		if n, err = io.ReadFull(r, buf); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			s.delChunks(key, m)
+			return err
+		}
+	}
+
+	b, err := json.Marshal(m)
+	if err == nil {
+		err = s.s.Set(key, &value{Data: append([]byte{kindManifest}, b...)})
+	}
+	if err != nil {
+		s.delChunks(key, m)
+		return err
+	}
+	s.delChunks(key, old)
+	return nil
+}
+
+// newID returns a random ID for the chunks of a value
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Get implements the "kv.Store".Get() interface. The value is read into an io.Writer dstVal one
+// chunk at a time, and is reassembled and decoded otherwise. Writers receive the bytes as stored,
+// which are the value itself if it was set from an io.Reader or encoded verbatim by the codec.
+func (s *Store) Get(key string, dstVal interface{}) error {
+	r, err := s.GetReader(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if w, ok := dstVal.(io.Writer); ok {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.codec.Unmarshal(b, dstVal)
+}
+
+// GetReader returns a reader for the value of the key, which fetches one chunk at a time. The
+// reader must be closed when done.
+func (s *Store) GetReader(key string) (io.ReadCloser, error) {
+	var v value
+	if err := s.s.Get(key, &v); err != nil {
+		return nil, err
+	}
+	b := v.Data
+	if len(b) == 0 {
+		return nil, ErrInvalidValue
+	}
+	switch b[0] {
+	case kindInline:
+		return ioutil.NopCloser(bytes.NewReader(b[1:])), nil
+	case kindManifest:
+		m, err := parseManifest(b)
+		if err != nil {
+			return nil, err
+		}
+		return &reader{s: s, key: key, m: m}, nil
+	}
+	return nil, ErrInvalidValue
+}
+
+// manifest returns the manifest of the key, or nil if its value isn't chunked
+func (s *Store) manifest(key string) (*manifest, error) {
+	var v value
+	if err := s.s.Get(key, &v); err != nil {
+		if err == kv.ErrCacheMiss {
+			return nil, kv.ErrNotFound
+		}
+		return nil, err
+	}
+	if len(v.Data) == 0 || v.Data[0] != kindManifest {
+		return nil, nil
+	}
+	return parseManifest(v.Data)
+}
+
+// parseManifest decodes a manifest stored with its kind byte
+func parseManifest(b []byte) (*manifest, error) {
+	m := &manifest{}
+	if err := json.Unmarshal(b[1:], m); err != nil || m.ID == "" || m.Chunks < 0 {
+		return nil, ErrInvalidValue
+	}
+	return m, nil
+}
+
+// Del implements the "kv.Store".Del() interface, deleting the key along with all its chunks
+func (s *Store) Del(key string) error {
+	m, err := s.manifest(key)
+	if err != nil {
+		return err
+	}
+	if err := s.s.Del(key); err != nil {
+		return err
+	}
+	if m != nil {
+		return kv.DelMulti(s.s, chunkKeys(key, m))
+	}
+	return nil
+}
+
+// delChunks deletes the chunks of m, if any. Errors are ignored since the chunks are unreachable
+// either way.
+func (s *Store) delChunks(key string, m *manifest) {
+	if m != nil && m.Chunks > 0 {
+		kv.DelMulti(s.s, chunkKeys(key, m))
+	}
+}
+
+// Store returns the underlying store
+func (s *Store) Store() kv.Store {
+	return s.s
+}
+
+// reader reads a chunked value one chunk at a time
+type reader struct {
+	s   *Store
+	key string
+	m   *manifest
+
+	n    int
+	buf  []byte
+	read int64
+}
+
+// Read implements the io.Reader interface
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.n >= r.m.Chunks {
+			if r.read != r.m.Size {
+				return 0, ErrInvalidValue
+			}
+			return 0, io.EOF
+		}
+		var v value
+		if err := r.s.s.Get(chunkKey(r.key, r.m, r.n), &v); err != nil {
+			if err == kv.ErrNotFound || err == kv.ErrCacheMiss {
+				return 0, ErrMissingChunk
+			}
+			return 0, err
+		}
+		r.buf = v.Data
+		r.n++
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.read += int64(n)
+	return n, nil
+}
+
+// Close implements the io.Closer interface. Chunks are fetched as they are read, so there's
+// nothing to release.
+func (r *reader) Close() error {
+	return nil
+}
@@ -0,0 +1,213 @@
+package chunk
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/bradberger/gokv"
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/drivers/memory"
+	"github.com/bradberger/gokv/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+type testStruct struct {
+	Foo string
+}
+
+var errInvalidEntity = errors.New("invalid entity type")
+
+// entityStore is a store which, like the App Engine datastore, only stores struct pointers
+type entityStore struct {
+	*memory.Store
+}
+
+func (s entityStore) Set(key string, v interface{}) error {
+	if err := structPtr(v); err != nil {
+		return err
+	}
+	return s.Store.Set(key, v)
+}
+
+func (s entityStore) Get(key string, dstVal interface{}) error {
+	if err := structPtr(dstVal); err != nil {
+		return err
+	}
+	return s.Store.Get(key, dstVal)
+}
+
+func structPtr(v interface{}) error {
+	if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errInvalidEntity
+	}
+	return nil
+}
+
+// blob returns n bytes of test data
+func blob(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func TestNew(t *testing.T) {
+	m := memory.New(memory.Options{})
+	s := New(m, Options{})
+	assert.Equal(t, DefaultSize, s.size)
+	assert.True(t, codec.Equal(codec.Gob, s.codec))
+	assert.Equal(t, m, s.Store())
+}
+
+func TestInline(t *testing.T) {
+	var v testStruct
+	m := memory.New(memory.Options{})
+	s := New(m, Options{Size: 64}, kv.WithCodec(codec.JSON))
+	assert.NoError(t, s.Set("foo", testStruct{"bar"}))
+	assert.Equal(t, []string{"foo"}, m.Keys())
+	assert.NoError(t, s.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+
+	var raw value
+	assert.NoError(t, m.Get("foo", &raw))
+	assert.Equal(t, append([]byte{kindInline}, `{"Foo":"bar"}`...), raw.Data)
+}
+
+func TestChunked(t *testing.T) {
+	var b []byte
+	m := memory.New(memory.Options{})
+	s := New(m, Options{Size: 10}, kv.WithCodec(codec.RawOr(codec.Gob)))
+	assert.NoError(t, s.Set("foo", blob(35)))
+	assert.Len(t, m.Keys(), 5)
+	assert.NoError(t, s.Get("foo", &b))
+	assert.Equal(t, blob(35), b)
+
+	r, err := s.GetReader("foo")
+	assert.NoError(t, err)
+	b, err = ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, blob(35), b)
+	assert.NoError(t, r.Close())
+
+	var buf bytes.Buffer
+	assert.NoError(t, s.Get("foo", &buf))
+	assert.Equal(t, blob(35), buf.Bytes())
+
+	// Values which are a multiple of the chunk size don't get an empty chunk
+	assert.NoError(t, s.Set("foo", blob(20)))
+	assert.Len(t, m.Keys(), 3)
+	assert.NoError(t, s.Get("foo", &b))
+	assert.Equal(t, blob(20), b)
+
+	// Replacing or deleting a value deletes its chunks
+	assert.NoError(t, s.Set("foo", "bar"))
+	assert.Equal(t, []string{"foo"}, m.Keys())
+	assert.NoError(t, s.Set("foo", blob(35)))
+	assert.NoError(t, s.Del("foo"))
+	assert.Len(t, m.Keys(), 0)
+	assert.Equal(t, kv.ErrNotFound, s.Get("foo", &b))
+	assert.Equal(t, kv.ErrNotFound, s.Del("foo"))
+}
+
+func TestSetReader(t *testing.T) {
+	var v testStruct
+	m := memory.New(memory.Options{})
+	s := New(m, Options{Size: 10})
+	assert.NoError(t, s.SetReader("foo", bytes.NewReader(blob(25))))
+	r, err := s.GetReader("foo")
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(iotest.OneByteReader(r))
+	assert.NoError(t, err)
+	assert.Equal(t, blob(25), b)
+
+	// Encoded values are chunked too
+	v.Foo = strings.Repeat("bar", 10)
+	assert.NoError(t, s.Set("bar", &v))
+	v = testStruct{}
+	assert.NoError(t, s.Get("bar", &v))
+	assert.Equal(t, strings.Repeat("bar", 10), v.Foo)
+}
+
+func TestSetErr(t *testing.T) {
+	m := memory.New(memory.Options{})
+	s := New(m, Options{Size: 10}, kv.WithCodec(codec.ErrTestCodec))
+	assert.Error(t, s.Set("foo", testStruct{"bar"}))
+
+	// Chunks written before the reader fails are deleted
+	err := errors.New("read error")
+	assert.Equal(t, err, s.Set("foo", io.MultiReader(bytes.NewReader(blob(25)), iotest.ErrReader(err))))
+	assert.Len(t, m.Keys(), 0)
+	assert.Equal(t, err, s.Set("foo", iotest.ErrReader(err)))
+	assert.Len(t, m.Keys(), 0)
+}
+
+func TestInvalid(t *testing.T) {
+	var b []byte
+	m := memory.New(memory.Options{})
+	s := New(m, Options{Size: 10}, kv.WithCodec(codec.RawOr(codec.Gob)))
+	for _, invalid := range [][]byte{{}, {9}, {kindManifest, '{'}, append([]byte{kindManifest}, `{"chunks":1}`...)} {
+		assert.NoError(t, m.Set("foo", &value{Data: invalid}))
+		assert.Equal(t, ErrInvalidValue, s.Get("foo", &b))
+	}
+
+	// Invalid values can be replaced
+	assert.NoError(t, s.Set("foo", "bar"))
+	assert.NoError(t, s.Get("foo", &b))
+	assert.Equal(t, "bar", string(b))
+}
+
+func TestMissingChunk(t *testing.T) {
+	var b []byte
+	m := memory.New(memory.Options{})
+	s := New(m, Options{Size: 10})
+	assert.NoError(t, s.Set("foo", blob(35)))
+	for _, key := range m.Keys() {
+		if key != "foo" {
+			assert.NoError(t, m.Del(key))
+			break
+		}
+	}
+	assert.Equal(t, ErrMissingChunk, s.Get("foo", &b))
+}
+
+func TestClient(t *testing.T) {
+	var b []byte
+	c := gokv.New()
+	nodes := []*memory.Store{memory.New(memory.Options{}), memory.New(memory.Options{})}
+	assert.NoError(t, c.AddNode("a", nodes[0]))
+	assert.NoError(t, c.AddNode("b", nodes[1]))
+	assert.NoError(t, c.ReplicateToN(2))
+	c.SetReplicateMethod(gokv.ReplicateSync)
+	s := New(c, Options{Size: 10}, kv.WithCodec(codec.RawOr(codec.Gob)))
+	assert.NoError(t, s.Set("foo", blob(100)))
+	assert.Len(t, nodes[0].Keys(), 11)
+	assert.Len(t, nodes[1].Keys(), 11)
+	assert.NoError(t, s.Get("foo", &b))
+	assert.Equal(t, blob(100), b)
+	assert.NoError(t, s.Del("foo"))
+	assert.Equal(t, 0, len(nodes[0].Keys())+len(nodes[1].Keys()))
+}
+
+func TestEntityStore(t *testing.T) {
+	var v testStruct
+	m := entityStore{memory.New(memory.Options{})}
+	assert.Equal(t, errInvalidEntity, m.Set("foo", []byte("bar")))
+
+	s := New(m, Options{Size: 10})
+	assert.NoError(t, s.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, s.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+	assert.NoError(t, s.Set("foo", testStruct{strings.Repeat("bar", 10)}))
+	assert.True(t, len(m.Keys()) > 1)
+	assert.NoError(t, s.Get("foo", &v))
+	assert.Equal(t, strings.Repeat("bar", 10), v.Foo)
+	assert.NoError(t, s.Del("foo"))
+	assert.Len(t, m.Keys(), 0)
+}
@@ -6,29 +6,51 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"fmt"
 
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
 	"gopkg.in/mgo.v2/bson"
 )
 
-// Codec defines an interface for encoding/decoding Go values to bytes
+// Codec defines an interface for encoding/decoding Go values to bytes.
+//
+// Name was added after Marshal and Unmarshal, so unkeyed literals like
+// codec.Codec{json.Marshal, json.Unmarshal} no longer compile and need their field names.
 type Codec struct {
 	Marshal   func(interface{}) ([]byte, error)
 	Unmarshal func([]byte, interface{}) error
+
+	// Name identifies the encoding, and is what Equal compares. Codecs wrapping another codec
+	// include its name and their parameters, like "compress(gob,1,0)". Codecs without a name are
+	// never equal to another codec, so custom codecs should set it.
+	Name string
 }
 
 var (
 	// Gob is a Codec that uses the gob package
-	Gob = Codec{gobMarshal, gobUnmarshal}
+	Gob = Codec{Marshal: gobMarshal, Unmarshal: gobUnmarshal, Name: "gob"}
 	// JSON is a Codec that uses the json package.
-	JSON = Codec{json.Marshal, json.Unmarshal}
+	JSON = Codec{Marshal: json.Marshal, Unmarshal: json.Unmarshal, Name: "json"}
 	// XML is a codec that uses the xml pacakge
-	XML = Codec{xml.Marshal, xml.Unmarshal}
+	XML = Codec{Marshal: xml.Marshal, Unmarshal: xml.Unmarshal, Name: "xml"}
 	// BSON is a codec that used the labix.org/v2/mgo/bson pacakge
-	BSON = Codec{bson.Marshal, bson.Unmarshal}
+	BSON = Codec{Marshal: bson.Marshal, Unmarshal: bson.Unmarshal, Name: "bson"}
+	// MsgPack is a codec that uses the github.com/vmihailenco/msgpack package
+	MsgPack = Codec{Marshal: msgpack.Marshal, Unmarshal: msgpack.Unmarshal, Name: "msgpack"}
+	// CBOR is a codec that uses the github.com/fxamacker/cbor package
+	CBOR = Codec{Marshal: cbor.Marshal, Unmarshal: cbor.Unmarshal, Name: "cbor"}
+	// Protobuf is a codec that uses the google.golang.org/protobuf package. It only accepts values
+	// implementing proto.Message, and returns ErrNotProtoMessage for anything else.
+	Protobuf = Codec{Marshal: protoMarshal, Unmarshal: protoUnmarshal, Name: "protobuf"}
 	// ErrTestCodec is a codec that returns errors, used for testing other packages.
-	ErrTestCodec = Codec{testMarshalErr, testUnmarshalErr}
+	ErrTestCodec = Codec{Marshal: testMarshalErr, Unmarshal: testUnmarshalErr, Name: "errtest"}
 )
 
+// ErrNotProtoMessage is returned by the Protobuf codec for values which don't implement proto.Message
+var ErrNotProtoMessage = errors.New("codec: value is not a proto.Message")
+
 func gobMarshal(v interface{}) ([]byte, error) {
 	var buf bytes.Buffer
 	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
@@ -41,6 +63,22 @@ func gobUnmarshal(data []byte, v interface{}) error {
 	return gob.NewDecoder(bytes.NewBuffer(data)).Decode(v)
 }
 
+func protoMarshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrNotProtoMessage, v)
+	}
+	return proto.Marshal(m)
+}
+
+func protoUnmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%w: %T", ErrNotProtoMessage, v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
 // testMarshalErr is a marshal func that always returns an error. Used for testing.
 func testMarshalErr(v interface{}) ([]byte, error) {
 	return nil, errors.New("test error")
@@ -50,3 +88,24 @@ func testMarshalErr(v interface{}) ([]byte, error) {
 func testUnmarshalErr(data []byte, v interface{}) error {
 	return errors.New("test error")
 }
+
+// Equal returns true if values encoded by one codec can be decoded by the other, which is only
+// known for codecs with the same name. Codecs without a name, and codecs wrapping one, are never
+// equal, so values copied between stores using them are decoded and re-encoded.
+func Equal(a, b Codec) bool {
+	return a.Name != "" && a.Name == b.Name
+}
+
+// wrappedName returns the name of a codec wrapping inner, formatting inner's name and args with
+// format. It's empty if inner has no name, since the wrapper can't be identified either. The zero
+// codec, which Auto accepts as having no legacy codec, is named "-".
+func wrappedName(inner Codec, format string, args ...interface{}) string {
+	name := inner.Name
+	if name == "" {
+		if inner.Marshal != nil || inner.Unmarshal != nil {
+			return ""
+		}
+		name = "-"
+	}
+	return fmt.Sprintf(format, append([]interface{}{name}, args...)...)
+}
@@ -1,10 +1,13 @@
 package codec
 
 import (
+	"encoding/json"
+	"errors"
 	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 // gobErrStruct is a struct which should trigger a Gob encoding error
@@ -39,3 +42,95 @@ func TestGobMarshalErr(t *testing.T) {
 	_, err := gobMarshal(&gobErrStruct{})
 	assert.Error(t, err)
 }
+
+// testStruct is used for round trips through the codecs
+type testStruct struct {
+	Foo string
+	Bar []int
+}
+
+func TestMsgPack(t *testing.T) {
+	var v testStruct
+	b, err := MsgPack.Marshal(testStruct{"foo", []int{1, 2}})
+	assert.NoError(t, err)
+	assert.NoError(t, MsgPack.Unmarshal(b, &v))
+	assert.Equal(t, testStruct{"foo", []int{1, 2}}, v)
+
+	b, err = MsgPack.Marshal(1.2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{'\xcb', '\x3f', '\xf3', '\x33', '\x33', '\x33', '\x33', '\x33', '\x33'}, b)
+
+	_, err = MsgPack.Marshal(make(chan int))
+	assert.Error(t, err)
+	assert.Error(t, MsgPack.Unmarshal([]byte{'\xc1'}, &v))
+}
+
+func TestCBOR(t *testing.T) {
+	var v testStruct
+	b, err := CBOR.Marshal(testStruct{"foo", []int{1, 2}})
+	assert.NoError(t, err)
+	assert.NoError(t, CBOR.Unmarshal(b, &v))
+	assert.Equal(t, testStruct{"foo", []int{1, 2}}, v)
+
+	b, err = CBOR.Marshal(1.2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{'\xfb', '\x3f', '\xf3', '\x33', '\x33', '\x33', '\x33', '\x33', '\x33'}, b)
+
+	_, err = CBOR.Marshal(make(chan int))
+	assert.Error(t, err)
+	assert.Error(t, CBOR.Unmarshal([]byte{'\xff'}, &v))
+}
+
+func TestProtobuf(t *testing.T) {
+	var v wrapperspb.StringValue
+	b, err := Protobuf.Marshal(wrapperspb.String("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{'\x0a', '\x03', 'f', 'o', 'o'}, b)
+	assert.NoError(t, Protobuf.Unmarshal(b, &v))
+	assert.Equal(t, "foo", v.GetValue())
+	assert.Error(t, Protobuf.Unmarshal([]byte{'\xff'}, &v))
+
+	_, err = Protobuf.Marshal(testStruct{"foo", nil})
+	assert.True(t, errors.Is(err, ErrNotProtoMessage))
+	assert.Contains(t, err.Error(), "codec.testStruct")
+	err = Protobuf.Unmarshal(b, &testStruct{})
+	assert.True(t, errors.Is(err, ErrNotProtoMessage))
+	assert.Contains(t, err.Error(), "*codec.testStruct")
+}
+
+func TestEqual(t *testing.T) {
+	assert.True(t, Equal(Gob, Gob))
+	assert.True(t, Equal(JSON, Codec{Marshal: json.Marshal, Unmarshal: json.Unmarshal, Name: "json"}))
+	assert.False(t, Equal(Gob, JSON))
+	assert.False(t, Equal(JSON, Codec{}))
+	assert.False(t, Equal(Codec{}, Codec{}))
+
+	// Codecs without a name are never equal, even to themselves
+	unnamed := Codec{Marshal: JSON.Marshal, Unmarshal: JSON.Unmarshal}
+	assert.False(t, Equal(JSON, unnamed))
+	assert.False(t, Equal(unnamed, unnamed))
+}
+
+func TestEqualWrapped(t *testing.T) {
+	kr1, kr2 := NewKeyring(), NewKeyring()
+	assert.True(t, Equal(Compress(Gob, Gzip, 0), Compress(Gob, Gzip, 0)))
+	assert.False(t, Equal(Compress(Gob, Gzip, 0), Compress(JSON, Zstd, 0)))
+	assert.False(t, Equal(Compress(Gob, Gzip, 0), Compress(Gob, Zstd, 0)))
+	assert.False(t, Equal(Compress(Gob, Gzip, 0), Compress(Gob, Gzip, 64)))
+	assert.False(t, Equal(Compress(Gob, Gzip, 0), Gob))
+	assert.True(t, Equal(Encrypt(Gob, kr1), Encrypt(Gob, kr1)))
+	assert.False(t, Equal(Encrypt(Gob, kr1), Encrypt(JSON, kr2)))
+	assert.False(t, Equal(Encrypt(Gob, kr1), Encrypt(Gob, kr2)))
+	assert.False(t, Equal(Encrypt(Gob, kr1), Encrypt(JSON, kr1)))
+	assert.True(t, Equal(Auto(GobID, JSON), Auto(GobID, JSON)))
+	assert.False(t, Equal(Auto(GobID, JSON), Auto(JSONID, JSON)))
+	assert.False(t, Equal(Auto(GobID, JSON), Auto(GobID, Codec{})))
+	assert.False(t, Equal(Compress(Encrypt(Gob, kr1), Gzip, 0), Compress(Encrypt(Gob, kr2), Gzip, 0)))
+	assert.True(t, Equal(Auto(GobID, Codec{}), Auto(GobID, Codec{})))
+
+	// Wrappers of unnamed codecs can't be told apart, even when built by the same func
+	unnamed := Codec{Marshal: JSON.Marshal, Unmarshal: JSON.Unmarshal}
+	assert.False(t, Equal(Compress(unnamed, Gzip, 0), Compress(unnamed, Gzip, 0)))
+	assert.False(t, Equal(Encrypt(unnamed, kr1), Encrypt(unnamed, kr1)))
+	assert.False(t, Equal(RawOr(unnamed), RawOr(unnamed)))
+}
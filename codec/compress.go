@@ -0,0 +1,134 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Algorithm is a compression algorithm used by Compress
+type Algorithm byte
+
+const (
+	// Gzip compresses values with the compress/gzip package
+	Gzip Algorithm = iota + 1
+	// Snappy compresses values with snappy, which is fast but compresses less
+	Snappy
+	// Zstd compresses values with zstandard, which compresses about as well as gzip but is faster
+	Zstd
+)
+
+// ErrUnknownAlgorithm is returned for values compressed with an algorithm this package doesn't know
+var ErrUnknownAlgorithm = errors.New("codec: unknown compression algorithm")
+
+// compressMagic marks a compressed value. Like expiryMagic, it can't be produced by the other codecs
+// of this package, and would need a BSON document of over 1GB to collide.
+var compressMagic = []byte("\xffZIP")
+
+// compressHeaderLen is the length of the magic bytes plus the algorithm byte
+var compressHeaderLen = len(compressMagic) + 1
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// Compress returns a codec which compresses the values encoded by inner with algo, as long as
+// they are at least minSize bytes long or start like a compressed value. Compressed values are
+// prefixed with a header naming the algorithm, so values below minSize, values written before
+// compression was enabled, and values compressed with another algorithm can all still be decoded.
+func Compress(inner Codec, algo Algorithm, minSize int) Codec {
+	return Codec{
+		Marshal: func(v interface{}) ([]byte, error) {
+			b, err := inner.Marshal(v)
+			if err != nil || (len(b) < minSize && !bytes.HasPrefix(b, compressMagic)) {
+				return b, err
+			}
+			return compress(b, algo)
+		},
+		Unmarshal: func(data []byte, v interface{}) error {
+			b, err := decompress(data)
+			if err != nil {
+				return err
+			}
+			return inner.Unmarshal(b, v)
+		},
+		Name: wrappedName(inner, "compress(%s,%d,%d)", algo, minSize),
+	}
+}
+
+// compressHeader returns the header of values compressed with algo
+func compressHeader(algo Algorithm) []byte {
+	return append(append(make([]byte, 0, compressHeaderLen), compressMagic...), byte(algo))
+}
+
+// compress compresses data with algo and prefixes it with the header
+func compress(data []byte, algo Algorithm) ([]byte, error) {
+	header := compressHeader(algo)
+	switch algo {
+	case Gzip:
+		buf := bytes.NewBuffer(header)
+		w := gzip.NewWriter(buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Snappy:
+		return append(header, snappy.Encode(nil, data)...), nil
+	case Zstd:
+		enc, _, err := zstdCoders()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(data, header), nil
+	}
+	return nil, ErrUnknownAlgorithm
+}
+
+// decompress decompresses data written by compress. Data without a header is returned as is.
+func decompress(data []byte) ([]byte, error) {
+	if len(data) < compressHeaderLen || !bytes.HasPrefix(data, compressMagic) {
+		return data, nil
+	}
+	algo, b := Algorithm(data[len(compressMagic)]), data[compressHeaderLen:]
+	switch algo {
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case Snappy:
+		return snappy.Decode(nil, b)
+	case Zstd:
+		_, dec, err := zstdCoders()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(b, nil)
+	}
+	return nil, ErrUnknownAlgorithm
+}
+
+// zstdCoders returns the zstd encoder and decoder, which are created on first use and shared since
+// EncodeAll and DecodeAll are safe for concurrent use
+func zstdCoders() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+	})
+	return zstdEncoder, zstdDecoder, zstdErr
+}
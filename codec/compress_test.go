@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompress(t *testing.T) {
+	v := testStruct{Foo: strings.Repeat("foo", 100), Bar: []int{1, 2, 3}}
+	plain, err := JSON.Marshal(v)
+	assert.NoError(t, err)
+
+	for _, algo := range []Algorithm{Gzip, Snappy, Zstd} {
+		var vv testStruct
+		c := Compress(JSON, algo, 100)
+		b, err := c.Marshal(v)
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(b, compressHeader(algo)))
+		assert.True(t, len(b) < len(plain))
+		assert.NoError(t, c.Unmarshal(b, &vv))
+		assert.Equal(t, v, vv)
+
+		// Values compressed with another algorithm are decoded using the algorithm of their header
+		vv = testStruct{}
+		assert.NoError(t, Compress(JSON, Gzip, 100).Unmarshal(b, &vv))
+		assert.Equal(t, v, vv)
+	}
+}
+
+func TestCompressMinSize(t *testing.T) {
+	var s string
+	c := Compress(JSON, Zstd, 100)
+	b, err := c.Marshal("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, `"foo"`, string(b))
+	assert.NoError(t, c.Unmarshal(b, &s))
+	assert.Equal(t, "foo", s)
+
+	// Values written before compression was enabled stay readable
+	b, err = JSON.Marshal(strings.Repeat("foo", 100))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Unmarshal(b, &s))
+	assert.Equal(t, strings.Repeat("foo", 100), s)
+
+	// Small raw values which start like a compressed value are compressed so they round trip
+	c = Compress(Raw, Zstd, 100)
+	raw := append(compressHeader(Gzip), "foo"...)
+	b, err = c.Marshal(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, compressHeader(Zstd), b[:compressHeaderLen])
+	assert.NoError(t, c.Unmarshal(b, &s))
+	assert.Equal(t, string(raw), s)
+}
+
+func TestCompressErr(t *testing.T) {
+	var s string
+	_, err := Compress(ErrTestCodec, Gzip, 0).Marshal("foo")
+	assert.Error(t, err)
+	_, err = Compress(JSON, Algorithm(0), 0).Marshal("foo")
+	assert.Equal(t, ErrUnknownAlgorithm, err)
+
+	c := Compress(JSON, Gzip, 0)
+	assert.Equal(t, ErrUnknownAlgorithm, c.Unmarshal(append(compressHeader(0), '"', '"'), &s))
+	for _, algo := range []Algorithm{Gzip, Snappy, Zstd} {
+		assert.Error(t, c.Unmarshal(append(compressHeader(algo), 'f', 'o', 'o'), &s))
+	}
+}
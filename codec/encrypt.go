@@ -0,0 +1,179 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Cipher is an authenticated encryption algorithm used by Encrypt
+type Cipher byte
+
+const (
+	// AESGCM encrypts values with AES in Galois/Counter mode. Keys must be 16, 24 or 32 bytes long.
+	AESGCM Cipher = iota + 1
+	// XChaCha20Poly1305 encrypts values with XChaCha20-Poly1305. Keys must be 32 bytes long.
+	XChaCha20Poly1305
+)
+
+var (
+	// ErrUnknownCipher is returned when adding a key for a cipher this package doesn't know
+	ErrUnknownCipher = errors.New("codec: unknown cipher")
+	// ErrNoKey is returned when encrypting with an empty keyring
+	ErrNoKey = errors.New("codec: keyring has no keys")
+	// ErrUnknownKey is returned for values encrypted with a key which isn't in the keyring
+	ErrUnknownKey = errors.New("codec: value is encrypted with an unknown key")
+	// ErrNotEncrypted is returned for values which weren't written by Encrypt
+	ErrNotEncrypted = errors.New("codec: value is not encrypted")
+)
+
+// encryptMagic marks an encrypted value. Like expiryMagic, it can't be produced by the other codecs
+// of this package, and would need a BSON document of over 1GB to collide.
+var encryptMagic = []byte("\xffENC")
+
+// encryptHeaderLen is the length of the magic bytes plus the key ID
+var encryptHeaderLen = len(encryptMagic) + 4
+
+// Keyring holds the keys used by Encrypt, identified by their IDs. New values are encrypted with the
+// primary key, which is the key added last, while the other keys are kept to decrypt older values.
+// It's safe for concurrent use, so keys can be rotated while stores are in use.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[uint32]cipher.AEAD
+	primary uint32
+}
+
+// NewKeyring returns an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[uint32]cipher.AEAD{}}
+}
+
+// Add adds a key for the cipher under the given ID and makes it the primary key. Adding a key with an
+// existing ID replaces it, so values encrypted with the previous key can't be decrypted anymore.
+func (k *Keyring) Add(id uint32, c Cipher, key []byte) error {
+	var aead cipher.AEAD
+	var err error
+	switch c {
+	case AESGCM:
+		var block cipher.Block
+		if block, err = aes.NewCipher(key); err == nil {
+			aead, err = cipher.NewGCM(block)
+		}
+	case XChaCha20Poly1305:
+		aead, err = chacha20poly1305.NewX(key)
+	default:
+		err = ErrUnknownCipher
+	}
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = aead
+	k.primary = id
+	return nil
+}
+
+// Primary returns the ID of the primary key, and false if the keyring is empty
+func (k *Keyring) Primary() (uint32, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary, len(k.keys) > 0
+}
+
+// Seal encrypts data with the primary key
+func (k *Keyring) Seal(data []byte) ([]byte, error) {
+	k.mu.RLock()
+	id, aead := k.primary, k.keys[k.primary]
+	k.mu.RUnlock()
+	if aead == nil {
+		return nil, ErrNoKey
+	}
+
+	b := make([]byte, encryptHeaderLen+aead.NonceSize(), encryptHeaderLen+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(b, encryptMagic)
+	binary.BigEndian.PutUint32(b[len(encryptMagic):], id)
+	nonce := b[encryptHeaderLen:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	// The header is authenticated too, so the key ID can't be tampered with
+	return aead.Seal(b, nonce, data, b[:encryptHeaderLen]), nil
+}
+
+// Open decrypts data written by Seal, with the key it was encrypted with
+func (k *Keyring) Open(data []byte) ([]byte, error) {
+	id, err := KeyID(data)
+	if err != nil {
+		return nil, err
+	}
+	k.mu.RLock()
+	aead := k.keys[id]
+	k.mu.RUnlock()
+	if aead == nil {
+		return nil, ErrUnknownKey
+	}
+	if len(data) < encryptHeaderLen+aead.NonceSize() {
+		return nil, ErrNotEncrypted
+	}
+	nonce := data[encryptHeaderLen : encryptHeaderLen+aead.NonceSize()]
+	return aead.Open(nil, nonce, data[encryptHeaderLen+aead.NonceSize():], data[:encryptHeaderLen])
+}
+
+// Reencrypt encrypts data written by Seal with the primary key. Data already encrypted with the
+// primary key is returned as is, and changed is false.
+func (k *Keyring) Reencrypt(data []byte) (b []byte, changed bool, err error) {
+	id, err := KeyID(data)
+	if err != nil {
+		return nil, false, err
+	}
+	if primary, _ := k.Primary(); id == primary {
+		return data, false, nil
+	}
+	if b, err = k.Open(data); err != nil {
+		return nil, false, err
+	}
+	if b, err = k.Seal(b); err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// KeyID returns the ID of the key data was encrypted with
+func KeyID(data []byte) (uint32, error) {
+	if len(data) < encryptHeaderLen || !bytes.HasPrefix(data, encryptMagic) {
+		return 0, ErrNotEncrypted
+	}
+	return binary.BigEndian.Uint32(data[len(encryptMagic):]), nil
+}
+
+// Encrypt returns a codec which encrypts the values encoded by inner with the primary key of the
+// keyring. The ID of the key is stored in front of each value, so keys can be rotated by adding a
+// new one while values encrypted with the older keys can still be decrypted. Values which aren't
+// encrypted are rejected with ErrNotEncrypted rather than decoded as is.
+func Encrypt(inner Codec, keyring *Keyring) Codec {
+	return Codec{
+		Marshal: func(v interface{}) ([]byte, error) {
+			b, err := inner.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			return keyring.Seal(b)
+		},
+		Unmarshal: func(data []byte, v interface{}) error {
+			b, err := keyring.Open(data)
+			if err != nil {
+				return err
+			}
+			return inner.Unmarshal(b, v)
+		},
+		Name: wrappedName(inner, "encrypt(%s,%p)", keyring),
+	}
+}
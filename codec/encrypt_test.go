@@ -0,0 +1,132 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testKey returns a key of n bytes filled with b
+func testKey(b byte, n int) []byte {
+	return bytes.Repeat([]byte{b}, n)
+}
+
+func TestKeyring(t *testing.T) {
+	k := NewKeyring()
+	_, ok := k.Primary()
+	assert.False(t, ok)
+	_, err := k.Seal([]byte("foo"))
+	assert.Equal(t, ErrNoKey, err)
+
+	assert.Equal(t, ErrUnknownCipher, k.Add(1, Cipher(0), testKey(1, 32)))
+	assert.Error(t, k.Add(1, AESGCM, testKey(1, 10)))
+	assert.Error(t, k.Add(1, XChaCha20Poly1305, testKey(1, 16)))
+	_, ok = k.Primary()
+	assert.False(t, ok)
+
+	assert.NoError(t, k.Add(1, AESGCM, testKey(1, 16)))
+	id, ok := k.Primary()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(1), id)
+	b, err := k.Seal([]byte("foo"))
+	assert.NoError(t, err)
+	id, err = KeyID(b)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), id)
+	assert.NotContains(t, string(b), "foo")
+
+	// Rotating keeps the older keys for decryption
+	assert.NoError(t, k.Add(2, XChaCha20Poly1305, testKey(2, 32)))
+	id, _ = k.Primary()
+	assert.Equal(t, uint32(2), id)
+	plain, err := k.Open(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", string(plain))
+
+	b2, changed, err := k.Reencrypt(b)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	id, _ = KeyID(b2)
+	assert.Equal(t, uint32(2), id)
+	plain, err = k.Open(b2)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", string(plain))
+
+	b3, changed, err := k.Reencrypt(b2)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, b2, b3)
+}
+
+func TestKeyringErr(t *testing.T) {
+	k := NewKeyring()
+	assert.NoError(t, k.Add(1, AESGCM, testKey(1, 32)))
+	b, err := k.Seal([]byte("foo"))
+	assert.NoError(t, err)
+
+	_, err = k.Open([]byte("foo"))
+	assert.Equal(t, ErrNotEncrypted, err)
+	_, err = KeyID([]byte("foo"))
+	assert.Equal(t, ErrNotEncrypted, err)
+	_, _, err = k.Reencrypt([]byte("foo"))
+	assert.Equal(t, ErrNotEncrypted, err)
+	_, err = k.Open(b[:encryptHeaderLen+1])
+	assert.Equal(t, ErrNotEncrypted, err)
+
+	// Tampering with the value or its key ID is detected
+	tampered := append([]byte(nil), b...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = k.Open(tampered)
+	assert.Error(t, err)
+	assert.NoError(t, k.Add(2, AESGCM, testKey(1, 32)))
+	tampered = append([]byte(nil), b...)
+	tampered[encryptHeaderLen-1] = 2
+	_, err = k.Open(tampered)
+	assert.Error(t, err)
+
+	// Values encrypted with a key missing from the keyring can't be decrypted
+	_, err = NewKeyring().Open(b)
+	assert.Equal(t, ErrUnknownKey, err)
+	k2 := NewKeyring()
+	assert.NoError(t, k2.Add(3, AESGCM, testKey(3, 32)))
+	_, _, err = k2.Reencrypt(b)
+	assert.Equal(t, ErrUnknownKey, err)
+}
+
+func TestEncrypt(t *testing.T) {
+	var v testStruct
+	k := NewKeyring()
+	assert.NoError(t, k.Add(1, XChaCha20Poly1305, testKey(1, 32)))
+	c := Encrypt(JSON, k)
+
+	b, err := c.Marshal(testStruct{"foo", []int{1}})
+	assert.NoError(t, err)
+	assert.NotContains(t, string(b), "foo")
+	assert.NoError(t, c.Unmarshal(b, &v))
+	assert.Equal(t, testStruct{"foo", []int{1}}, v)
+
+	// Values are encrypted with a fresh nonce every time
+	b2, err := c.Marshal(testStruct{"foo", []int{1}})
+	assert.NoError(t, err)
+	assert.NotEqual(t, b, b2)
+
+	assert.Equal(t, ErrNotEncrypted, c.Unmarshal([]byte(`{"Foo":"foo"}`), &v))
+	_, err = Encrypt(ErrTestCodec, k).Marshal("foo")
+	assert.Error(t, err)
+	_, err = Encrypt(JSON, NewKeyring()).Marshal("foo")
+	assert.Equal(t, ErrNoKey, err)
+	assert.Error(t, Encrypt(ErrTestCodec, k).Unmarshal(b, &v))
+}
+
+func TestEncryptCompress(t *testing.T) {
+	var s string
+	k := NewKeyring()
+	assert.NoError(t, k.Add(1, AESGCM, testKey(1, 32)))
+	c := Encrypt(Compress(JSON, Zstd, 0), k)
+	b, err := c.Marshal(string(testKey('a', 1000)))
+	assert.NoError(t, err)
+	assert.True(t, len(b) < 1000)
+	assert.NoError(t, c.Unmarshal(b, &s))
+	assert.Equal(t, string(testKey('a', 1000)), s)
+}
@@ -0,0 +1,130 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ID identifies a registered codec in the envelope written by Auto
+type ID byte
+
+// IDs of the codecs of this package. IDs below 64 are reserved for this package.
+const (
+	GobID ID = iota + 1
+	JSONID
+	XMLID
+	BSONID
+	MsgPackID
+	CBORID
+	ProtobufID
+	RawID
+)
+
+var (
+	// ErrUnknownCodec is returned for values written by a codec which isn't registered
+	ErrUnknownCodec = errors.New("codec: unknown codec")
+	// ErrNoEnvelope is returned by Auto for values without an envelope when there's no legacy codec
+	ErrNoEnvelope = errors.New("codec: value has no envelope")
+)
+
+// envelopeMagic marks a value which is prefixed with an envelope. Like expiryMagic, it can't be
+// produced by the other codecs of this package, and would need a BSON document of over 1GB to collide.
+var envelopeMagic = []byte("\xffCDC")
+
+// envelopeLen is the length of the magic bytes plus the codec ID
+var envelopeLen = len(envelopeMagic) + 1
+
+var registry = struct {
+	sync.RWMutex
+	codecs map[ID]Codec
+}{codecs: map[ID]Codec{}}
+
+func init() {
+	Register(GobID, Gob)
+	Register(JSONID, JSON)
+	Register(XMLID, XML)
+	Register(BSONID, BSON)
+	Register(MsgPackID, MsgPack)
+	Register(CBORID, CBOR)
+	Register(ProtobufID, Protobuf)
+	Register(RawID, Raw)
+}
+
+// Register registers c under id, so values it wrote can be decoded by Auto. Wrapping codecs like
+// Compress and Encrypt can be registered under their own ID. It panics if id is zero or already
+// registered, since values written under it would otherwise be decoded with the wrong codec.
+func Register(id ID, c Codec) {
+	registry.Lock()
+	defer registry.Unlock()
+	if id == 0 {
+		panic("codec: Register with ID 0")
+	}
+	if _, ok := registry.codecs[id]; ok {
+		panic(fmt.Sprintf("codec: Register called twice for ID %d", id))
+	}
+	registry.codecs[id] = c
+}
+
+// Lookup returns the codec registered under id
+func Lookup(id ID) (Codec, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	c, ok := registry.codecs[id]
+	return c, ok
+}
+
+// WithEnvelope prefixes the encoded data with an envelope naming the codec which encoded it
+func WithEnvelope(data []byte, id ID) []byte {
+	b := make([]byte, envelopeLen+len(data))
+	copy(b, envelopeMagic)
+	b[len(envelopeMagic)] = byte(id)
+	copy(b[envelopeLen:], data)
+	return b
+}
+
+// SplitEnvelope splits data written by WithEnvelope into the encoded value and the ID of its codec.
+// Data without an envelope is returned as is along with ID 0.
+func SplitEnvelope(data []byte) ([]byte, ID) {
+	if len(data) < envelopeLen || !bytes.HasPrefix(data, envelopeMagic) {
+		return data, 0
+	}
+	return data[envelopeLen:], ID(data[len(envelopeMagic)])
+}
+
+// Auto returns a codec which encodes values with the codec registered under def and wraps them in
+// an envelope, and decodes values written by any registered codec using their envelope. Values
+// without an envelope, written before Auto was adopted, are decoded with legacy. It may be the zero
+// Codec if there are no such values. Stores can switch codecs gradually by changing def, since
+// existing values stay readable.
+func Auto(def ID, legacy Codec) Codec {
+	return Codec{
+		Marshal: func(v interface{}) ([]byte, error) {
+			c, ok := Lookup(def)
+			if !ok {
+				return nil, ErrUnknownCodec
+			}
+			b, err := c.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			return WithEnvelope(b, def), nil
+		},
+		Unmarshal: func(data []byte, v interface{}) error {
+			b, id := SplitEnvelope(data)
+			if id == 0 {
+				if legacy.Unmarshal == nil {
+					return ErrNoEnvelope
+				}
+				return legacy.Unmarshal(b, v)
+			}
+			c, ok := Lookup(id)
+			if !ok {
+				return ErrUnknownCodec
+			}
+			return c.Unmarshal(b, v)
+		},
+		Name: wrappedName(legacy, "auto(%s,%d)", def),
+	}
+}
@@ -0,0 +1,83 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestEnvelope(t *testing.T) {
+	b := WithEnvelope([]byte("foo"), JSONID)
+	assert.Equal(t, []byte("\xffCDC\x02foo"), b)
+	data, id := SplitEnvelope(b)
+	assert.Equal(t, "foo", string(data))
+	assert.Equal(t, JSONID, id)
+
+	data, id = SplitEnvelope([]byte("foo"))
+	assert.Equal(t, "foo", string(data))
+	assert.Equal(t, ID(0), id)
+}
+
+func TestRegister(t *testing.T) {
+	for id, c := range map[ID]Codec{GobID: Gob, JSONID: JSON, XMLID: XML, BSONID: BSON, MsgPackID: MsgPack, CBORID: CBOR, ProtobufID: Protobuf, RawID: Raw} {
+		found, ok := Lookup(id)
+		assert.True(t, ok)
+		assert.True(t, Equal(c, found))
+	}
+	_, ok := Lookup(0)
+	assert.False(t, ok)
+
+	assert.Panics(t, func() { Register(0, JSON) })
+	assert.Panics(t, func() { Register(JSONID, JSON) })
+	Register(64, Compress(JSON, Gzip, 0))
+	c, ok := Lookup(64)
+	assert.True(t, ok)
+	assert.True(t, Equal(Compress(JSON, Gzip, 0), c))
+}
+
+func TestAuto(t *testing.T) {
+	var v testStruct
+	gob, err := Gob.Marshal(testStruct{"gob", nil})
+	assert.NoError(t, err)
+
+	// Values are written with the default codec, and decoded with the codec which wrote them
+	c := Auto(JSONID, Gob)
+	b, err := c.Marshal(testStruct{"json", nil})
+	assert.NoError(t, err)
+	assert.Equal(t, "\xffCDC\x02{\"Foo\":\"json\",\"Bar\":null}", string(b))
+	assert.NoError(t, c.Unmarshal(b, &v))
+	assert.Equal(t, testStruct{"json", nil}, v)
+	assert.NoError(t, c.Unmarshal(gob, &v))
+	assert.Equal(t, testStruct{"gob", nil}, v)
+
+	// Changing the default keeps existing values readable
+	c = Auto(MsgPackID, Gob)
+	mp, err := c.Marshal(testStruct{"msgpack", nil})
+	assert.NoError(t, err)
+	_, id := SplitEnvelope(mp)
+	assert.Equal(t, MsgPackID, id)
+	for _, data := range map[string][]byte{"json": b, "gob": gob, "msgpack": mp} {
+		v = testStruct{}
+		assert.NoError(t, c.Unmarshal(data, &v))
+	}
+	assert.NoError(t, c.Unmarshal(b, &v))
+	assert.Equal(t, "json", v.Foo)
+
+	b, err = Auto(ProtobufID, Codec{}).Marshal(wrapperspb.String("foo"))
+	assert.NoError(t, err)
+	var s wrapperspb.StringValue
+	assert.NoError(t, Auto(GobID, Codec{}).Unmarshal(b, &s))
+	assert.Equal(t, "foo", s.GetValue())
+}
+
+func TestAutoErr(t *testing.T) {
+	var v testStruct
+	_, err := Auto(ID(200), Gob).Marshal("foo")
+	assert.Equal(t, ErrUnknownCodec, err)
+	_, err = Auto(ProtobufID, Gob).Marshal("foo")
+	assert.Error(t, err)
+	assert.Equal(t, ErrUnknownCodec, Auto(GobID, Gob).Unmarshal(WithEnvelope([]byte("foo"), 200), &v))
+	assert.Equal(t, ErrNoEnvelope, Auto(GobID, Codec{}).Unmarshal([]byte("foo"), &v))
+	assert.Error(t, Auto(GobID, Gob).Unmarshal([]byte("foo"), &v))
+}
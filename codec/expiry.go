@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// expiryMagic marks a value which is prefixed with an expiry header. It can't be produced
+// by the Gob, JSON, XML, MsgPack, CBOR or Protobuf codecs, and would need a BSON document of
+// over 1GB to collide.
+var expiryMagic = []byte("\xffTTL")
+
+// expiryHeaderLen is the length of the magic bytes plus the expiration in unix nanoseconds
+var expiryHeaderLen = len(expiryMagic) + 8
+
+// WithExpiry prefixes the encoded data with a header holding the expiration time. If exp is
+// the zero time, data is returned without a header unless it starts like one, in which case a
+// header without an expiration is added so SplitExpiry returns the data intact. Data which
+// already has a header gets another one, so split it first to replace the expiration.
+func WithExpiry(data []byte, exp time.Time) []byte {
+	if exp.IsZero() && !bytes.HasPrefix(data, expiryMagic) {
+		return data
+	}
+	var ns int64
+	if !exp.IsZero() {
+		ns = exp.UnixNano()
+	}
+	b := make([]byte, expiryHeaderLen+len(data))
+	copy(b, expiryMagic)
+	binary.BigEndian.PutUint64(b[len(expiryMagic):], uint64(ns))
+	copy(b[expiryHeaderLen:], data)
+	return b
+}
+
+// SplitExpiry splits data written by WithExpiry into the encoded value and expiration time.
+// Data without an expiry header is returned as is along with the zero time.
+func SplitExpiry(data []byte) ([]byte, time.Time) {
+	if len(data) < expiryHeaderLen || !bytes.HasPrefix(data, expiryMagic) {
+		return data, time.Time{}
+	}
+	ns := int64(binary.BigEndian.Uint64(data[len(expiryMagic):]))
+	if ns == 0 {
+		return data[expiryHeaderLen:], time.Time{}
+	}
+	return data[expiryHeaderLen:], time.Unix(0, ns)
+}
+
+// ExpiresAt returns the expiration time for a value stored now with the given ttl. A ttl <= 0
+// returns the zero time, meaning the value never expires.
+func ExpiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// Expired returns true if the expiration time is set and has passed
+func Expired(exp time.Time) bool {
+	return !exp.IsZero() && !exp.After(time.Now())
+}
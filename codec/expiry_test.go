@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithExpiry(t *testing.T) {
+	b, err := Gob.Marshal("foobar")
+	assert.NoError(t, err)
+
+	exp := time.Now().Add(time.Minute)
+	data := WithExpiry(b, exp)
+	assert.Len(t, data, len(b)+expiryHeaderLen)
+
+	val, e := SplitExpiry(data)
+	assert.Equal(t, b, val)
+	assert.Equal(t, exp.UnixNano(), e.UnixNano())
+	assert.False(t, Expired(e))
+
+	// The expiration is replaced by splitting the header first
+	data = WithExpiry(val, exp.Add(-2*time.Minute))
+	val, e = SplitExpiry(data)
+	assert.Equal(t, b, val)
+	assert.True(t, Expired(e))
+
+	// The zero time adds no header
+	assert.Equal(t, b, WithExpiry(b, time.Time{}))
+}
+
+func TestWithExpiryMagic(t *testing.T) {
+	// Data which starts like a header is kept intact, with or without an expiration
+	b := append(append([]byte{}, expiryMagic...), "12345678foo"...)
+	data := WithExpiry(b, time.Time{})
+	assert.Len(t, data, len(b)+expiryHeaderLen)
+	val, e := SplitExpiry(data)
+	assert.Equal(t, b, val)
+	assert.True(t, e.IsZero())
+
+	exp := time.Now().Add(time.Minute)
+	val, e = SplitExpiry(WithExpiry(b, exp))
+	assert.Equal(t, b, val)
+	assert.Equal(t, exp.UnixNano(), e.UnixNano())
+}
+
+type expiryTestStruct struct {
+	Foo string
+}
+
+func TestSplitExpiryNoHeader(t *testing.T) {
+	for _, c := range []Codec{Gob, JSON, XML, BSON} {
+		b, err := c.Marshal(expiryTestStruct{"bar"})
+		assert.NoError(t, err)
+		val, exp := SplitExpiry(b)
+		assert.Equal(t, b, val)
+		assert.True(t, exp.IsZero())
+		assert.False(t, Expired(exp))
+	}
+}
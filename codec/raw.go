@@ -0,0 +1,69 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// ErrNotRaw is returned by Raw for values other than []byte, string and io.Reader, or destinations
+// other than *[]byte, *string and io.Writer
+var ErrNotRaw = errors.New("codec: value is not raw bytes")
+
+// Raw is a codec which stores []byte, string and io.Reader values verbatim, and decodes into *[]byte,
+// *string or io.Writer values, so the stored bytes can be read by other tools. Values starting with
+// one of the headers of this package are escaped by the codecs and drivers adding that header.
+var Raw = Codec{Marshal: rawMarshal, Unmarshal: rawUnmarshal, Name: "raw"}
+
+func rawMarshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return append([]byte(nil), v...), nil
+	case string:
+		return []byte(v), nil
+	case io.Reader:
+		return ioutil.ReadAll(v)
+	}
+	return nil, fmt.Errorf("%w: %T", ErrNotRaw, v)
+}
+
+func rawUnmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append([]byte(nil), data...)
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	case io.Writer:
+		_, err := v.Write(data)
+		return err
+	}
+	return fmt.Errorf("%w: %T", ErrNotRaw, v)
+}
+
+// RawOr returns a codec which stores []byte, string and io.Reader values verbatim like Raw, and
+// encodes other values with fallback. Values are decoded verbatim into *[]byte, *string and io.Writer
+// destinations, and with fallback into others, so a value must be read into the kind of type it was
+// stored from. It's meant to be the innermost codec, like Encrypt(RawOr(Gob), keyring), so codecs
+// wrapping it still apply to blobs.
+func RawOr(fallback Codec) Codec {
+	return Codec{
+		Marshal: func(v interface{}) ([]byte, error) {
+			switch v.(type) {
+			case []byte, string, io.Reader:
+				return rawMarshal(v)
+			}
+			return fallback.Marshal(v)
+		},
+		Unmarshal: func(data []byte, v interface{}) error {
+			switch v.(type) {
+			case *[]byte, *string, io.Writer:
+				return rawUnmarshal(data, v)
+			}
+			return fallback.Unmarshal(data, v)
+		},
+		Name: wrappedName(fallback, "rawor(%s)"),
+	}
+}
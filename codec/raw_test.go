@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRaw(t *testing.T) {
+	foo := []byte("foo")
+	b, err := Raw.Marshal(foo)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", string(b))
+	foo[0] = 'b'
+	assert.Equal(t, "foo", string(b))
+
+	b, err = Raw.Marshal("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", string(b))
+	b, err = Raw.Marshal(strings.NewReader("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", string(b))
+
+	var s string
+	var bs []byte
+	var buf bytes.Buffer
+	assert.NoError(t, Raw.Unmarshal(b, &s))
+	assert.Equal(t, "foo", s)
+	assert.NoError(t, Raw.Unmarshal(b, &bs))
+	assert.Equal(t, "foo", string(bs))
+	b[0] = 'b'
+	assert.Equal(t, "foo", string(bs))
+	assert.NoError(t, Raw.Unmarshal(b, &buf))
+	assert.Equal(t, "boo", buf.String())
+}
+
+func TestRawErr(t *testing.T) {
+	_, err := Raw.Marshal(1)
+	assert.True(t, errors.Is(err, ErrNotRaw))
+	assert.Contains(t, err.Error(), "int")
+	err = Raw.Unmarshal([]byte("1"), new(int))
+	assert.True(t, errors.Is(err, ErrNotRaw))
+	assert.Contains(t, err.Error(), "*int")
+}
+
+func TestRawOr(t *testing.T) {
+	var s string
+	var v testStruct
+	var buf bytes.Buffer
+	c := RawOr(JSON)
+	b, err := c.Marshal("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", string(b))
+	assert.NoError(t, c.Unmarshal(b, &s))
+	assert.Equal(t, "foo", s)
+	b, err = c.Marshal(strings.NewReader("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", string(b))
+	assert.NoError(t, c.Unmarshal(b, &buf))
+	assert.Equal(t, "foo", buf.String())
+
+	b, err = c.Marshal(testStruct{"foo", nil})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Foo":"foo","Bar":null}`, string(b))
+	assert.NoError(t, c.Unmarshal(b, &v))
+	assert.Equal(t, testStruct{"foo", nil}, v)
+
+	c = RawOr(ErrTestCodec)
+	_, err = c.Marshal(1)
+	assert.Error(t, err)
+	b, err = c.Marshal([]byte("foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", string(b))
+	assert.Error(t, c.Unmarshal(b, &v))
+}
+
+// TestWrappedRaw checks codecs wrapping RawOr or a codec like Gob still apply to blobs
+func TestWrappedRaw(t *testing.T) {
+	kr := NewKeyring()
+	assert.NoError(t, kr.Add(1, AESGCM, bytes.Repeat([]byte{1}, 32)))
+	for _, c := range []struct {
+		Codec
+		magic []byte
+	}{
+		{Encrypt(Gob, kr), encryptMagic},
+		{Encrypt(RawOr(Gob), kr), encryptMagic},
+		{Compress(Gob, Gzip, 0), compressMagic},
+		{Compress(RawOr(Gob), Gzip, 0), compressMagic},
+		{Auto(GobID, Codec{}), envelopeMagic},
+	} {
+		for _, v := range []interface{}{"TOPSECRET", []byte("TOPSECRET")} {
+			b, err := c.Marshal(v)
+			assert.NoError(t, err)
+			assert.True(t, bytes.HasPrefix(b, c.magic), "%s: %T stored as is", c.Name, v)
+			if bytes.Equal(c.magic, encryptMagic) {
+				assert.False(t, bytes.Contains(b, []byte("TOPSECRET")), "%s: %T stored in plaintext", c.Name, v)
+			}
+			var s string
+			var bs []byte
+			if _, ok := v.(string); ok {
+				assert.NoError(t, c.Unmarshal(b, &s))
+				assert.Equal(t, "TOPSECRET", s)
+			} else {
+				assert.NoError(t, c.Unmarshal(b, &bs))
+				assert.Equal(t, []byte("TOPSECRET"), bs)
+			}
+		}
+	}
+}
@@ -0,0 +1,369 @@
+// Package dedup implements a key/value store wrapper which stores identical values only once. Values
+// are stored under the digest of their encoding along with a reference count, and keys map to the
+// digest of their value.
+package dedup
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"lukechampine.com/blake3"
+)
+
+// Hash is the hash function used to compute the digest of values
+type Hash byte
+
+// Hash functions which can be used to compute digests. SHA256 is the default.
+const (
+	SHA256 Hash = iota
+	BLAKE3
+)
+
+// DefaultPrefix is the prefix of the keys blobs are stored under if Options.Prefix isn't set
+const DefaultPrefix = "blob."
+
+// countLen is the length of the reference count preceding the blob in its record
+const countLen = 8
+
+var (
+	// ErrNotAtomic is returned by New for stores which can't update reference counts atomically
+	ErrNotAtomic = errors.New("dedup: store must implement kv.CAS or kv.Transactional")
+	// ErrUnknownHash is returned by New for an unknown Options.Hash
+	ErrUnknownHash = errors.New("dedup: unknown hash")
+	// ErrMissingBlob is returned when the blob a key refers to is missing from the underlying store
+	ErrMissingBlob = errors.New("dedup: blob is missing")
+	// ErrInvalidValue is returned for values under a key which weren't written by a dedup Store
+	ErrInvalidValue = errors.New("dedup: invalid value")
+
+	// ensure struct implements the kv.Store interface
+	_ kv.Store = (*Store)(nil)
+)
+
+// Options configures a dedup Store
+type Options struct {
+	// Hash computes the digest of encoded values. Defaults to SHA256.
+	Hash Hash
+
+	// Prefix is prepended to the digest to form the key of a blob. It must not be used by other
+	// keys of the store. Defaults to DefaultPrefix.
+	Prefix string
+}
+
+// entry is stored under keys and blob keys. It's a struct rather than a []byte so drivers which
+// only store structs, such as the App Engine datastore, can hold it too. Data isn't indexed since
+// the datastore limits indexed values to 1500 bytes.
+type entry struct {
+	Data []byte `datastore:",noindex"`
+}
+
+// Store is a key/value store wrapping another store which stores each unique value once. A value
+// is stored as a blob under the digest of its encoding, prefixed with the number of keys referring
+// to it, and keys hold the key of their blob. Deleting or replacing the last key referring to a
+// blob deletes the blob. The underlying store only ever receives values of an unexported struct
+// type holding the bytes.
+//
+// Reference counts are kept correct under concurrent use with transactions if the underlying store
+// implements kv.Transactional, and with compare-and-swap retry loops if it only implements kv.CAS.
+// Without transactions a blob is referenced before a key is pointed at it and released afterwards,
+// so a failure in between leaves a blob which is never deleted rather than a key without a blob.
+// Keys must not expire, since the blobs they refer to would never be released.
+type Store struct {
+	s      kv.Store
+	tx     kv.Transactional
+	cas    kv.CAS
+	hash   Hash
+	prefix string
+	codec  codec.Codec
+}
+
+// New returns a store deduplicating the values stored in s, which must implement kv.CAS or
+// kv.Transactional. Transactions are used if s implements both. Values are encoded before they
+// are hashed with the codec set with kv.WithCodec, which defaults to codec.Gob.
+func New(s kv.Store, options Options, opts ...kv.Option) (*Store, error) {
+	st := &Store{s: s, hash: options.Hash, prefix: options.Prefix, codec: kv.NewOptions(opts...).CodecOr(codec.Gob)}
+	st.tx, _ = s.(kv.Transactional)
+	if st.tx == nil {
+		st.cas, _ = s.(kv.CAS)
+		if st.cas == nil {
+			return nil, ErrNotAtomic
+		}
+	}
+	if st.hash != SHA256 && st.hash != BLAKE3 {
+		return nil, ErrUnknownHash
+	}
+	if st.prefix == "" {
+		st.prefix = DefaultPrefix
+	}
+	return st, nil
+}
+
+// blobKey returns the key of the blob holding the encoded value b
+func (s *Store) blobKey(b []byte) string {
+	if s.hash == BLAKE3 {
+		sum := blake3.Sum256(b)
+		return s.prefix + "blake3." + hex.EncodeToString(sum[:])
+	}
+	sum := sha256.Sum256(b)
+	return s.prefix + "sha256." + hex.EncodeToString(sum[:])
+}
+
+// ref returns the blob key held by a key, or an empty string if the value isn't a blob key
+func (s *Store) ref(b []byte) string {
+	if ref := string(b); strings.HasPrefix(ref, s.prefix) {
+		return ref
+	}
+	return ""
+}
+
+// newRecord returns a blob record with the reference count n
+func newRecord(n uint64, blob []byte) *entry {
+	rec := make([]byte, countLen+len(blob))
+	binary.BigEndian.PutUint64(rec, n)
+	copy(rec[countLen:], blob)
+	return &entry{Data: rec}
+}
+
+// newRef returns the value of a key referring to the blob key bk
+func newRef(bk string) *entry {
+	return &entry{Data: []byte(bk)}
+}
+
+// adjust returns the record with its reference count changed by delta, or nil if it drops to zero
+func adjust(rec *entry, delta int) (*entry, error) {
+	if len(rec.Data) < countLen {
+		return nil, ErrInvalidValue
+	}
+	n := binary.BigEndian.Uint64(rec.Data)
+	if delta < 0 && n <= uint64(-delta) {
+		return nil, nil
+	}
+	return newRecord(n+uint64(delta), rec.Data[countLen:]), nil
+}
+
+func isNotFound(err error) bool {
+	return err == kv.ErrNotFound || err == kv.ErrCacheMiss
+}
+
+// Set implements the "kv.Store".Set() interface
+func (s *Store) Set(key string, value interface{}) error {
+	b, err := s.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	bk := s.blobKey(b)
+	if s.tx != nil {
+		return s.tx.Update(func(tx kv.Tx) error {
+			var cur entry
+			if err := tx.Get(key, &cur); err != nil && !isNotFound(err) {
+				return err
+			}
+			old := s.ref(cur.Data)
+			if old == bk {
+				return nil
+			}
+			if err := txAdjust(tx, bk, b, 1); err != nil {
+				return err
+			}
+			if err := tx.Set(key, newRef(bk)); err != nil {
+				return err
+			}
+			if old != "" {
+				return txAdjust(tx, old, nil, -1)
+			}
+			return nil
+		})
+	}
+
+	if err := s.casAdjust(bk, b, 1); err != nil {
+		return err
+	}
+	old, err := s.casSwap(key, bk)
+	if err != nil {
+		s.casAdjust(bk, nil, -1)
+		return err
+	}
+	if old != "" {
+		return s.casAdjust(old, nil, -1)
+	}
+	return nil
+}
+
+// txAdjust changes the reference count of the blob within a transaction. A missing blob is created
+// with the value blob when incrementing, and ignored when decrementing.
+func txAdjust(tx kv.Tx, bk string, blob []byte, delta int) error {
+	rec := &entry{}
+	if err := tx.Get(bk, rec); err != nil {
+		if !isNotFound(err) {
+			return err
+		}
+		if delta < 0 {
+			return nil
+		}
+		return tx.Set(bk, newRecord(uint64(delta), blob))
+	}
+	rec, err := adjust(rec, delta)
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		return tx.Del(bk)
+	}
+	return tx.Set(bk, rec)
+}
+
+// casAdjust changes the reference count of the blob with compare-and-swap operations, retrying
+// until no other write intervenes. A missing blob is handled like txAdjust does.
+func (s *Store) casAdjust(bk string, blob []byte, delta int) error {
+	for {
+		rec := &entry{}
+		ver, err := s.cas.GetWithVersion(bk, rec)
+		switch {
+		case isNotFound(err):
+			if delta < 0 {
+				return nil
+			}
+			_, err = s.cas.SetIfNotExists(bk, newRecord(uint64(delta), blob))
+		case err != nil:
+			return err
+		default:
+			if rec, err = adjust(rec, delta); err != nil {
+				return err
+			}
+			if rec == nil {
+				err = s.cas.DelIfVersion(bk, ver)
+			} else {
+				_, err = s.cas.SetIfVersion(bk, rec, ver)
+			}
+		}
+		if err != kv.ErrVersionMismatch {
+			return err
+		}
+	}
+}
+
+// casSwap points the key at the blob with compare-and-swap operations, and returns the blob it
+// referred to before, if any
+func (s *Store) casSwap(key, bk string) (string, error) {
+	for {
+		var cur entry
+		ver, err := s.cas.GetWithVersion(key, &cur)
+		switch {
+		case isNotFound(err):
+			_, err = s.cas.SetIfNotExists(key, newRef(bk))
+		case err != nil:
+			return "", err
+		default:
+			_, err = s.cas.SetIfVersion(key, newRef(bk), ver)
+		}
+		if err == nil {
+			return s.ref(cur.Data), nil
+		}
+		if err != kv.ErrVersionMismatch {
+			return "", err
+		}
+	}
+}
+
+// Get implements the "kv.Store".Get() interface
+func (s *Store) Get(key string, dstVal interface{}) error {
+	var rec entry
+	if s.tx != nil {
+		err := s.tx.View(func(tx kv.Tx) error {
+			var cur entry
+			if err := tx.Get(key, &cur); err != nil {
+				return err
+			}
+			bk := s.ref(cur.Data)
+			if bk == "" {
+				return ErrInvalidValue
+			}
+			if err := tx.Get(bk, &rec); err != nil {
+				if isNotFound(err) {
+					return ErrMissingBlob
+				}
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		// The blob may be released by a concurrent write between reading the key and the blob, so
+		// it's only missing if the key still refers to it
+		var prev string
+		for {
+			var cur entry
+			if err := s.s.Get(key, &cur); err != nil {
+				return err
+			}
+			bk := s.ref(cur.Data)
+			if bk == "" {
+				return ErrInvalidValue
+			}
+			if bk == prev {
+				return ErrMissingBlob
+			}
+			err := s.s.Get(bk, &rec)
+			if err == nil {
+				break
+			}
+			if !isNotFound(err) {
+				return err
+			}
+			prev = bk
+		}
+	}
+	if len(rec.Data) < countLen {
+		return ErrInvalidValue
+	}
+	return s.codec.Unmarshal(rec.Data[countLen:], dstVal)
+}
+
+// Del implements the "kv.Store".Del() interface, deleting the blob of the value if no other key
+// refers to it
+func (s *Store) Del(key string) error {
+	if s.tx != nil {
+		return s.tx.Update(func(tx kv.Tx) error {
+			var cur entry
+			if err := tx.Get(key, &cur); err != nil {
+				return err
+			}
+			if err := tx.Del(key); err != nil {
+				return err
+			}
+			if bk := s.ref(cur.Data); bk != "" {
+				return txAdjust(tx, bk, nil, -1)
+			}
+			return nil
+		})
+	}
+
+	for {
+		var cur entry
+		ver, err := s.cas.GetWithVersion(key, &cur)
+		if err != nil {
+			return err
+		}
+		if err := s.cas.DelIfVersion(key, ver); err != nil {
+			if err == kv.ErrVersionMismatch {
+				continue
+			}
+			return err
+		}
+		if bk := s.ref(cur.Data); bk != "" {
+			return s.casAdjust(bk, nil, -1)
+		}
+		return nil
+	}
+}
+
+// Store returns the underlying store
+func (s *Store) Store() kv.Store {
+	return s.s
+}
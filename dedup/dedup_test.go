@@ -0,0 +1,296 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/drivers/leveldb"
+	"github.com/bradberger/gokv/drivers/memory"
+	"github.com/bradberger/gokv/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+type testStruct struct {
+	Foo string
+}
+
+// casStore hides the transactions of a store so the compare-and-swap path is used
+type casStore struct {
+	kv.Store
+	kv.CAS
+}
+
+var errInvalidEntity = errors.New("invalid entity type")
+
+// entityStore is a store which, like the App Engine datastore, only stores struct pointers
+type entityStore struct {
+	*leveldb.DB
+}
+
+func (s entityStore) Set(key string, v interface{}) error {
+	if err := structPtr(v); err != nil {
+		return err
+	}
+	return s.DB.Set(key, v)
+}
+
+func (s entityStore) Get(key string, dstVal interface{}) error {
+	if err := structPtr(dstVal); err != nil {
+		return err
+	}
+	return s.DB.Get(key, dstVal)
+}
+
+func (s entityStore) GetWithVersion(key string, dstVal interface{}) (uint64, error) {
+	if err := structPtr(dstVal); err != nil {
+		return 0, err
+	}
+	return s.DB.GetWithVersion(key, dstVal)
+}
+
+func (s entityStore) SetIfVersion(key string, v interface{}, version uint64) (uint64, error) {
+	if err := structPtr(v); err != nil {
+		return 0, err
+	}
+	return s.DB.SetIfVersion(key, v, version)
+}
+
+func (s entityStore) SetIfNotExists(key string, v interface{}) (uint64, error) {
+	if err := structPtr(v); err != nil {
+		return 0, err
+	}
+	return s.DB.SetIfNotExists(key, v)
+}
+
+func (s entityStore) Update(fn func(tx kv.Tx) error) error {
+	return s.DB.Update(func(tx kv.Tx) error { return fn(entityTx{tx}) })
+}
+
+func (s entityStore) View(fn func(tx kv.Tx) error) error {
+	return s.DB.View(func(tx kv.Tx) error { return fn(entityTx{tx}) })
+}
+
+// entityTx is a transaction of an entityStore
+type entityTx struct {
+	kv.Tx
+}
+
+func (tx entityTx) Set(key string, v interface{}) error {
+	if err := structPtr(v); err != nil {
+		return err
+	}
+	return tx.Tx.Set(key, v)
+}
+
+func (tx entityTx) Get(key string, dstVal interface{}) error {
+	if err := structPtr(dstVal); err != nil {
+		return err
+	}
+	return tx.Tx.Get(key, dstVal)
+}
+
+func structPtr(v interface{}) error {
+	if rv := reflect.ValueOf(v); rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errInvalidEntity
+	}
+	return nil
+}
+
+// newTestDB returns a leveldb store and a function removing it
+func newTestDB(t *testing.T) (*leveldb.DB, func()) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := leveldb.New(dir+"/db", nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// stores runs fn with a dedup Store using transactions and one using compare-and-swap, over a
+// store taking any value and one only taking struct pointers
+func stores(t *testing.T, options Options, opts []kv.Option, fn func(t *testing.T, s *Store, db *leveldb.DB)) {
+	for name, wrap := range map[string]func(db *leveldb.DB) kv.Store{
+		"tx":         func(db *leveldb.DB) kv.Store { return db },
+		"cas":        func(db *leveldb.DB) kv.Store { return casStore{db, db} },
+		"entity-tx":  func(db *leveldb.DB) kv.Store { return entityStore{db} },
+		"entity-cas": func(db *leveldb.DB) kv.Store { return casStore{entityStore{db}, entityStore{db}} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, done := newTestDB(t)
+			defer done()
+			s, err := New(wrap(db), options, opts...)
+			assert.NoError(t, err)
+			assert.Equal(t, strings.HasSuffix(name, "tx"), s.tx != nil)
+			fn(t, s, db)
+		})
+	}
+}
+
+// prefixed returns the keys of the store with the prefix
+func prefixed(db *leveldb.DB, prefix string) []string {
+	keys := []string{}
+	for _, key := range db.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// refs returns the reference counts of all blobs
+func refs(t *testing.T, db *leveldb.DB) map[string]uint64 {
+	counts := map[string]uint64{}
+	for _, key := range prefixed(db, DefaultPrefix) {
+		var rec entry
+		assert.NoError(t, db.Get(key, &rec))
+		counts[key] = binary.BigEndian.Uint64(rec.Data)
+	}
+	return counts
+}
+
+// blobKey returns the key of the blob holding the value
+func blobKey(t *testing.T, s *Store, v interface{}) string {
+	b, err := s.codec.Marshal(v)
+	assert.NoError(t, err)
+	return s.blobKey(b)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(memory.New(memory.Options{}), Options{})
+	assert.Equal(t, ErrNotAtomic, err)
+
+	db, done := newTestDB(t)
+	defer done()
+	s, err := New(db, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultPrefix, s.prefix)
+	assert.True(t, codec.Equal(codec.Gob, s.codec))
+	assert.Equal(t, db, s.Store())
+	_, err = New(db, Options{Hash: 9})
+	assert.Equal(t, ErrUnknownHash, err)
+}
+
+func TestBlobKey(t *testing.T) {
+	s := &Store{prefix: DefaultPrefix}
+	assert.Equal(t, "blob.sha256.2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", s.blobKey([]byte("foo")))
+	s.hash = BLAKE3
+	assert.Equal(t, "blob.blake3.04e0bb39f30b1a3feb89f536c93be15055482df748674b00d26e5a75777702e9", s.blobKey([]byte("foo")))
+}
+
+func TestDedup(t *testing.T) {
+	stores(t, Options{}, []kv.Option{kv.WithCodec(codec.JSON)}, func(t *testing.T, s *Store, db *leveldb.DB) {
+		var v testStruct
+		assert.NoError(t, s.Set("foo", testStruct{"bar"}))
+		assert.NoError(t, s.Set("bar", testStruct{"bar"}))
+		assert.NoError(t, s.Set("baz", "baz"))
+		bk := s.blobKey([]byte(`{"Foo":"bar"}`))
+		assert.Equal(t, map[string]uint64{bk: 2, s.blobKey([]byte(`"baz"`)): 1}, refs(t, db))
+		assert.NoError(t, s.Get("foo", &v))
+		assert.Equal(t, testStruct{"bar"}, v)
+		assert.NoError(t, s.Get("bar", &v))
+		assert.Equal(t, testStruct{"bar"}, v)
+
+		// Setting the same value again keeps the count
+		assert.NoError(t, s.Set("foo", testStruct{"bar"}))
+		assert.Equal(t, uint64(2), refs(t, db)[bk])
+
+		// Replacing and deleting values releases their blobs
+		assert.NoError(t, s.Set("foo", "baz"))
+		assert.Equal(t, map[string]uint64{bk: 1, s.blobKey([]byte(`"baz"`)): 2}, refs(t, db))
+		assert.NoError(t, s.Del("bar"))
+		assert.Equal(t, map[string]uint64{s.blobKey([]byte(`"baz"`)): 2}, refs(t, db))
+		assert.Equal(t, kv.ErrNotFound, s.Get("bar", &v))
+		assert.Equal(t, kv.ErrNotFound, s.Del("bar"))
+		assert.NoError(t, s.Del("foo"))
+		assert.NoError(t, s.Del("baz"))
+		assert.Len(t, db.Keys(), 0)
+	})
+}
+
+func TestBLAKE3(t *testing.T) {
+	stores(t, Options{Hash: BLAKE3, Prefix: "dedup-"}, nil, func(t *testing.T, s *Store, db *leveldb.DB) {
+		var v string
+		assert.NoError(t, s.Set("foo", "bar"))
+		assert.Len(t, prefixed(db, "dedup-blake3."), 1)
+		assert.NoError(t, s.Get("foo", &v))
+		assert.Equal(t, "bar", v)
+	})
+}
+
+func TestSetErr(t *testing.T) {
+	stores(t, Options{}, []kv.Option{kv.WithCodec(codec.ErrTestCodec)}, func(t *testing.T, s *Store, db *leveldb.DB) {
+		assert.Error(t, s.Set("foo", testStruct{"bar"}))
+		assert.Len(t, db.Keys(), 0)
+	})
+}
+
+func TestInvalid(t *testing.T) {
+	stores(t, Options{}, nil, func(t *testing.T, s *Store, db *leveldb.DB) {
+		var v string
+		assert.NoError(t, db.Set("foo", &entry{Data: []byte("foo")}))
+		assert.Equal(t, ErrInvalidValue, s.Get("foo", &v))
+
+		// Invalid values can be replaced and deleted
+		assert.NoError(t, s.Set("foo", "bar"))
+		assert.NoError(t, s.Get("foo", &v))
+		assert.Equal(t, "bar", v)
+		assert.NoError(t, db.Set("foo", &entry{Data: []byte("foo")}))
+		assert.NoError(t, s.Del("foo"))
+		assert.Len(t, refs(t, db), 1)
+
+		// Blobs deleted behind the store's back are missing
+		assert.NoError(t, s.Set("bar", "bar"))
+		assert.NoError(t, db.Del(blobKey(t, s, "bar")))
+		assert.Equal(t, ErrMissingBlob, s.Get("bar", &v))
+	})
+}
+
+func TestConcurrent(t *testing.T) {
+	stores(t, Options{}, nil, func(t *testing.T, s *Store, db *leveldb.DB) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					key := fmt.Sprintf("key-%d", j%4)
+					assert.NoError(t, s.Set(key, strings.Repeat("x", (i+j)%3)))
+					if j%5 == 4 {
+						if err := s.Del(key); err != nil {
+							assert.Equal(t, kv.ErrNotFound, err)
+						}
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// Each remaining key holds one reference
+		want := map[string]uint64{}
+		for j := 0; j < 4; j++ {
+			var v string
+			key := fmt.Sprintf("key-%d", j)
+			if err := s.Get(key, &v); err == nil {
+				want[blobKey(t, s, v)]++
+			} else {
+				assert.Equal(t, kv.ErrNotFound, err)
+			}
+		}
+		assert.Equal(t, want, refs(t, db))
+	})
+}
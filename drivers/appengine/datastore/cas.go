@@ -0,0 +1,122 @@
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/bradberger/gokv/kv"
+	"golang.org/x/net/context"
+	ae "google.golang.org/appengine/datastore"
+)
+
+// propsVersion returns a "kv.CAS" version for the properties of an entity. Properties are sorted
+// by name first, since the datastore doesn't guarantee the order they're loaded in.
+func propsVersion(props ae.PropertyList) uint64 {
+	sorted := make(ae.PropertyList, len(props))
+	copy(sorted, props)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	var buf bytes.Buffer
+	for _, p := range sorted {
+		fmt.Fprintf(&buf, "%s\x00%t\x00%v\x00", p.Name, p.Multiple, p.Value)
+	}
+	return kv.Version(buf.Bytes())
+}
+
+// saveProps returns the properties the value would be saved with
+func saveProps(value interface{}) (ae.PropertyList, error) {
+	if pls, ok := value.(ae.PropertyLoadSaver); ok {
+		return pls.Save()
+	}
+	return ae.SaveStruct(value)
+}
+
+// loadProps loads the properties into dstVal
+func loadProps(dstVal interface{}, props ae.PropertyList) error {
+	if pls, ok := dstVal.(ae.PropertyLoadSaver); ok {
+		return pls.Load(props)
+	}
+	return ae.LoadStruct(dstVal, props)
+}
+
+// GetWithVersion implements the "kv.CAS".GetWithVersion() interface. The version is a hash of
+// the entity's properties. Missing entities return kv.ErrNotFound.
+func (e *Entity) GetWithVersion(key string, dstVal interface{}) (uint64, error) {
+	props, exists, err := e.current(e.Context, key)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, kv.ErrNotFound
+	}
+	return propsVersion(props), loadProps(dstVal, props)
+}
+
+// SetIfVersion implements the "kv.CAS".SetIfVersion() interface within a datastore transaction
+func (e *Entity) SetIfVersion(key string, value interface{}, version uint64) (uint64, error) {
+	return e.setIf(key, value, func(cur ae.PropertyList, exists bool) bool {
+		return exists && propsVersion(cur) == version
+	})
+}
+
+// SetIfNotExists implements the "kv.CAS".SetIfNotExists() interface within a datastore transaction
+func (e *Entity) SetIfNotExists(key string, value interface{}) (uint64, error) {
+	return e.setIf(key, value, func(cur ae.PropertyList, exists bool) bool {
+		return !exists
+	})
+}
+
+// setIf puts the value if ok returns true for the current properties of the entity
+func (e *Entity) setIf(key string, value interface{}, ok func(cur ae.PropertyList, exists bool) bool) (uint64, error) {
+	props, err := saveProps(value)
+	if err != nil {
+		return 0, err
+	}
+	err = ae.RunInTransaction(e.Context, func(tc context.Context) error {
+		cur, exists, err := e.current(tc, key)
+		if err != nil {
+			return err
+		}
+		if !ok(cur, exists) {
+			return kv.ErrVersionMismatch
+		}
+		_, err = ae.Put(tc, e.key(tc, key), &props)
+		return err
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+	hub(e.Entity).Put(key, nil)
+	return propsVersion(props), nil
+}
+
+// DelIfVersion implements the "kv.CAS".DelIfVersion() interface within a datastore transaction
+func (e *Entity) DelIfVersion(key string, version uint64) error {
+	err := ae.RunInTransaction(e.Context, func(tc context.Context) error {
+		cur, exists, err := e.current(tc, key)
+		if err != nil {
+			return err
+		}
+		if !exists || propsVersion(cur) != version {
+			return kv.ErrVersionMismatch
+		}
+		return ae.Delete(tc, e.key(tc, key))
+	}, nil)
+	if err != nil {
+		return err
+	}
+	hub(e.Entity).Delete(key)
+	return nil
+}
+
+// current loads the properties of the entity, if it exists
+func (e *Entity) current(ctx context.Context, key string) (props ae.PropertyList, exists bool, err error) {
+	err = ae.Get(ctx, e.key(ctx, key), &props)
+	switch err {
+	case nil:
+		return props, true, nil
+	case ae.ErrNoSuchEntity:
+		return nil, false, nil
+	}
+	return nil, false, err
+}
@@ -0,0 +1,34 @@
+package datastore
+
+import (
+	"testing"
+
+	"github.com/bradberger/gokv/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCAS(t *testing.T) {
+	var v testStruct
+	e := New(ctx, "CAS")
+	_, err := e.GetWithVersion("foo", &v)
+	assert.Equal(t, kv.ErrNotFound, err)
+
+	ver, err := e.SetIfNotExists("foo", &testStruct{"foo"})
+	assert.NoError(t, err)
+	_, err = e.SetIfNotExists("foo", &testStruct{"bar"})
+	assert.Equal(t, kv.ErrVersionMismatch, err)
+
+	got, err := e.GetWithVersion("foo", &v)
+	assert.NoError(t, err)
+	assert.Equal(t, ver, got)
+	assert.Equal(t, "foo", v.Foo)
+
+	newVer, err := e.SetIfVersion("foo", &testStruct{"bar"}, ver)
+	assert.NoError(t, err)
+	_, err = e.SetIfVersion("foo", &testStruct{"baz"}, ver)
+	assert.Equal(t, kv.ErrVersionMismatch, err)
+	assert.Equal(t, kv.ErrVersionMismatch, e.DelIfVersion("foo", ver))
+	assert.NoError(t, e.DelIfVersion("foo", newVer))
+	_, err = e.GetWithVersion("foo", &v)
+	assert.Equal(t, kv.ErrNotFound, err)
+}
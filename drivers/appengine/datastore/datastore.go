@@ -3,14 +3,25 @@ package datastore
 import (
 	"github.com/bradberger/gokv/kv"
 	"golang.org/x/net/context"
+	"google.golang.org/appengine"
 	ae "google.golang.org/appengine/datastore"
 )
 
 var (
 	// ensure Entity struct implements the kv.Store interface
-	_ kv.Store = (*Entity)(nil)
+	_ kv.Store         = (*Entity)(nil)
+	_ kv.ContextStore  = (*Entity)(nil)
+	_ kv.Batcher       = (*Entity)(nil)
+	_ kv.Datastore     = (*Entity)(nil)
+	_ kv.Clearer       = (*Entity)(nil)
+	_ kv.CAS           = (*Entity)(nil)
+	_ kv.Transactional = (*Entity)(nil)
+	_ kv.Watcher       = (*Entity)(nil)
 )
 
+// maxBatchSize is the maximum number of entities the datastore accepts in a single multi call
+const maxBatchSize = 500
+
 // Entity represents the entity being stored.
 type Entity struct {
 	Context context.Context
@@ -30,21 +41,181 @@ func (e *Entity) WithContext(ctx context.Context) *Entity {
 
 // Set implements the "kv.Cache".Set() interface
 func (e *Entity) Set(key string, value interface{}) (err error) {
-	_, err = ae.Put(e.Context, e.Key(key), value)
-	return
+	return e.SetContext(e.Context, key, value)
 }
 
 // Get implements the "kv.Cache".Get() interface
 func (e *Entity) Get(key string, dstVal interface{}) error {
-	return ae.Get(e.Context, e.Key(key), dstVal)
+	return e.GetContext(e.Context, key, dstVal)
 }
 
 // Del implements the "kv.Cache".Del() interface
 func (e *Entity) Del(key string) error {
-	return ae.Delete(e.Context, e.Key(key))
+	return e.DelContext(e.Context, key)
+}
+
+// SetContext implements the "kv.ContextStore".SetContext() interface, using ctx
+// instead of the internal context.
+func (e *Entity) SetContext(ctx context.Context, key string, value interface{}) (err error) {
+	if _, err = ae.Put(ctx, e.key(ctx, key), value); err != nil {
+		return
+	}
+	hub(e.Entity).Put(key, nil)
+	return
+}
+
+// GetContext implements the "kv.ContextStore".GetContext() interface, using ctx
+// instead of the internal context. Missing entities return kv.ErrNotFound.
+func (e *Entity) GetContext(ctx context.Context, key string, dstVal interface{}) error {
+	if err := ae.Get(ctx, e.key(ctx, key), dstVal); err != ae.ErrNoSuchEntity {
+		return err
+	}
+	return kv.ErrNotFound
+}
+
+// DelContext implements the "kv.ContextStore".DelContext() interface, using ctx
+// instead of the internal context.
+func (e *Entity) DelContext(ctx context.Context, key string) error {
+	if err := ae.Delete(ctx, e.key(ctx, key)); err != nil {
+		return err
+	}
+	hub(e.Entity).Delete(key)
+	return nil
+}
+
+// SetMulti implements the "kv.Batcher".SetMulti() interface using ae.PutMulti(). Values must be
+// struct pointers or implement ae.PropertyLoadSaver.
+func (e *Entity) SetMulti(items map[string]interface{}) error {
+	keys := make([]*ae.Key, 0, len(items))
+	vals := make([]interface{}, 0, len(items))
+	for key, val := range items {
+		keys = append(keys, e.Key(key))
+		vals = append(vals, val)
+	}
+	for i := 0; i < len(keys); i += maxBatchSize {
+		j := batchEnd(i, len(keys))
+		if _, err := ae.PutMulti(e.Context, keys[i:j], vals[i:j]); err != nil {
+			return err
+		}
+		for _, key := range keys[i:j] {
+			hub(e.Entity).Put(key.StringID(), nil)
+		}
+	}
+	return nil
+}
+
+// GetMulti implements the "kv.Batcher".GetMulti() interface using ae.GetMulti(). The elements of
+// dst must be structs, struct pointers or implement ae.PropertyLoadSaver.
+func (e *Entity) GetMulti(keys []string, dst interface{}) error {
+	props := make([]ae.PropertyList, len(keys))
+	errs := make(appengine.MultiError, len(keys))
+	for i := 0; i < len(keys); i += maxBatchSize {
+		j := batchEnd(i, len(keys))
+		dsKeys := make([]*ae.Key, 0, j-i)
+		for _, key := range keys[i:j] {
+			dsKeys = append(dsKeys, e.Key(key))
+		}
+		err := ae.GetMulti(e.Context, dsKeys, props[i:j])
+		if me, ok := err.(appengine.MultiError); ok {
+			copy(errs[i:j], me)
+		} else if err != nil {
+			return err
+		}
+	}
+	return kv.FillMulti(keys, dst, func(i int, key string, dstVal interface{}) error {
+		switch errs[i] {
+		case nil:
+		case ae.ErrNoSuchEntity:
+			return kv.ErrNotFound
+		default:
+			return errs[i]
+		}
+		return loadProps(dstVal, props[i])
+	})
+}
+
+// DelMulti implements the "kv.Batcher".DelMulti() interface using ae.DeleteMulti()
+func (e *Entity) DelMulti(keys []string) error {
+	dsKeys := make([]*ae.Key, 0, len(keys))
+	for _, key := range keys {
+		dsKeys = append(dsKeys, e.Key(key))
+	}
+	return e.deleteKeys(dsKeys)
+}
+
+func (e *Entity) deleteKeys(keys []*ae.Key) error {
+	for i := 0; i < len(keys); i += maxBatchSize {
+		j := batchEnd(i, len(keys))
+		if err := ae.DeleteMulti(e.Context, keys[i:j]); err != nil {
+			return err
+		}
+		for _, key := range keys[i:j] {
+			hub(e.Entity).Delete(key.StringID())
+		}
+	}
+	return nil
+}
+
+// allKeys returns the datastore keys of all entities of the kind
+func (e *Entity) allKeys() ([]*ae.Key, error) {
+	return ae.NewQuery(e.Entity).KeysOnly().GetAll(e.Context, nil)
+}
+
+// Keys implements the "kv.KeyList".Keys() interface with a keys only query. Since the
+// interface can't return an error, nil is returned if the query fails.
+func (e *Entity) Keys() []string {
+	dsKeys, err := e.allKeys()
+	if err != nil {
+		return nil
+	}
+	keys := make([]string, len(dsKeys))
+	for i := range dsKeys {
+		keys[i] = dsKeys[i].StringID()
+	}
+	return keys
+}
+
+// Transfer implements the "kv.Datastore".Transfer() interface. Entities are loaded as an
+// ae.PropertyList, which is what's passed to dst.Set(), so it's best suited to transferring
+// to another datastore entity kind.
+func (e *Entity) Transfer(dst kv.Store) error {
+	for _, key := range e.Keys() {
+		var props ae.PropertyList
+		if err := e.Get(key, &props); err != nil {
+			if err == kv.ErrNotFound {
+				continue
+			}
+			return err
+		}
+		if err := dst.Set(key, &props); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clear implements the "kv.Clearer".Clear() interface, deleting all entities of the kind
+func (e *Entity) Clear() error {
+	keys, err := e.allKeys()
+	if err != nil {
+		return err
+	}
+	return e.deleteKeys(keys)
+}
+
+// batchEnd returns the end index of the batch starting at i of a total of n items
+func batchEnd(i, n int) int {
+	if i+maxBatchSize < n {
+		return i + maxBatchSize
+	}
+	return n
 }
 
 // Key returns the datastore Key string associated with the entity
 func (e *Entity) Key(key string) *ae.Key {
-	return ae.NewKey(e.Context, e.Entity, key, 0, nil)
+	return e.key(e.Context, key)
+}
+
+func (e *Entity) key(ctx context.Context, key string) *ae.Key {
+	return ae.NewKey(ctx, e.Entity, key, 0, nil)
 }
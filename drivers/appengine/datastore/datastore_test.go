@@ -3,8 +3,12 @@ package datastore
 import (
 	"log"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/bradberger/gokv/chunk"
+	"github.com/bradberger/gokv/dedup"
+	"github.com/bradberger/gokv/kv"
 	"github.com/stretchr/testify/assert"
 
 	"golang.org/x/net/context"
@@ -58,3 +62,103 @@ func TestWithContext(t *testing.T) {
 	assert.Equal(t, e, e.WithContext(ctx))
 	assert.Equal(t, ctx, e.Context)
 }
+
+func TestGetSetDelContext(t *testing.T) {
+	v := testStruct{"bar"}
+	vv := testStruct{}
+	e := New(nil, "Data")
+	assert.NoError(t, e.SetContext(ctx, "foo", &v))
+	assert.NoError(t, e.GetContext(ctx, "foo", &vv))
+	assert.NoError(t, e.DelContext(ctx, "foo"))
+	assert.EqualValues(t, v, vv)
+}
+
+func TestMulti(t *testing.T) {
+	e := New(ctx, "Data")
+	assert.NoError(t, e.SetMulti(map[string]interface{}{
+		"foo": &testStruct{"foo"},
+		"bar": &testStruct{"bar"},
+	}))
+
+	m := map[string]testStruct{}
+	assert.NoError(t, e.GetMulti([]string{"foo", "bar", "baz"}, m))
+	assert.Equal(t, map[string]testStruct{"foo": {"foo"}, "bar": {"bar"}}, m)
+
+	var sl []*testStruct
+	assert.NoError(t, e.GetMulti([]string{"foo", "baz", "bar"}, &sl))
+	assert.Equal(t, []*testStruct{{"foo"}, nil, {"bar"}}, sl)
+
+	assert.NoError(t, e.DelMulti([]string{"foo", "bar"}))
+	assert.Equal(t, kv.ErrNotFound, e.Get("foo", &testStruct{}))
+}
+
+func TestChunk(t *testing.T) {
+	var vv testStruct
+	s := chunk.New(New(ctx, "Chunk"), chunk.Options{Size: 10})
+	assert.NoError(t, s.Set("foo", testStruct{strings.Repeat("bar", 10)}))
+	assert.NoError(t, s.Get("foo", &vv))
+	assert.Equal(t, strings.Repeat("bar", 10), vv.Foo)
+	assert.NoError(t, s.Del("foo"))
+	assert.Equal(t, kv.ErrNotFound, s.Get("foo", &vv))
+}
+
+func TestDedup(t *testing.T) {
+	var vv testStruct
+	s, err := dedup.New(New(ctx, "Dedup"), dedup.Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, s.Set("bar", testStruct{"bar"}))
+	assert.NoError(t, s.Get("foo", &vv))
+	assert.Equal(t, "bar", vv.Foo)
+	assert.NoError(t, s.Del("foo"))
+	assert.NoError(t, s.Del("bar"))
+	assert.Equal(t, kv.ErrNotFound, s.Get("foo", &vv))
+}
+
+func TestKeysTransferClear(t *testing.T) {
+	vv := testStruct{}
+	e, e2 := New(ctx, "Data"), New(ctx, "Data2")
+	assert.NoError(t, e.Set("foo", &testStruct{"bar"}))
+	assert.Equal(t, []string{"foo"}, e.Keys())
+
+	assert.NoError(t, e.Transfer(e2))
+	assert.NoError(t, e2.Get("foo", &vv))
+	assert.Equal(t, "bar", vv.Foo)
+
+	assert.NoError(t, e.Clear())
+	assert.NoError(t, e2.Clear())
+	assert.Len(t, e.Keys(), 0)
+	assert.Len(t, e2.Keys(), 0)
+}
+
+func TestUpdateView(t *testing.T) {
+	var v testStruct
+	e := New(ctx, "Data")
+	assert.NoError(t, e.Update(func(tx kv.Tx) error {
+		if err := tx.Set("foo", &testStruct{"foo"}); err != nil {
+			return err
+		}
+		return tx.Set("bar", &testStruct{"bar"})
+	}))
+	assert.NoError(t, e.View(func(tx kv.Tx) error {
+		assert.Equal(t, kv.ErrReadOnly, tx.Set("foo", &testStruct{"bar"}))
+		assert.Equal(t, kv.ErrReadOnly, tx.Del("foo"))
+		return tx.Get("foo", &v)
+	}))
+	assert.Equal(t, "foo", v.Foo)
+	assert.NoError(t, e.DelMulti([]string{"foo", "bar"}))
+}
+
+func TestWatch(t *testing.T) {
+	e := New(ctx, "Data")
+	events, cancel := New(ctx, "Data").Watch("foo")
+	defer cancel()
+	assert.NoError(t, e.Set("foo", &testStruct{"foo"}))
+	assert.NoError(t, e.Set("bar", &testStruct{"bar"}))
+	assert.NoError(t, e.Update(func(tx kv.Tx) error {
+		return tx.Del("foo")
+	}))
+	assert.Equal(t, kv.Event{Type: kv.EventPut, Key: "foo"}, <-events)
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "foo"}, <-events)
+	assert.NoError(t, e.Del("bar"))
+}
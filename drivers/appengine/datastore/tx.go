@@ -0,0 +1,70 @@
+package datastore
+
+import (
+	"github.com/bradberger/gokv/kv"
+	"golang.org/x/net/context"
+	ae "google.golang.org/appengine/datastore"
+)
+
+// tx is a "kv.Tx" for the entity kind within a datastore transaction. Changes are recorded
+// so watchers can be notified once the transaction commits.
+type tx struct {
+	e        *Entity
+	ctx      context.Context
+	readOnly bool
+	changes  []kv.Event
+}
+
+// Update implements the "kv.Transactional".Update() interface using ae.RunInTransaction(). Since
+// every key is its own entity group the transaction is cross group, which limits it to 25 keys.
+func (e *Entity) Update(fn func(tx kv.Tx) error) error {
+	var t *tx
+	err := ae.RunInTransaction(e.Context, func(tc context.Context) error {
+		// The func is retried on conflicts, so changes of failed attempts are discarded
+		t = &tx{e: e, ctx: tc}
+		return fn(t)
+	}, &ae.TransactionOptions{XG: true})
+	if err != nil {
+		return err
+	}
+	for i := range t.changes {
+		hub(e.Entity).Publish(t.changes[i])
+	}
+	return nil
+}
+
+// View implements the "kv.Transactional".View() interface using a read only transaction
+func (e *Entity) View(fn func(tx kv.Tx) error) error {
+	return ae.RunInTransaction(e.Context, func(tc context.Context) error {
+		return fn(&tx{e: e, ctx: tc, readOnly: true})
+	}, &ae.TransactionOptions{XG: true, ReadOnly: true})
+}
+
+// Set implements the "kv.Tx".Set() interface
+func (t *tx) Set(key string, value interface{}) error {
+	if t.readOnly {
+		return kv.ErrReadOnly
+	}
+	if _, err := ae.Put(t.ctx, t.e.key(t.ctx, key), value); err != nil {
+		return err
+	}
+	t.changes = append(t.changes, kv.Event{Type: kv.EventPut, Key: key})
+	return nil
+}
+
+// Get implements the "kv.Tx".Get() interface
+func (t *tx) Get(key string, dstVal interface{}) error {
+	return t.e.GetContext(t.ctx, key, dstVal)
+}
+
+// Del implements the "kv.Tx".Del() interface
+func (t *tx) Del(key string) error {
+	if t.readOnly {
+		return kv.ErrReadOnly
+	}
+	if err := ae.Delete(t.ctx, t.e.key(t.ctx, key)); err != nil {
+		return err
+	}
+	t.changes = append(t.changes, kv.Event{Type: kv.EventDelete, Key: key})
+	return nil
+}
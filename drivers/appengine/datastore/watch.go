@@ -0,0 +1,31 @@
+package datastore
+
+import (
+	"sync"
+
+	"github.com/bradberger/gokv/kv"
+)
+
+var (
+	hubsMu sync.Mutex
+	hubs   = make(map[string]*kv.Hub)
+)
+
+// hub returns the notification hub shared by all entities of the kind in this process
+func hub(kind string) *kv.Hub {
+	hubsMu.Lock()
+	defer hubsMu.Unlock()
+	h, ok := hubs[kind]
+	if !ok {
+		h = &kv.Hub{}
+		hubs[kind] = h
+	}
+	return h
+}
+
+// Watch implements the "kv.Watcher".Watch() interface for changes made through any Entity of
+// the same kind in this process. Entities aren't stored as encoded bytes, so the Value of put
+// events is always nil; use Get() to load the new value.
+func (e *Entity) Watch(prefix string) (<-chan kv.Event, func()) {
+	return hub(e.Entity).Watch(prefix)
+}
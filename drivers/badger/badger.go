@@ -0,0 +1,372 @@
+// Package badger implements a key/value store powered by BadgerDB
+package badger
+
+import (
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"github.com/dgraph-io/badger/v4"
+	"golang.org/x/net/context"
+)
+
+var (
+	// Codec is the codec used to marshal/unmarshal interfaces into the byte slices stored in BadgerDB,
+	// for DBs created without the kv.WithCodec option. The default codec is Gob
+	Codec codec.Codec
+
+	// ensure struct implements the kv.Store interface
+	_ kv.Store         = (*DB)(nil)
+	_ kv.ContextStore  = (*DB)(nil)
+	_ kv.Expirer       = (*DB)(nil)
+	_ kv.Batcher       = (*DB)(nil)
+	_ kv.Scanner       = (*DB)(nil)
+	_ kv.Datastore     = (*DB)(nil)
+	_ kv.Clearer       = (*DB)(nil)
+	_ kv.RawStore      = (*DB)(nil)
+	_ kv.Transactional = (*DB)(nil)
+	_ kv.Watcher       = (*DB)(nil)
+)
+
+func init() {
+	Codec = codec.Gob
+}
+
+// DB is a light wrapper around the BadgerDB database. Expiration uses badger's native entry
+// TTL, which has a resolution of one second.
+type DB struct {
+	db   *badger.DB
+	opts kv.Options
+	hub  kv.Hub
+}
+
+// New returns a new key/value store powered by BadgerDB. If opts is nil the default options
+// are used. Empty Dir and ValueDir options default to path. Options like kv.WithCodec configure
+// the DB itself.
+func New(path string, options *badger.Options, opts ...kv.Option) (*DB, error) {
+	o := badger.DefaultOptions(path)
+	if options != nil {
+		o = *options
+		if o.Dir == "" {
+			o.Dir = path
+		}
+		if o.ValueDir == "" {
+			o.ValueDir = path
+		}
+	}
+	db, err := badger.Open(o)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db: db, opts: kv.NewOptions(opts...)}, nil
+}
+
+// Get implements the "kv.Store".Get interface
+func (db *DB) Get(key string, dstVal interface{}) error {
+	return db.GetContext(context.Background(), key, dstVal)
+}
+
+// Set implements the "kv.Store".Set interface
+func (db *DB) Set(key string, val interface{}) error {
+	return db.SetContext(context.Background(), key, val)
+}
+
+// Del implements the "kv.Store".Del interface
+func (db *DB) Del(key string) error {
+	return db.DelContext(context.Background(), key)
+}
+
+// GetContext implements the "kv.ContextStore".GetContext interface
+func (db *DB) GetContext(ctx context.Context, key string, dstVal interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.DB().View(func(txn *badger.Txn) error {
+		return db.get(txn, key, dstVal)
+	})
+}
+
+// get decodes the value of the key within the transaction
+func (db *DB) get(txn *badger.Txn, key string, dstVal interface{}) error {
+	item, err := txn.Get([]byte(key))
+	if err != nil {
+		return notFound(err)
+	}
+	return item.Value(func(b []byte) error {
+		return db.Codec().Unmarshal(b, dstVal)
+	})
+}
+
+// notFound converts badger's missing key error to kv.ErrNotFound
+func notFound(err error) error {
+	if err == badger.ErrKeyNotFound {
+		return kv.ErrNotFound
+	}
+	return err
+}
+
+// SetContext implements the "kv.ContextStore".SetContext interface
+func (db *DB) SetContext(ctx context.Context, key string, val interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, err := db.Codec().Marshal(val)
+	if err != nil {
+		return err
+	}
+	return db.update(func(txn *badger.Txn, c *changes) error {
+		return c.put(txn, badger.NewEntry([]byte(key), b))
+	})
+}
+
+// DelContext implements the "kv.ContextStore".DelContext interface
+func (db *DB) DelContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.update(func(txn *badger.Txn, c *changes) error {
+		return c.del(txn, []byte(key))
+	})
+}
+
+// changes records the events of a transaction, to be published once it's committed
+type changes []kv.Event
+
+// put sets the entry in the transaction and records the event
+func (c *changes) put(txn *badger.Txn, e *badger.Entry) error {
+	if err := txn.SetEntry(e); err != nil {
+		return err
+	}
+	*c = append(*c, kv.Event{Type: kv.EventPut, Key: string(e.Key), Value: e.Value})
+	return nil
+}
+
+// del deletes the key in the transaction and records the event
+func (c *changes) del(txn *badger.Txn, key []byte) error {
+	if err := txn.Delete(key); err != nil {
+		return err
+	}
+	*c = append(*c, kv.Event{Type: kv.EventDelete, Key: string(key)})
+	return nil
+}
+
+// publish notifies watchers of the changes
+func (c changes) publish(hub *kv.Hub) {
+	for i := range c {
+		hub.Publish(c[i])
+	}
+}
+
+// update runs fn in a read/write transaction, retrying it if the transaction conflicts with a
+// concurrent one. The changes recorded by the committed attempt are published to watchers.
+func (db *DB) update(fn func(txn *badger.Txn, c *changes) error) error {
+	for {
+		var c changes
+		err := db.DB().Update(func(txn *badger.Txn) error {
+			return fn(txn, &c)
+		})
+		switch err {
+		case nil:
+			c.publish(&db.hub)
+			return nil
+		case badger.ErrConflict:
+			continue
+		}
+		return err
+	}
+}
+
+// Watch implements the "kv.Watcher".Watch interface for changes made through this DB
+func (db *DB) Watch(prefix string) (<-chan kv.Event, func()) {
+	return db.hub.Watch(prefix)
+}
+
+// SetMulti implements the "kv.Batcher".SetMulti interface using a badger WriteBatch. Unlike
+// a transaction, a WriteBatch isn't limited in size, but isn't atomic either.
+func (db *DB) SetMulti(items map[string]interface{}) error {
+	wb := db.DB().NewWriteBatch()
+	defer wb.Cancel()
+	var c changes
+	for key, val := range items {
+		b, err := db.Codec().Marshal(val)
+		if err != nil {
+			return err
+		}
+		if err := wb.Set([]byte(key), b); err != nil {
+			return err
+		}
+		c = append(c, kv.Event{Type: kv.EventPut, Key: key, Value: b})
+	}
+	if err := wb.Flush(); err != nil {
+		return err
+	}
+	c.publish(&db.hub)
+	return nil
+}
+
+// GetMulti implements the "kv.Batcher".GetMulti interface, reading all keys in a single transaction
+func (db *DB) GetMulti(keys []string, dst interface{}) error {
+	return db.DB().View(func(txn *badger.Txn) error {
+		return kv.FillMulti(keys, dst, func(i int, key string, dstVal interface{}) error {
+			return db.get(txn, key, dstVal)
+		})
+	})
+}
+
+// DelMulti implements the "kv.Batcher".DelMulti interface using a badger WriteBatch
+func (db *DB) DelMulti(keys []string) error {
+	wb := db.DB().NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete([]byte(key)); err != nil {
+			return err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return err
+	}
+	db.hub.Delete(keys...)
+	return nil
+}
+
+// SetWithTTL implements the "kv.Expirer".SetWithTTL interface using badger's entry TTL
+func (db *DB) SetWithTTL(key string, val interface{}, ttl time.Duration) error {
+	b, err := db.Codec().Marshal(val)
+	if err != nil {
+		return err
+	}
+	return db.update(func(txn *badger.Txn, c *changes) error {
+		return c.put(txn, entry([]byte(key), b, codec.ExpiresAt(ttl)))
+	})
+}
+
+// entry returns a badger entry expiring at exp, unless exp is the zero time
+func entry(key, b []byte, exp time.Time) *badger.Entry {
+	e := badger.NewEntry(key, b)
+	if !exp.IsZero() {
+		// Round up to the next second so entries never expire early
+		e.ExpiresAt = uint64((exp.UnixNano() + int64(time.Second) - 1) / int64(time.Second))
+	}
+	return e
+}
+
+// expiresAt returns the expiration time of the item, or the zero time if it doesn't expire
+func expiresAt(item *badger.Item) time.Time {
+	if item.ExpiresAt() == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(item.ExpiresAt()), 0)
+}
+
+// TTL implements the "kv.Expirer".TTL interface
+func (db *DB) TTL(key string) (ttl time.Duration, err error) {
+	err = db.DB().View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return notFound(err)
+		}
+		if exp := expiresAt(item); exp.IsZero() {
+			ttl = kv.NoExpiration
+		} else {
+			ttl = exp.Sub(time.Now())
+		}
+		return nil
+	})
+	return
+}
+
+// Touch implements the "kv.Expirer".Touch interface by rewriting the entry with a new TTL
+func (db *DB) Touch(key string, ttl time.Duration) error {
+	return db.update(func(txn *badger.Txn, c *changes) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return notFound(err)
+		}
+		b, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		return c.put(txn, entry([]byte(key), b, codec.ExpiresAt(ttl)))
+	})
+}
+
+// GetRaw implements the "kv.RawStore".GetRaw interface. The entry's expiration is added as an
+// expiry header, so it's kept when the value is copied to another store.
+func (db *DB) GetRaw(key string) (b []byte, err error) {
+	err = db.DB().View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return notFound(err)
+		}
+		if b, err = item.ValueCopy(nil); err != nil {
+			return err
+		}
+		b = codec.WithExpiry(b, expiresAt(item))
+		return nil
+	})
+	return
+}
+
+// SetRaw implements the "kv.RawStore".SetRaw interface. An expiry header is converted to the
+// entry's TTL.
+func (db *DB) SetRaw(key string, b []byte) error {
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return nil
+	}
+	return db.update(func(txn *badger.Txn, c *changes) error {
+		return c.put(txn, entry([]byte(key), b, exp))
+	})
+}
+
+// Codec implements the "kv.RawStore".Codec interface
+func (db *DB) Codec() codec.Codec {
+	return db.opts.CodecOr(Codec)
+}
+
+// Keys implements the "kv.KeyList".Keys interface. Since the interface can't return an error,
+// nil is returned if the database can't be read.
+func (db *DB) Keys() []string {
+	keys := []string{}
+	err := db.DB().View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Item().Key()))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+	return keys
+}
+
+// Transfer implements the "kv.Datastore".Transfer interface, copying all keys to dst.
+// Encoded values are copied as is if dst is a "kv.RawStore" sharing the same codec.
+func (db *DB) Transfer(dst kv.Store) error {
+	return kv.Transfer(db, dst)
+}
+
+// Clear implements the "kv.Clearer".Clear interface using DropAll, which blocks writes while it
+// runs. Watchers receive a delete event for every key.
+func (db *DB) Clear() error {
+	keys := db.Keys()
+	if err := db.DB().DropAll(); err != nil {
+		return err
+	}
+	db.hub.Delete(keys...)
+	return nil
+}
+
+// DB returns the underlying BadgerDB database
+func (db *DB) DB() *badger.DB {
+	return db.db
+}
+
+// Close is used to close the database.
+func (db *DB) Close() error {
+	return db.DB().Close()
+}
@@ -0,0 +1,249 @@
+package badger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type testStruct struct {
+	Foo string
+}
+
+func tmpDir() string {
+	dir, err := ioutil.TempDir("", "badger")
+	if err != nil {
+		panic(err)
+	}
+	return dir
+}
+
+// newTestDB opens a quiet database in a temp dir which is removed once the test completes
+func newTestDB(t *testing.T, opts ...kv.Option) *DB {
+	dir := tmpDir()
+	options := badger.DefaultOptions("").WithLogger(nil)
+	db, err := New(dir, &options, opts...)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return db
+}
+
+// newTestStore returns a new database for the kvtest conformance tests
+func newTestStore(t *testing.T) kv.Store {
+	return newTestDB(t)
+}
+
+func TestNew(t *testing.T) {
+	db := newTestDB(t)
+	assert.NotNil(t, db.DB())
+	assert.Equal(t, db.db, db.DB())
+}
+
+func TestOpenErr(t *testing.T) {
+	f, err := ioutil.TempFile("", "badger")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	db, err := New(f.Name(), nil)
+	assert.Error(t, err)
+	assert.Nil(t, db)
+}
+
+func TestGetSetDel(t *testing.T) {
+	var v testStruct
+	db := newTestDB(t)
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &v))
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, db.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+	assert.NoError(t, db.Del("foo"))
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &v))
+}
+
+func TestSetErr(t *testing.T) {
+	db := newTestDB(t, kv.WithCodec(codec.ErrTestCodec))
+	assert.Error(t, db.Set("foo", testStruct{"bar"}))
+	assert.Error(t, db.SetMulti(map[string]interface{}{"foo": testStruct{"bar"}}))
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", nil))
+}
+
+func TestCodec(t *testing.T) {
+	var v testStruct
+	db := newTestDB(t, kv.WithCodec(codec.JSON))
+	assert.True(t, codec.Equal(codec.JSON, db.Codec()))
+	assert.True(t, codec.Equal(Codec, newTestDB(t).Codec()))
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	b, err := db.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Foo":"bar"}`, string(b))
+	assert.NoError(t, db.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+}
+
+// TestRawValues checks blobs are stored verbatim with codec.RawOr
+func TestRawValues(t *testing.T) {
+	var s string
+	var b []byte
+	var buf bytes.Buffer
+	db := newTestDB(t, kv.WithCodec(codec.RawOr(codec.Gob)))
+	assert.NoError(t, db.Set("foo", []byte("\x89PNG")))
+	raw, err := db.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x89PNG"), raw)
+	assert.NoError(t, db.Get("foo", &b))
+	assert.Equal(t, []byte("\x89PNG"), b)
+	assert.NoError(t, db.Set("bar", strings.NewReader("bar")))
+	assert.NoError(t, db.Get("bar", &s))
+	assert.Equal(t, "bar", s)
+	assert.NoError(t, db.Get("bar", &buf))
+	assert.Equal(t, "bar", buf.String())
+
+	// Blobs which start like an expiry header aren't mistaken for one
+	ttl := []byte("\xffTTL\x00\x00\x00\x00\x00\x00\x00\x01foo")
+	assert.NoError(t, db.Set("baz", ttl))
+	assert.NoError(t, db.Get("baz", &b))
+	assert.Equal(t, ttl, b)
+}
+
+func TestContext(t *testing.T) {
+	var s string
+	db := newTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, db.SetContext(ctx, "foo", "bar"))
+	assert.NoError(t, db.GetContext(ctx, "foo", &s))
+	assert.Equal(t, "bar", s)
+
+	cancel()
+	assert.Equal(t, context.Canceled, db.SetContext(ctx, "bar", "baz"))
+	assert.Equal(t, context.Canceled, db.GetContext(ctx, "foo", &s))
+	assert.Equal(t, context.Canceled, db.DelContext(ctx, "foo"))
+	assert.NoError(t, db.Get("foo", &s))
+	assert.Equal(t, kv.ErrNotFound, db.Get("bar", &s))
+}
+
+func TestTTL(t *testing.T) {
+	var v testStruct
+	db := newTestDB(t)
+	assert.NoError(t, db.Set("foo", testStruct{"foo"}))
+	ttl, err := db.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+
+	assert.NoError(t, db.SetWithTTL("bar", testStruct{"bar"}, time.Millisecond))
+	ttl, err = db.TTL("bar")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Second)
+	time.Sleep(time.Second + 10*time.Millisecond)
+	_, err = db.TTL("bar")
+	assert.Equal(t, kv.ErrNotFound, err)
+	assert.Equal(t, kv.ErrNotFound, db.Get("bar", &v))
+	assert.Equal(t, kv.ErrNotFound, db.Touch("bar", time.Minute))
+
+	assert.NoError(t, db.Touch("foo", time.Minute))
+	ttl, err = db.TTL("foo")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 59*time.Second)
+	assert.NoError(t, db.Get("foo", &v))
+	assert.Equal(t, "foo", v.Foo)
+}
+
+func TestEntry(t *testing.T) {
+	assert.Zero(t, entry([]byte("foo"), nil, time.Time{}).ExpiresAt)
+	assert.Equal(t, uint64(2), entry([]byte("foo"), nil, time.Unix(1, 1)).ExpiresAt)
+	assert.Equal(t, uint64(1), entry([]byte("foo"), nil, time.Unix(1, 0)).ExpiresAt)
+}
+
+func TestMulti(t *testing.T) {
+	db := newTestDB(t)
+	assert.NoError(t, db.SetMulti(map[string]interface{}{
+		"foo": testStruct{"foo"},
+		"bar": testStruct{"bar"},
+	}))
+
+	m := map[string]testStruct{}
+	assert.NoError(t, db.GetMulti([]string{"foo", "bar", "baz"}, m))
+	assert.Equal(t, map[string]testStruct{"foo": {"foo"}, "bar": {"bar"}}, m)
+
+	var sl []*testStruct
+	assert.NoError(t, db.GetMulti([]string{"foo", "baz", "bar"}, &sl))
+	assert.Equal(t, []*testStruct{{"foo"}, nil, {"bar"}}, sl)
+
+	assert.NoError(t, db.DelMulti([]string{"foo", "bar", "baz"}))
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &testStruct{}))
+	assert.Equal(t, kv.ErrNotFound, db.Get("bar", &testStruct{}))
+}
+
+func TestKeysTransferClear(t *testing.T) {
+	vv := testStruct{}
+	db, db2 := newTestDB(t), newTestDB(t)
+
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, db.SetWithTTL("bar", testStruct{"bar"}, time.Minute))
+	assert.NoError(t, db.SetRaw("baz", codec.WithExpiry(nil, time.Now().Add(-time.Second))))
+	keys := db.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+
+	assert.NoError(t, db.Transfer(db2))
+	keys = db2.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+	assert.NoError(t, db2.Get("foo", &vv))
+	assert.EqualValues(t, testStruct{"bar"}, vv)
+	ttl, err := db2.TTL("bar")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+
+	assert.NoError(t, db.Clear())
+	assert.Len(t, db.Keys(), 0)
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &vv))
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	assert.Len(t, db.Keys(), 1)
+}
+
+func TestWatch(t *testing.T) {
+	var vv testStruct
+	db := newTestDB(t)
+
+	events, cancel := db.Watch("foo")
+	assert.NoError(t, db.Set("bar", testStruct{"bar"}))
+	assert.NoError(t, db.SetWithTTL("foo", testStruct{"foo"}, time.Minute))
+	assert.NoError(t, db.Touch("foo", time.Hour))
+	assert.NoError(t, db.Update(func(tx kv.Tx) error {
+		return tx.Del("foo")
+	}))
+
+	e := <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+
+	// Touch rewrites the key, so watchers see the unchanged value again
+	vv = testStruct{}
+	e = <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "foo"}, <-events)
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+}
@@ -0,0 +1,83 @@
+package badger
+
+import (
+	"bytes"
+
+	"github.com/bradberger/gokv/kv"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// iter is a "kv.Iterator" wrapping a badger iterator and its read only transaction
+type iter struct {
+	db      *DB
+	txn     *badger.Txn
+	it      *badger.Iterator
+	start   []byte
+	end     []byte
+	started bool
+}
+
+// Scan implements the "kv.Scanner".Scan interface using a badger iterator limited to the prefix.
+// The iterator reads from a snapshot of the database and keys are returned in byte order.
+// Expired keys are skipped by badger itself.
+func (db *DB) Scan(prefix, start, end string) kv.Iterator {
+	txn := db.DB().NewTransaction(false)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = []byte(prefix)
+	i := &iter{db: db, txn: txn, it: txn.NewIterator(opts), start: []byte(prefix)}
+	if start > prefix {
+		i.start = []byte(start)
+	}
+	if end != "" {
+		i.end = []byte(end)
+	}
+	return i
+}
+
+// Next implements the "kv.Iterator".Next interface
+func (i *iter) Next() bool {
+	if i.it == nil {
+		return false
+	}
+	if i.started {
+		i.it.Next()
+	} else {
+		i.it.Seek(i.start)
+		i.started = true
+	}
+	return i.it.Valid() && (i.end == nil || bytes.Compare(i.it.Item().Key(), i.end) < 0)
+}
+
+// Key implements the "kv.Iterator".Key interface
+func (i *iter) Key() string {
+	if i.it == nil || !i.it.Valid() {
+		return ""
+	}
+	return string(i.it.Item().Key())
+}
+
+// Value implements the "kv.Iterator".Value interface
+func (i *iter) Value(dstVal interface{}) error {
+	if i.it == nil || !i.it.Valid() {
+		return kv.ErrNotFound
+	}
+	return i.it.Item().Value(func(b []byte) error {
+		return i.db.Codec().Unmarshal(b, dstVal)
+	})
+}
+
+// Err implements the "kv.Iterator".Err interface. Badger iterators don't report errors, which
+// surface when reading values instead.
+func (i *iter) Err() error {
+	return nil
+}
+
+// Close implements the "kv.Iterator".Close interface, discarding the transaction
+func (i *iter) Close() error {
+	if i.it != nil {
+		i.it.Close()
+		i.txn.Discard()
+		i.it = nil
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package badger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bradberger/gokv/kv"
+	"github.com/bradberger/gokv/kv/kvtest"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// setExpired sets an entry which already expired, since badger expires entries by the second
+func setExpired(t *testing.T, s kv.Store, key string, value interface{}) {
+	b, err := Codec.Marshal(value)
+	assert.NoError(t, err)
+	assert.NoError(t, s.(*DB).DB().Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(entry([]byte(key), b, time.Now().Add(-time.Second)))
+	}))
+}
+
+func TestScan(t *testing.T) {
+	kvtest.Scan(t, newTestStore, kvtest.ScanOptions{SetExpired: setExpired})
+}
@@ -0,0 +1,55 @@
+package badger
+
+import (
+	"github.com/bradberger/gokv/kv"
+	"github.com/dgraph-io/badger/v4"
+)
+
+// tx is a "kv.Tx" wrapping a badger transaction. Changes are recorded to notify watchers once
+// the transaction commits, and c is nil for read only transactions.
+type tx struct {
+	db  *DB
+	txn *badger.Txn
+	c   *changes
+}
+
+// Update implements the "kv.Transactional".Update interface using a badger read/write
+// transaction. Badger detects conflicts with concurrent transactions, in which case fn is
+// retried, so it must not have side effects other than through tx.
+func (db *DB) Update(fn func(tx kv.Tx) error) error {
+	return db.update(func(txn *badger.Txn, c *changes) error {
+		return fn(&tx{db, txn, c})
+	})
+}
+
+// View implements the "kv.Transactional".View interface using a badger read only transaction
+func (db *DB) View(fn func(tx kv.Tx) error) error {
+	return db.DB().View(func(txn *badger.Txn) error {
+		return fn(&tx{db: db, txn: txn})
+	})
+}
+
+// Set implements the "kv.Tx".Set interface
+func (t *tx) Set(key string, val interface{}) error {
+	if t.c == nil {
+		return kv.ErrReadOnly
+	}
+	b, err := t.db.Codec().Marshal(val)
+	if err != nil {
+		return err
+	}
+	return t.c.put(t.txn, badger.NewEntry([]byte(key), b))
+}
+
+// Get implements the "kv.Tx".Get interface
+func (t *tx) Get(key string, dstVal interface{}) error {
+	return t.db.get(t.txn, key, dstVal)
+}
+
+// Del implements the "kv.Tx".Del interface
+func (t *tx) Del(key string) error {
+	if t.c == nil {
+		return kv.ErrReadOnly
+	}
+	return t.c.del(t.txn, []byte(key))
+}
@@ -2,19 +2,32 @@ package boltdb
 
 import (
 	"os"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/bradberger/gokv/codec"
 	"github.com/bradberger/gokv/kv"
+	"golang.org/x/net/context"
 )
 
 var (
-	// Codec is the codec used to marshal/unmarshal interfaces into the byte slices required by the BoltDB client.
-	// The default codec is Gob
+	// Codec is the codec used to marshal/unmarshal interfaces into the byte slices required by the BoltDB client,
+	// for DBs created without the kv.WithCodec option. The default codec is Gob
 	Codec codec.Codec
 
 	// ensure struct implements the kv.Store interface
-	_ kv.Store = (*DB)(nil)
+	_ kv.Store         = (*DB)(nil)
+	_ kv.ContextStore  = (*DB)(nil)
+	_ kv.Expirer       = (*DB)(nil)
+	_ kv.Sweeper       = (*DB)(nil)
+	_ kv.Batcher       = (*DB)(nil)
+	_ kv.Scanner       = (*DB)(nil)
+	_ kv.Datastore     = (*DB)(nil)
+	_ kv.Clearer       = (*DB)(nil)
+	_ kv.RawStore      = (*DB)(nil)
+	_ kv.CAS           = (*DB)(nil)
+	_ kv.Transactional = (*DB)(nil)
+	_ kv.Watcher       = (*DB)(nil)
 )
 
 func init() {
@@ -25,17 +38,19 @@ func init() {
 type DB struct {
 	db     *bolt.DB
 	bucket string
+	opts   kv.Options
+	hub    kv.Hub
 }
 
 // New creates a new DB struct to interace with the underlying BoltDB database. Be sure to close it when you're done or it could hang
-// If the bucket does not exist, it will be created.
-func New(path string, bucket string, mode os.FileMode, options *bolt.Options) (*DB, error) {
+// If the bucket does not exist, it will be created. Options like kv.WithCodec configure the DB itself.
+func New(path string, bucket string, mode os.FileMode, options *bolt.Options, opts ...kv.Option) (*DB, error) {
 	var err error
 	db, err := bolt.Open(path, mode, options)
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db: db, bucket: bucket}, db.Update(func(tx *bolt.Tx) error {
+	return &DB{db: db, bucket: bucket, opts: kv.NewOptions(opts...)}, db.Update(func(tx *bolt.Tx) error {
 		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
 		return err
 	})
@@ -43,33 +58,317 @@ func New(path string, bucket string, mode os.FileMode, options *bolt.Options) (*
 
 // Set implements the "kv.Store".Set() interface
 func (d *DB) Set(key string, value interface{}) error {
-	b, err := Codec.Marshal(value)
+	return d.SetContext(context.Background(), key, value)
+}
+
+// Get implements the "kv.Store".Get() interface
+func (d *DB) Get(key string, dstVal interface{}) error {
+	return d.GetContext(context.Background(), key, dstVal)
+}
+
+// Del implements the "kv.Store".Del() interface
+func (d *DB) Del(key string) error {
+	return d.DelContext(context.Background(), key)
+}
+
+// SetContext implements the "kv.ContextStore".SetContext() interface. BoltDB transactions
+// can't be interrupted, so the context is checked before the write is committed.
+func (d *DB) SetContext(ctx context.Context, key string, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, err := d.marshal(value, time.Time{})
 	if err != nil {
 		return err
 	}
-	return d.DB().Update(func(tx *bolt.Tx) error {
-		return tx.Bucket([]byte(d.bucket)).Put([]byte(key), b)
+	return d.put(ctx, key, b)
+}
+
+func (d *DB) put(ctx context.Context, key string, b []byte) error {
+	return d.update(func(bucket *bolt.Bucket, c *changes) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return c.put(bucket, []byte(key), b)
 	})
 }
 
-// Get implements the "kv.Store".Get() interface
-func (d *DB) Get(key string, dstVal interface{}) error {
+// changes records the events of a transaction, to be published once it's committed
+type changes []kv.Event
+
+// put puts the value in the bucket and records the event
+func (c *changes) put(b *bolt.Bucket, key, val []byte) error {
+	if err := b.Put(key, val); err != nil {
+		return err
+	}
+	val, _ = codec.SplitExpiry(val)
+	*c = append(*c, kv.Event{Type: kv.EventPut, Key: string(key), Value: val})
+	return nil
+}
+
+// del deletes the key from the bucket and records the event
+func (c *changes) del(b *bolt.Bucket, key []byte) error {
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+	*c = append(*c, kv.Event{Type: kv.EventDelete, Key: string(key)})
+	return nil
+}
+
+// update runs fn in a read/write transaction, publishing the changes it records to watchers
+// once the transaction has been committed
+func (d *DB) update(fn func(b *bolt.Bucket, c *changes) error) error {
+	var c changes
+	err := d.DB().Update(func(tx *bolt.Tx) error {
+		return fn(tx.Bucket([]byte(d.bucket)), &c)
+	})
+	if err != nil {
+		return err
+	}
+	for i := range c {
+		d.hub.Publish(c[i])
+	}
+	return nil
+}
+
+// Watch implements the "kv.Watcher".Watch() interface for changes made through this DB
+func (d *DB) Watch(prefix string) (<-chan kv.Event, func()) {
+	return d.hub.Watch(prefix)
+}
+
+// GetContext implements the "kv.ContextStore".GetContext() interface
+func (d *DB) GetContext(ctx context.Context, key string, dstVal interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	return d.DB().View(func(tx *bolt.Tx) error {
 		val := tx.Bucket([]byte(d.bucket)).Get([]byte(key))
 		if val == nil {
 			return kv.ErrNotFound
 		}
-		return Codec.Unmarshal(val, dstVal)
+		return d.unmarshal(val, dstVal)
 	})
 }
 
-// Del implements the "kv.Store".Del() interface
-func (d *DB) Del(key string) error {
-	return d.DB().Update(func(tx *bolt.Tx) error {
-		return tx.Bucket([]byte(d.bucket)).Delete([]byte(key))
+// marshal encodes a value to be stored with the expiration exp, which is kept in a header in
+// front of the encoded value. Values which never expire only get a header if they start like one.
+func (d *DB) marshal(value interface{}, exp time.Time) ([]byte, error) {
+	b, err := d.Codec().Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return codec.WithExpiry(b, exp), nil
+}
+
+// unmarshal decodes a stored value, returning kv.ErrCacheMiss if it has expired
+func (d *DB) unmarshal(b []byte, dstVal interface{}) error {
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return kv.ErrCacheMiss
+	}
+	return d.Codec().Unmarshal(b, dstVal)
+}
+
+// DelContext implements the "kv.ContextStore".DelContext() interface
+func (d *DB) DelContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.update(func(b *bolt.Bucket, c *changes) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return c.del(b, []byte(key))
+	})
+}
+
+// SetMulti implements the "kv.Batcher".SetMulti() interface, setting all items in a single transaction
+func (d *DB) SetMulti(items map[string]interface{}) error {
+	encoded := make(map[string][]byte, len(items))
+	for key, value := range items {
+		b, err := d.marshal(value, time.Time{})
+		if err != nil {
+			return err
+		}
+		encoded[key] = b
+	}
+	return d.update(func(b *bolt.Bucket, c *changes) error {
+		for key, val := range encoded {
+			if err := c.put(b, []byte(key), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetMulti implements the "kv.Batcher".GetMulti() interface, reading all keys in a single transaction
+func (d *DB) GetMulti(keys []string, dst interface{}) error {
+	return d.DB().View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(d.bucket))
+		return kv.FillMulti(keys, dst, func(i int, key string, dstVal interface{}) error {
+			val := b.Get([]byte(key))
+			if val == nil {
+				return kv.ErrNotFound
+			}
+			return d.unmarshal(val, dstVal)
+		})
+	})
+}
+
+// DelMulti implements the "kv.Batcher".DelMulti() interface, deleting all keys in a single transaction
+func (d *DB) DelMulti(keys []string) error {
+	return d.update(func(b *bolt.Bucket, c *changes) error {
+		for _, key := range keys {
+			if err := c.del(b, []byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetWithTTL implements the "kv.Expirer".SetWithTTL() interface. The expiration is stored
+// in a header in front of the encoded value.
+func (d *DB) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	b, err := d.marshal(value, codec.ExpiresAt(ttl))
+	if err != nil {
+		return err
+	}
+	return d.put(context.Background(), key, b)
+}
+
+// TTL implements the "kv.Expirer".TTL() interface
+func (d *DB) TTL(key string) (ttl time.Duration, err error) {
+	err = d.DB().View(func(tx *bolt.Tx) error {
+		val := tx.Bucket([]byte(d.bucket)).Get([]byte(key))
+		if val == nil {
+			return kv.ErrNotFound
+		}
+		_, exp := codec.SplitExpiry(val)
+		switch {
+		case exp.IsZero():
+			ttl = kv.NoExpiration
+		case codec.Expired(exp):
+			return kv.ErrCacheMiss
+		default:
+			ttl = exp.Sub(time.Now())
+		}
+		return nil
+	})
+	return
+}
+
+// Touch implements the "kv.Expirer".Touch() interface
+func (d *DB) Touch(key string, ttl time.Duration) error {
+	return d.update(func(b *bolt.Bucket, c *changes) error {
+		val := b.Get([]byte(key))
+		if val == nil {
+			return kv.ErrNotFound
+		}
+		val, exp := codec.SplitExpiry(val)
+		if codec.Expired(exp) {
+			return kv.ErrCacheMiss
+		}
+		// The value is only valid within the transaction, but is kept by the event
+		val = append([]byte(nil), val...)
+		return c.put(b, []byte(key), codec.WithExpiry(val, codec.ExpiresAt(ttl)))
+	})
+}
+
+// Sweep implements the "kv.Sweeper".Sweep() interface, deleting all expired keys in a
+// single transaction. Use kv.SweepEvery() to run it periodically.
+func (d *DB) Sweep() error {
+	return d.update(func(b *bolt.Bucket, c *changes) error {
+		var expired [][]byte
+		cur := b.Cursor()
+		for k, v := cur.First(); k != nil; k, v = cur.Next() {
+			if _, exp := codec.SplitExpiry(v); codec.Expired(exp) {
+				expired = append(expired, append([]byte(nil), k...))
+			}
+		}
+		for i := range expired {
+			if err := c.del(b, expired[i]); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 }
 
+// GetRaw implements the "kv.RawStore".GetRaw() interface, returning the value as stored, including
+// any expiry header
+func (d *DB) GetRaw(key string) (b []byte, err error) {
+	err = d.DB().View(func(tx *bolt.Tx) error {
+		val := tx.Bucket([]byte(d.bucket)).Get([]byte(key))
+		if val == nil {
+			return kv.ErrNotFound
+		}
+		if _, exp := codec.SplitExpiry(val); codec.Expired(exp) {
+			return kv.ErrCacheMiss
+		}
+		b = append([]byte(nil), val...)
+		return nil
+	})
+	return
+}
+
+// SetRaw implements the "kv.RawStore".SetRaw() interface
+func (d *DB) SetRaw(key string, b []byte) error {
+	return d.put(context.Background(), key, b)
+}
+
+// Codec implements the "kv.RawStore".Codec() interface, returning the codec set with kv.WithCodec
+// or the package Codec
+func (d *DB) Codec() codec.Codec {
+	return d.opts.CodecOr(Codec)
+}
+
+// Keys implements the "kv.KeyList".Keys() interface. Expired keys are left out. Since the
+// interface can't return an error, nil is returned if the database can't be read.
+func (d *DB) Keys() []string {
+	it := d.Scan("", "", "")
+	defer it.Close()
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil {
+		return nil
+	}
+	return keys
+}
+
+// Transfer implements the "kv.Datastore".Transfer() interface, copying all keys to dst.
+// Encoded values are copied as is if dst is a "kv.RawStore" sharing the same codec.
+func (d *DB) Transfer(dst kv.Store) error {
+	return kv.Transfer(d, dst)
+}
+
+// Clear implements the "kv.Clearer".Clear() interface by deleting and recreating the bucket.
+// Watchers receive a delete event for every key.
+func (d *DB) Clear() error {
+	var keys []string
+	err := d.DB().Update(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte(d.bucket)); b != nil {
+			b.ForEach(func(k, v []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			})
+		}
+		if err := tx.DeleteBucket([]byte(d.bucket)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		_, err := tx.CreateBucket([]byte(d.bucket))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	d.hub.Delete(keys...)
+	return nil
+}
+
 // DB returns the underling BoltDB struct
 func (d *DB) DB() *bolt.DB {
 	return d.db
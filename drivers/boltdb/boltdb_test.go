@@ -1,13 +1,18 @@
 package boltdb
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bradberger/gokv/codec"
 	"github.com/bradberger/gokv/kv"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
 )
 
 type testStruct struct {
@@ -23,6 +28,25 @@ func tmpFile() string {
 	return f.Name()
 }
 
+// newTestDB opens a database in a temp file which is removed once the test completes
+func newTestDB(t *testing.T) *DB {
+	fn := tmpFile()
+	db, err := New(fn, "test", 0777, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.Remove(fn)
+	})
+	return db
+}
+
+// newTestStore returns a new database for the kvtest conformance tests
+func newTestStore(t *testing.T) kv.Store {
+	return newTestDB(t)
+}
+
 func TestNew(t *testing.T) {
 	fn := tmpFile()
 	db, err := New(fn, "test", 0777, nil)
@@ -60,18 +84,122 @@ func TestSet(t *testing.T) {
 func TestSetErr(t *testing.T) {
 	v := testStruct{"bar"}
 	fn := tmpFile()
-	origCodec := Codec
-	Codec = codec.ErrTestCodec
-	db, err := New(fn, "test", 0777, nil)
+	db, err := New(fn, "test", 0777, nil, kv.WithCodec(codec.ErrTestCodec))
 	defer func() {
 		db.Close()
 		os.Remove(fn)
-		Codec = origCodec
 	}()
 	assert.NoError(t, err)
 	assert.Error(t, db.Set("foo", v))
 }
 
+func TestCodec(t *testing.T) {
+	var v testStruct
+	fn, fn2 := tmpFile(), tmpFile()
+	db, err := New(fn, "test", 0777, nil, kv.WithCodec(codec.JSON))
+	assert.NoError(t, err)
+	db2, err := New(fn2, "test", 0777, nil)
+	assert.NoError(t, err)
+	defer func() {
+		db.Close()
+		db2.Close()
+		os.Remove(fn)
+		os.Remove(fn2)
+	}()
+
+	assert.True(t, codec.Equal(codec.JSON, db.Codec()))
+	assert.True(t, codec.Equal(Codec, db2.Codec()))
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	b, err := db.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Foo":"bar"}`, string(b))
+	assert.NoError(t, db.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+
+	// Stores of the same driver can use different codecs side by side
+	assert.NoError(t, db2.Set("foo", testStruct{"bar"}))
+	b, err = db2.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.NotEqual(t, `{"Foo":"bar"}`, string(b))
+	assert.NoError(t, db2.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+}
+
+// TestRawValues checks blobs are stored verbatim with codec.RawOr
+func TestRawValues(t *testing.T) {
+	var s string
+	var b []byte
+	var buf bytes.Buffer
+	fn := tmpFile()
+	db, err := New(fn, "test", 0777, nil, kv.WithCodec(codec.RawOr(codec.Gob)))
+	defer func() {
+		db.Close()
+		os.Remove(fn)
+	}()
+	assert.NoError(t, err)
+	assert.NoError(t, db.Set("foo", []byte("\x89PNG")))
+	raw, err := db.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x89PNG"), raw)
+	assert.NoError(t, db.Get("foo", &b))
+	assert.Equal(t, []byte("\x89PNG"), b)
+	assert.NoError(t, db.Set("bar", strings.NewReader("bar")))
+	assert.NoError(t, db.Get("bar", &s))
+	assert.Equal(t, "bar", s)
+	assert.NoError(t, db.Get("bar", &buf))
+	assert.Equal(t, "bar", buf.String())
+
+	// Blobs which start like an expiry header aren't mistaken for one
+	ttl := []byte("\xffTTL\x00\x00\x00\x00\x00\x00\x00\x01foo")
+	assert.NoError(t, db.Set("baz", ttl))
+	assert.NoError(t, db.Get("baz", &b))
+	assert.Equal(t, ttl, b)
+}
+
+// TestCodecBlobs checks codecs like Encrypt and Compress still apply to []byte and string values
+func TestCodecBlobs(t *testing.T) {
+	kr := codec.NewKeyring()
+	assert.NoError(t, kr.Add(1, codec.AESGCM, bytes.Repeat([]byte{1}, 32)))
+	for _, c := range []codec.Codec{codec.Encrypt(codec.Gob, kr), codec.Encrypt(codec.RawOr(codec.Gob), kr), codec.Compress(codec.Gob, codec.Gzip, 0)} {
+		var s string
+		var b []byte
+		fn := tmpFile()
+		db, err := New(fn, "test", 0777, nil, kv.WithCodec(c))
+		assert.NoError(t, err)
+		assert.NoError(t, db.Set("foo", "TOPSECRET"))
+		assert.NoError(t, db.Set("bar", []byte("TOPSECRET")))
+		for _, key := range []string{"foo", "bar"} {
+			raw, err := db.GetRaw(key)
+			assert.NoError(t, err)
+			assert.NotEqual(t, []byte("TOPSECRET"), raw, c.Name)
+			if _, err := codec.KeyID(raw); err == nil {
+				assert.False(t, bytes.Contains(raw, []byte("TOPSECRET")), c.Name)
+			}
+		}
+		assert.NoError(t, db.Get("foo", &s))
+		assert.Equal(t, "TOPSECRET", s)
+		assert.NoError(t, db.Get("bar", &b))
+		assert.Equal(t, []byte("TOPSECRET"), b)
+		db.Close()
+		os.Remove(fn)
+	}
+
+	// Blobs are re-encrypted like other values
+	fn := tmpFile()
+	defer os.Remove(fn)
+	db, err := New(fn, "test", 0777, nil, kv.WithCodec(codec.Encrypt(codec.Gob, kr)))
+	assert.NoError(t, err)
+	defer db.Close()
+	assert.NoError(t, db.Set("foo", "TOPSECRET"))
+	assert.NoError(t, kr.Add(2, codec.AESGCM, bytes.Repeat([]byte{2}, 32)))
+	assert.NoError(t, kv.Reencrypt(db, kr))
+	raw, err := db.GetRaw("foo")
+	assert.NoError(t, err)
+	id, err := codec.KeyID(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), id)
+}
+
 func TestGet(t *testing.T) {
 	v := &testStruct{"bar"}
 	vv := &testStruct{}
@@ -103,3 +231,184 @@ func TestDel(t *testing.T) {
 	assert.NoError(t, db.Del("foo"))
 	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &v))
 }
+
+func TestContext(t *testing.T) {
+	v := testStruct{"bar"}
+	vv := testStruct{}
+	fn := tmpFile()
+	db, err := New(fn, "test", 0777, nil)
+	defer func() {
+		db.Close()
+		os.Remove(fn)
+	}()
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, db.SetContext(ctx, "foo", v))
+	assert.NoError(t, db.GetContext(ctx, "foo", &vv))
+	assert.EqualValues(t, v, vv)
+
+	cancel()
+	assert.Equal(t, context.Canceled, db.SetContext(ctx, "bar", v))
+	assert.Equal(t, context.Canceled, db.GetContext(ctx, "foo", &vv))
+	assert.Equal(t, context.Canceled, db.DelContext(ctx, "foo"))
+	assert.Equal(t, kv.ErrNotFound, db.Get("bar", &vv))
+	assert.NoError(t, db.Get("foo", &vv))
+}
+
+func TestTTL(t *testing.T) {
+	v := testStruct{"bar"}
+	vv := testStruct{}
+	fn := tmpFile()
+	db, err := New(fn, "test", 0777, nil)
+	defer func() {
+		db.Close()
+		os.Remove(fn)
+	}()
+	assert.NoError(t, err)
+	assert.NoError(t, db.SetWithTTL("foo", v, time.Minute))
+	assert.NoError(t, db.Get("foo", &vv))
+	assert.EqualValues(t, v, vv)
+
+	ttl, err := db.TTL("foo")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+
+	assert.NoError(t, db.Touch("foo", 0))
+	ttl, err = db.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+
+	assert.NoError(t, db.Touch("foo", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, kv.ErrCacheMiss, db.Get("foo", &vv))
+	assert.Equal(t, kv.ErrCacheMiss, db.Touch("foo", time.Minute))
+	_, err = db.TTL("foo")
+	assert.Equal(t, kv.ErrCacheMiss, err)
+
+	_, err = db.TTL("bar")
+	assert.Equal(t, kv.ErrNotFound, err)
+	assert.Equal(t, kv.ErrNotFound, db.Touch("bar", time.Minute))
+}
+
+func TestSweep(t *testing.T) {
+	v := testStruct{"bar"}
+	vv := testStruct{}
+	fn := tmpFile()
+	db, err := New(fn, "test", 0777, nil)
+	defer func() {
+		db.Close()
+		os.Remove(fn)
+	}()
+	assert.NoError(t, err)
+	assert.NoError(t, db.SetWithTTL("foo", v, time.Millisecond))
+	assert.NoError(t, db.SetWithTTL("bar", v, time.Minute))
+	assert.NoError(t, db.Set("baz", v))
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, db.Sweep())
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &vv))
+	assert.NoError(t, db.Get("bar", &vv))
+	assert.NoError(t, db.Get("baz", &vv))
+}
+
+func TestMulti(t *testing.T) {
+	fn := tmpFile()
+	db, err := New(fn, "test", 0777, nil)
+	defer func() {
+		db.Close()
+		os.Remove(fn)
+	}()
+	assert.NoError(t, err)
+	assert.NoError(t, db.SetMulti(map[string]interface{}{
+		"foo": testStruct{"foo"},
+		"bar": testStruct{"bar"},
+	}))
+
+	m := map[string]testStruct{}
+	assert.NoError(t, db.GetMulti([]string{"foo", "bar", "baz"}, m))
+	assert.Equal(t, map[string]testStruct{"foo": {"foo"}, "bar": {"bar"}}, m)
+
+	var sl []*testStruct
+	assert.NoError(t, db.GetMulti([]string{"foo", "baz", "bar"}, &sl))
+	assert.Equal(t, []*testStruct{{"foo"}, nil, {"bar"}}, sl)
+
+	assert.NoError(t, db.DelMulti([]string{"foo", "bar", "baz"}))
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &testStruct{}))
+	assert.Equal(t, kv.ErrNotFound, db.Get("bar", &testStruct{}))
+}
+
+func TestKeysTransferClear(t *testing.T) {
+	vv := testStruct{}
+	fn, fn2 := tmpFile(), tmpFile()
+	db, err := New(fn, "test", 0777, nil)
+	assert.NoError(t, err)
+	db2, err := New(fn2, "test", 0777, nil)
+	assert.NoError(t, err)
+	defer func() {
+		db.Close()
+		db2.Close()
+		os.Remove(fn)
+		os.Remove(fn2)
+	}()
+
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, db.SetWithTTL("bar", testStruct{"bar"}, time.Minute))
+	assert.NoError(t, db.SetWithTTL("baz", testStruct{"bar"}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	keys := db.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+
+	assert.NoError(t, db.Transfer(db2))
+	keys = db2.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+	assert.NoError(t, db2.Get("foo", &vv))
+	assert.EqualValues(t, testStruct{"bar"}, vv)
+	ttl, err := db2.TTL("bar")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+
+	assert.NoError(t, db.Clear())
+	assert.Len(t, db.Keys(), 0)
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &vv))
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	assert.Len(t, db.Keys(), 1)
+}
+
+func TestWatch(t *testing.T) {
+	var vv testStruct
+	fn := tmpFile()
+	db, err := New(fn, "test", 0777, nil)
+	assert.NoError(t, err)
+	defer func() {
+		db.Close()
+		os.Remove(fn)
+	}()
+
+	events, cancel := db.Watch("foo")
+	assert.NoError(t, db.Set("bar", testStruct{"bar"}))
+	assert.NoError(t, db.SetWithTTL("foo", testStruct{"foo"}, time.Minute))
+	assert.NoError(t, db.Touch("foo", time.Hour))
+	assert.NoError(t, db.Update(func(tx kv.Tx) error {
+		return tx.Del("foo")
+	}))
+
+	e := <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+
+	// Touch rewrites the key, so watchers see the unchanged value again
+	vv = testStruct{}
+	e = <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "foo"}, <-events)
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+}
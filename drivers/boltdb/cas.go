@@ -0,0 +1,84 @@
+package boltdb
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+)
+
+// current returns the encoded value of the key without its expiry header, or nil if the key
+// doesn't exist or has expired
+func current(b *bolt.Bucket, key string) []byte {
+	val := b.Get([]byte(key))
+	if val == nil {
+		return nil
+	}
+	val, exp := codec.SplitExpiry(val)
+	if codec.Expired(exp) {
+		return nil
+	}
+	return val
+}
+
+// GetWithVersion implements the "kv.CAS".GetWithVersion() interface. The version is a hash of
+// the encoded value.
+func (d *DB) GetWithVersion(key string, dstVal interface{}) (version uint64, err error) {
+	err = d.DB().View(func(tx *bolt.Tx) error {
+		val := tx.Bucket([]byte(d.bucket)).Get([]byte(key))
+		if val == nil {
+			return kv.ErrNotFound
+		}
+		val, exp := codec.SplitExpiry(val)
+		if codec.Expired(exp) {
+			return kv.ErrCacheMiss
+		}
+		version = kv.Version(val)
+		return d.Codec().Unmarshal(val, dstVal)
+	})
+	return
+}
+
+// SetIfVersion implements the "kv.CAS".SetIfVersion() interface. The version is checked and the
+// value written within the same transaction.
+func (d *DB) SetIfVersion(key string, value interface{}, version uint64) (uint64, error) {
+	return d.setIf(key, value, func(cur []byte) bool {
+		return cur != nil && kv.Version(cur) == version
+	})
+}
+
+// SetIfNotExists implements the "kv.CAS".SetIfNotExists() interface
+func (d *DB) SetIfNotExists(key string, value interface{}) (uint64, error) {
+	return d.setIf(key, value, func(cur []byte) bool {
+		return cur == nil
+	})
+}
+
+// setIf sets the value if ok returns true for the current value of the key
+func (d *DB) setIf(key string, value interface{}, ok func(cur []byte) bool) (uint64, error) {
+	b, err := d.Codec().Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	err = d.update(func(bucket *bolt.Bucket, c *changes) error {
+		if !ok(current(bucket, key)) {
+			return kv.ErrVersionMismatch
+		}
+		return c.put(bucket, []byte(key), codec.WithExpiry(b, time.Time{}))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return kv.Version(b), nil
+}
+
+// DelIfVersion implements the "kv.CAS".DelIfVersion() interface
+func (d *DB) DelIfVersion(key string, version uint64) error {
+	return d.update(func(bucket *bolt.Bucket, c *changes) error {
+		if cur := current(bucket, key); cur == nil || kv.Version(cur) != version {
+			return kv.ErrVersionMismatch
+		}
+		return c.del(bucket, []byte(key))
+	})
+}
@@ -0,0 +1,92 @@
+package boltdb
+
+import (
+	"bytes"
+
+	"github.com/boltdb/bolt"
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+)
+
+// iterator is a "kv.Iterator" over a bolt cursor. It holds a read-only transaction open until closed.
+type iterator struct {
+	d      *DB
+	tx     *bolt.Tx
+	c      *bolt.Cursor
+	prefix []byte
+	start  []byte
+	end    []byte
+
+	started bool
+	key     []byte
+	val     []byte
+	err     error
+}
+
+// Scan implements the "kv.Scanner".Scan() interface using a bolt cursor. Keys are returned in
+// byte order. The iterator holds a read-only transaction, so it must be closed when done.
+func (d *DB) Scan(prefix, start, end string) kv.Iterator {
+	tx, err := d.DB().Begin(false)
+	if err != nil {
+		return &iterator{err: err}
+	}
+	it := &iterator{d: d, tx: tx, c: tx.Bucket([]byte(d.bucket)).Cursor(), prefix: []byte(prefix), start: []byte(start)}
+	if end != "" {
+		it.end = []byte(end)
+	}
+	if bytes.Compare(it.start, it.prefix) < 0 {
+		it.start = it.prefix
+	}
+	return it
+}
+
+// Next implements the "kv.Iterator".Next() interface
+func (it *iterator) Next() bool {
+	if it.err != nil || it.c == nil {
+		return false
+	}
+	for {
+		if !it.started {
+			it.key, it.val = it.c.Seek(it.start)
+			it.started = true
+		} else {
+			it.key, it.val = it.c.Next()
+		}
+		if it.key == nil || !bytes.HasPrefix(it.key, it.prefix) || (it.end != nil && bytes.Compare(it.key, it.end) >= 0) {
+			it.key, it.val, it.c = nil, nil, nil
+			return false
+		}
+		if _, exp := codec.SplitExpiry(it.val); !codec.Expired(exp) {
+			return true
+		}
+	}
+}
+
+// Key implements the "kv.Iterator".Key() interface
+func (it *iterator) Key() string {
+	return string(it.key)
+}
+
+// Value implements the "kv.Iterator".Value() interface
+func (it *iterator) Value(dstVal interface{}) error {
+	if it.val == nil {
+		return kv.ErrNotFound
+	}
+	return it.d.unmarshal(it.val, dstVal)
+}
+
+// Err implements the "kv.Iterator".Err() interface
+func (it *iterator) Err() error {
+	return it.err
+}
+
+// Close implements the "kv.Iterator".Close() interface, releasing the transaction
+func (it *iterator) Close() error {
+	it.c = nil
+	if it.tx == nil {
+		return nil
+	}
+	tx := it.tx
+	it.tx = nil
+	return tx.Rollback()
+}
@@ -0,0 +1,58 @@
+package boltdb
+
+import (
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/bradberger/gokv/kv"
+)
+
+// tx is a "kv.Tx" wrapping the bucket of a bolt transaction
+type tx struct {
+	d *DB
+	b *bolt.Bucket
+	c *changes
+}
+
+// Update implements the "kv.Transactional".Update() interface using a bolt read/write transaction
+func (d *DB) Update(fn func(tx kv.Tx) error) error {
+	return d.update(func(b *bolt.Bucket, c *changes) error {
+		return fn(&tx{d, b, c})
+	})
+}
+
+// View implements the "kv.Transactional".View() interface using a bolt read-only transaction
+func (d *DB) View(fn func(tx kv.Tx) error) error {
+	return d.DB().View(func(btx *bolt.Tx) error {
+		return fn(&tx{d: d, b: btx.Bucket([]byte(d.bucket))})
+	})
+}
+
+// Set implements the "kv.Tx".Set() interface
+func (t *tx) Set(key string, value interface{}) error {
+	if !t.b.Tx().Writable() {
+		return kv.ErrReadOnly
+	}
+	b, err := t.d.marshal(value, time.Time{})
+	if err != nil {
+		return err
+	}
+	return t.c.put(t.b, []byte(key), b)
+}
+
+// Get implements the "kv.Tx".Get() interface
+func (t *tx) Get(key string, dstVal interface{}) error {
+	val := t.b.Get([]byte(key))
+	if val == nil {
+		return kv.ErrNotFound
+	}
+	return t.d.unmarshal(val, dstVal)
+}
+
+// Del implements the "kv.Tx".Del() interface
+func (t *tx) Del(key string) error {
+	if !t.b.Tx().Writable() {
+		return kv.ErrReadOnly
+	}
+	return t.c.del(t.b, []byte(key))
+}
@@ -0,0 +1,11 @@
+package boltdb
+
+import (
+	"testing"
+
+	"github.com/bradberger/gokv/kv/kvtest"
+)
+
+func TestTransactional(t *testing.T) {
+	kvtest.Transactional(t, newTestStore)
+}
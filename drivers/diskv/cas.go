@@ -0,0 +1,89 @@
+package diskv
+
+import (
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+)
+
+// current returns the encoded value of the key without its expiry header, or nil if the key
+// doesn't exist or has expired. The key must be locked by the caller.
+func (d *Diskv) current(key string) ([]byte, error) {
+	b, err := d.readDirect(key)
+	if err == kv.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return nil, nil
+	}
+	return b, nil
+}
+
+// GetWithVersion implements the "kv.CAS".GetWithVersion() interface. The version is a hash of
+// the encoded value, which is always read from disk rather than the cache.
+func (d *Diskv) GetWithVersion(key string, dstVal interface{}) (uint64, error) {
+	b, err := d.readDirect(key)
+	if err != nil {
+		return 0, err
+	}
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return 0, kv.ErrCacheMiss
+	}
+	return kv.Version(b), d.Codec().Unmarshal(b, dstVal)
+}
+
+// SetIfVersion implements the "kv.CAS".SetIfVersion() interface. Diskv has no conditional
+// writes, so the key is locked while the version is checked and the value written.
+func (d *Diskv) SetIfVersion(key string, val interface{}, version uint64) (uint64, error) {
+	return d.setIf(key, val, func(cur []byte) bool {
+		return cur != nil && kv.Version(cur) == version
+	})
+}
+
+// SetIfNotExists implements the "kv.CAS".SetIfNotExists() interface
+func (d *Diskv) SetIfNotExists(key string, val interface{}) (uint64, error) {
+	return d.setIf(key, val, func(cur []byte) bool {
+		return cur == nil
+	})
+}
+
+// setIf sets the value if ok returns true for the current value of the key
+func (d *Diskv) setIf(key string, val interface{}, ok func(cur []byte) bool) (uint64, error) {
+	b, err := d.Codec().Marshal(val)
+	if err != nil {
+		return 0, err
+	}
+	d.locks.Lock(key)
+	defer d.locks.Unlock(key)
+	cur, err := d.current(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok(cur) {
+		return 0, kv.ErrVersionMismatch
+	}
+	if err := d.writeLocked(key, codec.WithExpiry(b, time.Time{})); err != nil {
+		return 0, err
+	}
+	return kv.Version(b), nil
+}
+
+// DelIfVersion implements the "kv.CAS".DelIfVersion() interface
+func (d *Diskv) DelIfVersion(key string, version uint64) error {
+	d.locks.Lock(key)
+	defer d.locks.Unlock(key)
+	cur, err := d.current(key)
+	if err != nil {
+		return err
+	}
+	if cur == nil || kv.Version(cur) != version {
+		return kv.ErrVersionMismatch
+	}
+	return d.eraseLocked(key)
+}
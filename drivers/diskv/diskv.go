@@ -1,19 +1,32 @@
 package diskv
 
 import (
+	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/bradberger/gokv/codec"
 	"github.com/bradberger/gokv/kv"
 	"github.com/peterbourgon/diskv"
+	"golang.org/x/net/context"
 )
 
 var (
-	// Codec is the codec used to marshal/unmarshal interfaces into the byte slices required by the Diskv client
+	// Codec is the codec used to marshal/unmarshal interfaces into the byte slices required by the Diskv client,
+	// for stores created without the kv.WithCodec option
 	Codec codec.Codec
 
 	// ensure struct implements the kv.Store interface
-	_ kv.Store = (*Diskv)(nil)
+	_ kv.Store        = (*Diskv)(nil)
+	_ kv.ContextStore = (*Diskv)(nil)
+	_ kv.Expirer      = (*Diskv)(nil)
+	_ kv.Sweeper      = (*Diskv)(nil)
+	_ kv.Scanner      = (*Diskv)(nil)
+	_ kv.Datastore    = (*Diskv)(nil)
+	_ kv.Clearer      = (*Diskv)(nil)
+	_ kv.RawStore     = (*Diskv)(nil)
+	_ kv.CAS          = (*Diskv)(nil)
+	_ kv.Watcher      = (*Diskv)(nil)
 )
 
 func init() {
@@ -22,38 +35,138 @@ func init() {
 
 // Diskv is a Diskv backed key/value store
 type Diskv struct {
-	dv *diskv.Diskv
+	dv   *diskv.Diskv
+	opts kv.Options
+
+	// locks serializes writes to a key so read-modify-write operations like Touch are atomic
+	locks kv.KeyLock
+	hub   kv.Hub
 }
 
-// New returns a new Diskv backed key/value store
-func New(opts diskv.Options) *Diskv {
-	return &Diskv{dv: diskv.New(opts)}
+// New returns a new Diskv backed key/value store. Options like kv.WithCodec configure the store itself.
+func New(options diskv.Options, opts ...kv.Option) *Diskv {
+	return &Diskv{dv: diskv.New(options), opts: kv.NewOptions(opts...)}
 }
 
 // Set implements the "kv.Cache".Set() interface
 func (d *Diskv) Set(key string, value interface{}) error {
-	b, err := Codec.Marshal(value)
+	return d.SetContext(context.Background(), key, value)
+}
+
+// Get implements the "kv.Cache".Get() interface
+func (d *Diskv) Get(key string, dstVal interface{}) error {
+	return d.GetContext(context.Background(), key, dstVal)
+}
+
+// Del implements the "kv.Cache".Del() interface
+func (d *Diskv) Del(key string) error {
+	return d.DelContext(context.Background(), key)
+}
+
+// SetContext implements the "kv.ContextStore".SetContext() interface
+func (d *Diskv) SetContext(ctx context.Context, key string, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, err := d.marshal(value, time.Time{})
 	if err != nil {
 		return err
 	}
-	return d.dv.Write(key, b)
+	return d.write(key, b)
 }
 
-// Get implements the "kv.Cache".Get() interface
-func (d *Diskv) Get(key string, dstVal interface{}) error {
+func (d *Diskv) write(key string, b []byte) error {
+	d.locks.Lock(key)
+	defer d.locks.Unlock(key)
+	return d.writeLocked(key, b)
+}
+
+// writeLocked writes the value and notifies watchers. The key must be locked by the caller.
+func (d *Diskv) writeLocked(key string, b []byte) error {
+	if err := d.dv.Write(key, b); err != nil {
+		return err
+	}
+	b, _ = codec.SplitExpiry(b)
+	d.hub.Put(key, b)
+	return nil
+}
+
+// eraseLocked erases the key and notifies watchers. The key must be locked by the caller.
+func (d *Diskv) eraseLocked(key string) error {
+	if err := d.dv.Erase(key); err != nil {
+		return err
+	}
+	d.hub.Delete(key)
+	return nil
+}
+
+// Watch implements the "kv.Watcher".Watch() interface for changes made through this Diskv
+func (d *Diskv) Watch(prefix string) (<-chan kv.Event, func()) {
+	return d.hub.Watch(prefix)
+}
+
+// GetContext implements the "kv.ContextStore".GetContext() interface
+func (d *Diskv) GetContext(ctx context.Context, key string, dstVal interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, err := d.read(key)
+	if err != nil {
+		return err
+	}
+	return d.unmarshal(b, dstVal)
+}
+
+func (d *Diskv) read(key string) ([]byte, error) {
 	b, err := d.dv.Read(key)
+	if err != nil && strings.HasSuffix(err.Error(), "no such file or directory") {
+		err = kv.ErrNotFound
+	}
+	return b, err
+}
+
+// readDirect reads the key from disk, bypassing and evicting any cached value. The cache can
+// briefly hold a stale value when a read races a write, which CAS operations and other locked
+// read-modify-writes like Touch and Sweep can't tolerate.
+func (d *Diskv) readDirect(key string) ([]byte, error) {
+	rc, err := d.dv.ReadStream(key, true)
 	if err != nil {
 		if strings.HasSuffix(err.Error(), "no such file or directory") {
 			err = kv.ErrNotFound
 		}
-		return err
+		return nil, err
 	}
-	return Codec.Unmarshal(b, dstVal)
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
 }
 
-// Del implements the "kv.Cache".Del() interface
-func (d *Diskv) Del(key string) error {
-	if err := d.Diskv().Erase(key); err != nil {
+// marshal encodes a value to be stored with the expiration exp, which is kept in a header in
+// front of the encoded value. Values which never expire only get a header if they start like one.
+func (d *Diskv) marshal(value interface{}, exp time.Time) ([]byte, error) {
+	b, err := d.Codec().Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return codec.WithExpiry(b, exp), nil
+}
+
+// unmarshal decodes a stored value, returning kv.ErrCacheMiss if it has expired
+func (d *Diskv) unmarshal(b []byte, dstVal interface{}) error {
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return kv.ErrCacheMiss
+	}
+	return d.Codec().Unmarshal(b, dstVal)
+}
+
+// DelContext implements the "kv.ContextStore".DelContext() interface
+func (d *Diskv) DelContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d.locks.Lock(key)
+	defer d.locks.Unlock(key)
+	if err := d.eraseLocked(key); err != nil {
 		if strings.Contains(err.Error(), "no such file or directory") {
 			err = kv.ErrNotFound
 		}
@@ -62,6 +175,133 @@ func (d *Diskv) Del(key string) error {
 	return nil
 }
 
+// SetWithTTL implements the "kv.Expirer".SetWithTTL() interface. The expiration is stored
+// in a header in front of the encoded value.
+func (d *Diskv) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	b, err := d.marshal(value, codec.ExpiresAt(ttl))
+	if err != nil {
+		return err
+	}
+	return d.write(key, b)
+}
+
+// TTL implements the "kv.Expirer".TTL() interface
+func (d *Diskv) TTL(key string) (time.Duration, error) {
+	b, err := d.read(key)
+	if err != nil {
+		return 0, err
+	}
+	_, exp := codec.SplitExpiry(b)
+	switch {
+	case exp.IsZero():
+		return kv.NoExpiration, nil
+	case codec.Expired(exp):
+		return 0, kv.ErrCacheMiss
+	}
+	return exp.Sub(time.Now()), nil
+}
+
+// Touch implements the "kv.Expirer".Touch() interface. The value is read from disk so a stale
+// cached value is never written back.
+func (d *Diskv) Touch(key string, ttl time.Duration) error {
+	d.locks.Lock(key)
+	defer d.locks.Unlock(key)
+	b, err := d.readDirect(key)
+	if err != nil {
+		return err
+	}
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return kv.ErrCacheMiss
+	}
+	return d.writeLocked(key, codec.WithExpiry(b, codec.ExpiresAt(ttl)))
+}
+
+// Sweep implements the "kv.Sweeper".Sweep() interface, erasing all expired keys.
+// Use kv.SweepEvery() to run it periodically.
+func (d *Diskv) Sweep() error {
+	cancel := make(chan struct{})
+	defer close(cancel)
+	for key := range d.dv.Keys(cancel) {
+		if err := d.eraseExpired(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eraseExpired erases the key if it's expired
+func (d *Diskv) eraseExpired(key string) error {
+	d.locks.Lock(key)
+	defer d.locks.Unlock(key)
+	b, err := d.readDirect(key)
+	if err != nil {
+		if err == kv.ErrNotFound {
+			err = nil
+		}
+		return err
+	}
+	if _, exp := codec.SplitExpiry(b); !codec.Expired(exp) {
+		return nil
+	}
+	return d.eraseLocked(key)
+}
+
+// GetRaw implements the "kv.RawStore".GetRaw() interface, returning the value as stored, including
+// any expiry header
+func (d *Diskv) GetRaw(key string) ([]byte, error) {
+	b, err := d.read(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, exp := codec.SplitExpiry(b); codec.Expired(exp) {
+		return nil, kv.ErrCacheMiss
+	}
+	return b, nil
+}
+
+// SetRaw implements the "kv.RawStore".SetRaw() interface
+func (d *Diskv) SetRaw(key string, b []byte) error {
+	return d.write(key, b)
+}
+
+// Codec implements the "kv.RawStore".Codec() interface
+func (d *Diskv) Codec() codec.Codec {
+	return d.opts.CodecOr(Codec)
+}
+
+// Keys implements the "kv.KeyList".Keys() interface. Expired keys are left out, and since the
+// interface can't return an error, nil is returned if the files can't be read.
+func (d *Diskv) Keys() []string {
+	it := d.Scan("", "", "")
+	defer it.Close()
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil {
+		return nil
+	}
+	return keys
+}
+
+// Transfer implements the "kv.Datastore".Transfer() interface, copying all keys to dst.
+// Encoded values are copied as is if dst is a "kv.RawStore" sharing the same codec.
+func (d *Diskv) Transfer(dst kv.Store) error {
+	return kv.Transfer(d, dst)
+}
+
+// Clear implements the "kv.Clearer".Clear() interface, erasing all files and the cache.
+// Watchers receive a delete event for every key which existed when Clear was called.
+func (d *Diskv) Clear() error {
+	keys := d.Keys()
+	if err := d.dv.EraseAll(); err != nil {
+		return err
+	}
+	d.hub.Delete(keys...)
+	return nil
+}
+
 // Exists implements the "kv.Cache".Exists() interface
 func (d *Diskv) Exists(key string) bool {
 	return d.Diskv().Has(key)
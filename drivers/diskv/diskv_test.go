@@ -1,15 +1,20 @@
 package diskv
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bradberger/gokv/codec"
 	"github.com/bradberger/gokv/kv"
 	"github.com/peterbourgon/diskv"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
 )
 
 type testStruct struct {
@@ -28,6 +33,20 @@ func getTestOptions() diskv.Options {
 	}
 }
 
+// newTestDB returns a store in a temp dir which is removed once the test completes
+func newTestDB(t *testing.T) *Diskv {
+	opts := getTestOptions()
+	t.Cleanup(func() {
+		os.RemoveAll(opts.BasePath)
+	})
+	return New(opts)
+}
+
+// newTestStore returns a new store for the kvtest conformance tests
+func newTestStore(t *testing.T) kv.Store {
+	return newTestDB(t)
+}
+
 func TestNew(t *testing.T) {
 	opts := getTestOptions()
 	dv := New(opts)
@@ -51,16 +70,60 @@ func TestSet(t *testing.T) {
 func TestSetErr(t *testing.T) {
 	v := &testStruct{"foo", "bar"}
 	opts := getTestOptions()
-	dv := New(opts)
-	origCodec := Codec
-	Codec = codec.ErrTestCodec
+	dv := New(opts, kv.WithCodec(codec.ErrTestCodec))
 	defer func() {
-		Codec = origCodec
 		os.RemoveAll(opts.BasePath)
 	}()
 	assert.Error(t, dv.Set("foobar", v))
 }
 
+func TestCodec(t *testing.T) {
+	var vv testStruct
+	v := testStruct{"foo", "bar"}
+	opts := getTestOptions()
+	dv := New(opts, kv.WithCodec(codec.JSON))
+	defer func() {
+		os.RemoveAll(opts.BasePath)
+	}()
+	assert.True(t, codec.Equal(codec.JSON, dv.Codec()))
+	assert.True(t, codec.Equal(Codec, New(opts).Codec()))
+	assert.NoError(t, dv.Set("foobar", v))
+	b, err := dv.GetRaw("foobar")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"Foo":"foo","Bar":"bar"}`, string(b))
+	assert.NoError(t, dv.Get("foobar", &vv))
+	assert.Equal(t, v, vv)
+}
+
+// TestRawValues checks blobs are stored verbatim with codec.RawOr
+func TestRawValues(t *testing.T) {
+	var s string
+	var b []byte
+	var buf bytes.Buffer
+	opts := getTestOptions()
+	dv := New(opts, kv.WithCodec(codec.RawOr(codec.Gob)))
+	defer func() {
+		os.RemoveAll(opts.BasePath)
+	}()
+	assert.NoError(t, dv.Set("foo", []byte("\x89PNG")))
+	raw, err := dv.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x89PNG"), raw)
+	assert.NoError(t, dv.Get("foo", &b))
+	assert.Equal(t, []byte("\x89PNG"), b)
+	assert.NoError(t, dv.Set("bar", strings.NewReader("bar")))
+	assert.NoError(t, dv.Get("bar", &s))
+	assert.Equal(t, "bar", s)
+	assert.NoError(t, dv.Get("bar", &buf))
+	assert.Equal(t, "bar", buf.String())
+
+	// Blobs which start like an expiry header aren't mistaken for one
+	ttl := []byte("\xffTTL\x00\x00\x00\x00\x00\x00\x00\x01foo")
+	assert.NoError(t, dv.Set("baz", ttl))
+	assert.NoError(t, dv.Get("baz", &b))
+	assert.Equal(t, ttl, b)
+}
+
 func TestGet(t *testing.T) {
 	v := &testStruct{"foo", "bar"}
 	vv := &testStruct{}
@@ -85,13 +148,9 @@ func TestGetErr(t *testing.T) {
 func TestGetCodecErr(t *testing.T) {
 	var v testStruct
 	opts := getTestOptions()
-	dv := New(opts)
-	origCodec := Codec
-	Codec = codec.ErrTestCodec
-	Codec.Marshal = json.Marshal
-	defer func() {
-		Codec = origCodec
-	}()
+	c := codec.ErrTestCodec
+	c.Marshal = json.Marshal
+	dv := New(opts, kv.WithCodec(c))
 	assert.NoError(t, dv.Set("foobar", &v))
 	os.RemoveAll(opts.BasePath)
 	assert.EqualError(t, dv.Get("foobar", &v), "not found")
@@ -106,3 +165,143 @@ func TestDel(t *testing.T) {
 	assert.False(t, dv.Exists("foobar"))
 	assert.Equal(t, kv.ErrNotFound, dv.Del("foobar"))
 }
+
+func TestContext(t *testing.T) {
+	v := &testStruct{"foo", "bar"}
+	vv := &testStruct{}
+	opts := getTestOptions()
+	dv := New(opts)
+	defer func() {
+		os.RemoveAll(opts.BasePath)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, dv.SetContext(ctx, "foobar", v))
+	assert.NoError(t, dv.GetContext(ctx, "foobar", vv))
+	assert.EqualValues(t, v, vv)
+
+	cancel()
+	assert.Equal(t, context.Canceled, dv.SetContext(ctx, "barfoo", v))
+	assert.Equal(t, context.Canceled, dv.GetContext(ctx, "foobar", vv))
+	assert.Equal(t, context.Canceled, dv.DelContext(ctx, "foobar"))
+	assert.False(t, dv.Exists("barfoo"))
+	assert.True(t, dv.Exists("foobar"))
+}
+
+func TestTTL(t *testing.T) {
+	v := testStruct{"foo", "bar"}
+	vv := testStruct{}
+	opts := getTestOptions()
+	db := New(opts)
+	defer func() {
+		os.RemoveAll(opts.BasePath)
+	}()
+	assert.NoError(t, db.SetWithTTL("foo", v, time.Minute))
+	assert.NoError(t, db.Get("foo", &vv))
+	assert.EqualValues(t, v, vv)
+
+	ttl, err := db.TTL("foo")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+
+	assert.NoError(t, db.Touch("foo", 0))
+	ttl, err = db.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+
+	assert.NoError(t, db.Touch("foo", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, kv.ErrCacheMiss, db.Get("foo", &vv))
+	assert.Equal(t, kv.ErrCacheMiss, db.Touch("foo", time.Minute))
+	_, err = db.TTL("foo")
+	assert.Equal(t, kv.ErrCacheMiss, err)
+
+	_, err = db.TTL("bar")
+	assert.Equal(t, kv.ErrNotFound, err)
+	assert.Equal(t, kv.ErrNotFound, db.Touch("bar", time.Minute))
+}
+
+func TestSweep(t *testing.T) {
+	v := testStruct{"foo", "bar"}
+	vv := testStruct{}
+	opts := getTestOptions()
+	db := New(opts)
+	defer func() {
+		os.RemoveAll(opts.BasePath)
+	}()
+	assert.NoError(t, db.SetWithTTL("foo", v, time.Millisecond))
+	assert.NoError(t, db.SetWithTTL("bar", v, time.Minute))
+	assert.NoError(t, db.Set("baz", v))
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, db.Sweep())
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &vv))
+	assert.NoError(t, db.Get("bar", &vv))
+	assert.NoError(t, db.Get("baz", &vv))
+}
+
+func TestKeysTransferClear(t *testing.T) {
+	vv := testStruct{}
+	opts, opts2 := getTestOptions(), getTestOptions()
+	db, db2 := New(opts), New(opts2)
+	defer func() {
+		os.RemoveAll(opts.BasePath)
+		os.RemoveAll(opts2.BasePath)
+	}()
+
+	assert.NoError(t, db.Set("foo", testStruct{"foo", "bar"}))
+	assert.NoError(t, db.SetWithTTL("bar", testStruct{"foo", "bar"}, time.Minute))
+	assert.NoError(t, db.SetWithTTL("baz", testStruct{"foo", "bar"}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	keys := db.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+
+	assert.NoError(t, db.Transfer(db2))
+	keys = db2.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+	assert.NoError(t, db2.Get("foo", &vv))
+	assert.EqualValues(t, testStruct{"foo", "bar"}, vv)
+	ttl, err := db2.TTL("bar")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+
+	assert.NoError(t, db.Clear())
+	assert.Len(t, db.Keys(), 0)
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &vv))
+	assert.NoError(t, db.Set("foo", testStruct{"foo", "bar"}))
+	assert.Len(t, db.Keys(), 1)
+}
+
+func TestWatch(t *testing.T) {
+	var vv testStruct
+	opts := getTestOptions()
+	db := New(opts)
+	defer func() {
+		os.RemoveAll(opts.BasePath)
+	}()
+
+	events, cancel := db.Watch("foo")
+	assert.NoError(t, db.Set("bar", testStruct{"foo", "bar"}))
+	assert.NoError(t, db.SetWithTTL("foo", testStruct{"foo", "bar"}, time.Minute))
+	assert.NoError(t, db.Touch("foo", time.Hour))
+	assert.NoError(t, db.Clear())
+
+	e := <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo", "bar"}, vv)
+
+	// Touch rewrites the key, so watchers see the unchanged value again
+	vv = testStruct{}
+	e = <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo", "bar"}, vv)
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "foo"}, <-events)
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+}
@@ -0,0 +1,80 @@
+package diskv
+
+import (
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+)
+
+// iterator is a "kv.Iterator" over the keys channel of diskv
+type iterator struct {
+	d          *Diskv
+	keys       <-chan string
+	cancel     chan struct{}
+	start, end string
+
+	key string
+	val []byte
+	err error
+}
+
+// Scan implements the "kv.Scanner".Scan() interface using diskv's KeysPrefix channel. Diskv
+// walks the file system, so keys are not returned in any particular order.
+func (d *Diskv) Scan(prefix, start, end string) kv.Iterator {
+	cancel := make(chan struct{})
+	return &iterator{d: d, keys: d.dv.KeysPrefix(prefix, cancel), cancel: cancel, start: start, end: end}
+}
+
+// Next implements the "kv.Iterator".Next() interface, skipping keys outside of the range
+// and expired keys. Keys erased since the scan started are skipped as well.
+func (it *iterator) Next() bool {
+	if it.err != nil || it.cancel == nil {
+		return false
+	}
+	for key := range it.keys {
+		if !kv.InRange(key, "", it.start, it.end) {
+			continue
+		}
+		b, err := it.d.read(key)
+		if err == kv.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			it.err = err
+			return false
+		}
+		if _, exp := codec.SplitExpiry(b); codec.Expired(exp) {
+			continue
+		}
+		it.key, it.val = key, b
+		return true
+	}
+	it.key, it.val = "", nil
+	return false
+}
+
+// Key implements the "kv.Iterator".Key() interface
+func (it *iterator) Key() string {
+	return it.key
+}
+
+// Value implements the "kv.Iterator".Value() interface
+func (it *iterator) Value(dstVal interface{}) error {
+	if it.val == nil {
+		return kv.ErrNotFound
+	}
+	return it.d.unmarshal(it.val, dstVal)
+}
+
+// Err implements the "kv.Iterator".Err() interface
+func (it *iterator) Err() error {
+	return it.err
+}
+
+// Close implements the "kv.Iterator".Close() interface, stopping the directory walk
+func (it *iterator) Close() error {
+	if it.cancel != nil {
+		close(it.cancel)
+		it.cancel = nil
+	}
+	return nil
+}
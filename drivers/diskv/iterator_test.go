@@ -0,0 +1,11 @@
+package diskv
+
+import (
+	"testing"
+
+	"github.com/bradberger/gokv/kv/kvtest"
+)
+
+func TestScan(t *testing.T) {
+	kvtest.Scan(t, newTestStore, kvtest.ScanOptions{Unordered: true, SetExpired: kvtest.ExpireSoon})
+}
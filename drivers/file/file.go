@@ -0,0 +1,390 @@
+// Package file implements a human editable key/value store keeping the whole dataset in a single
+// JSON or YAML document. It's meant for small datasets like config and test fixtures.
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+)
+
+var (
+	// ErrInvalidJSON is returned by SetRaw for values which aren't valid JSON
+	ErrInvalidJSON = errors.New("file: value is not valid JSON")
+
+	// ensure struct implements the kv.Store interface
+	_ kv.Store     = (*File)(nil)
+	_ kv.Batcher   = (*File)(nil)
+	_ kv.Datastore = (*File)(nil)
+	_ kv.Clearer   = (*File)(nil)
+	_ kv.RawStore  = (*File)(nil)
+	_ kv.Watcher   = (*File)(nil)
+)
+
+// Options configures a File
+type Options struct {
+	// Format is the format of the document. It defaults to Auto, which picks it from the extension
+	// of the path.
+	Format Format
+	// ManualFlush defers writing the document until Flush or Close are called, rather than writing
+	// it after every change
+	ManualFlush bool
+}
+
+// File is a key/value store backed by a JSON or YAML document. Keys are the top level keys of the
+// document and values are encoded as JSON, or the equivalent YAML, so they can be edited by hand.
+// The document is rewritten atomically through a temp file renamed over it, and it's reloaded
+// whenever its modification time or size changes, so external edits are picked up. Pending changes
+// take precedence over external edits when ManualFlush is set, since the document is then
+// overwritten on the next Flush.
+type File struct {
+	path   string
+	format Format
+	manual bool
+
+	mu      sync.Mutex
+	data    map[string]json.RawMessage
+	dirty   bool
+	modTime time.Time
+	size    int64
+
+	hub kv.Hub
+}
+
+// New returns a new key/value store backed by the document at path. The document is loaded if it
+// exists, otherwise it's created on the first write.
+func New(path string, opts Options) (*File, error) {
+	f := &File{
+		path:   path,
+		format: formatOf(path, opts.Format),
+		manual: opts.ManualFlush,
+		data:   map[string]json.RawMessage{},
+	}
+	if err := f.refresh(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// refresh reloads the document if it changed since it was last read or written. Watchers are
+// notified of the keys the edit changed. The lock must be held by the caller.
+func (f *File) refresh() error {
+	if f.dirty {
+		return nil
+	}
+	fi, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		if !f.modTime.IsZero() {
+			// The document was removed, which clears the store
+			f.modTime, f.size = time.Time{}, 0
+			f.replace(map[string]json.RawMessage{})
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.ModTime().Equal(f.modTime) && fi.Size() == f.size {
+		return nil
+	}
+	b, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	data, err := decode(b, f.format)
+	if err != nil {
+		return err
+	}
+	f.modTime, f.size = fi.ModTime(), fi.Size()
+	f.replace(data)
+	return nil
+}
+
+// replace swaps the dataset for data, notifying watchers of the differences
+func (f *File) replace(data map[string]json.RawMessage) {
+	old := f.data
+	f.data = data
+	for key, val := range data {
+		if cur, ok := old[key]; !ok || string(cur) != string(val) {
+			f.hub.Put(key, val)
+		}
+	}
+	for key := range old {
+		if _, ok := data[key]; !ok {
+			f.hub.Delete(key)
+		}
+	}
+}
+
+// write writes the document unless writes are deferred to Flush. The lock must be held by the caller.
+func (f *File) write() error {
+	if f.manual {
+		f.dirty = true
+		return nil
+	}
+	return f.flush()
+}
+
+// flush writes the document to a temp file in the same directory and renames it over the document,
+// so readers never see a partially written document. The lock must be held by the caller.
+func (f *File) flush() error {
+	b, err := encode(f.data, f.format)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), "."+filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(f.path); err == nil {
+		mode = fi.Mode()
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	f.modTime, f.size, f.dirty = fi.ModTime(), fi.Size(), false
+	return nil
+}
+
+// Flush writes pending changes to the document. It's only needed when ManualFlush is set.
+func (f *File) Flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.dirty {
+		return nil
+	}
+	return f.flush()
+}
+
+// Close flushes pending changes
+func (f *File) Close() error {
+	return f.Flush()
+}
+
+// Path returns the path of the document
+func (f *File) Path() string {
+	return f.path
+}
+
+// Set implements the "kv.Store".Set() interface
+func (f *File) Set(key string, value interface{}) error {
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return f.set(key, b)
+}
+
+// set sets the JSON encoded value of the key
+func (f *File) set(key string, b []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.refresh(); err != nil {
+		return err
+	}
+	return f.update(func(data map[string]json.RawMessage) {
+		data[key] = b
+	})
+}
+
+// Get implements the "kv.Store".Get() interface
+func (f *File) Get(key string, dstVal interface{}) error {
+	b, err := f.GetRaw(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dstVal)
+}
+
+// Del implements the "kv.Store".Del() interface
+func (f *File) Del(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.refresh(); err != nil {
+		return err
+	}
+	if _, ok := f.data[key]; !ok {
+		return kv.ErrNotFound
+	}
+	return f.update(func(data map[string]json.RawMessage) {
+		delete(data, key)
+	})
+}
+
+// SetMulti implements the "kv.Batcher".SetMulti() interface, writing the document once
+func (f *File) SetMulti(items map[string]interface{}) error {
+	encoded := make(map[string]json.RawMessage, len(items))
+	for key, value := range items {
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		encoded[key] = b
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.refresh(); err != nil {
+		return err
+	}
+	return f.update(func(data map[string]json.RawMessage) {
+		for key, b := range encoded {
+			data[key] = b
+		}
+	})
+}
+
+// GetMulti implements the "kv.Batcher".GetMulti() interface
+func (f *File) GetMulti(keys []string, dst interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.refresh(); err != nil {
+		return err
+	}
+	return kv.FillMulti(keys, dst, func(i int, key string, dstVal interface{}) error {
+		b, ok := f.data[key]
+		if !ok {
+			return kv.ErrNotFound
+		}
+		return json.Unmarshal(b, dstVal)
+	})
+}
+
+// DelMulti implements the "kv.Batcher".DelMulti() interface, writing the document once. Missing
+// keys are ignored.
+func (f *File) DelMulti(keys []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.refresh(); err != nil {
+		return err
+	}
+	return f.update(func(data map[string]json.RawMessage) {
+		for _, key := range keys {
+			delete(data, key)
+		}
+	})
+}
+
+// update applies fn to a copy of the dataset and writes it, keeping the current dataset if the
+// write fails. Watchers are notified of the changes. The lock must be held by the caller.
+func (f *File) update(fn func(data map[string]json.RawMessage)) error {
+	old := f.data
+	data := make(map[string]json.RawMessage, len(old))
+	for key, val := range old {
+		data[key] = val
+	}
+	fn(data)
+
+	f.data = data
+	if err := f.write(); err != nil {
+		f.data = old
+		return err
+	}
+	f.data = old
+	f.replace(data)
+	return nil
+}
+
+// GetRaw implements the "kv.RawStore".GetRaw() interface, returning the value encoded as JSON
+func (f *File) GetRaw(key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.refresh(); err != nil {
+		return nil, err
+	}
+	b, ok := f.data[key]
+	if !ok {
+		return nil, kv.ErrNotFound
+	}
+	return append([]byte(nil), b...), nil
+}
+
+// SetRaw implements the "kv.RawStore".SetRaw() interface. The value must be valid JSON. The
+// document has nowhere to keep expiration times, so expired values are skipped and other expiry
+// headers are dropped.
+func (f *File) SetRaw(key string, b []byte) error {
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return nil
+	}
+	if !json.Valid(b) {
+		return ErrInvalidJSON
+	}
+	return f.set(key, append([]byte(nil), b...))
+}
+
+// Codec implements the "kv.RawStore".Codec() interface. Values are always encoded as JSON.
+func (f *File) Codec() codec.Codec {
+	return codec.JSON
+}
+
+// Keys implements the "kv.KeyList".Keys() interface, returning the keys in sorted order. Since the
+// interface can't return an error, nil is returned if the document can't be reloaded.
+func (f *File) Keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.refresh(); err != nil {
+		return nil
+	}
+	keys := make([]string, 0, len(f.data))
+	for key := range f.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Transfer implements the "kv.Datastore".Transfer() interface, copying all keys to dst.
+// Encoded values are copied as is if dst is a "kv.RawStore" using codec.JSON.
+func (f *File) Transfer(dst kv.Store) error {
+	return kv.Transfer(f, dst)
+}
+
+// Clear implements the "kv.Clearer".Clear() interface, leaving an empty document. Watchers receive
+// a delete event for every key.
+func (f *File) Clear() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.refresh(); err != nil {
+		return err
+	}
+	return f.update(func(data map[string]json.RawMessage) {
+		for key := range data {
+			delete(data, key)
+		}
+	})
+}
+
+// Watch implements the "kv.Watcher".Watch() interface. External edits are reported once they're
+// picked up by the next operation on the store.
+func (f *File) Watch(prefix string) (<-chan kv.Event, func()) {
+	return f.hub.Watch(prefix)
+}
@@ -0,0 +1,267 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/drivers/memory"
+	"github.com/bradberger/gokv/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+type testStruct struct {
+	Foo string
+}
+
+// tmpPath returns the path of a document in a temp dir which is removed once the test completes
+func tmpPath(t *testing.T, name string) string {
+	dir, err := ioutil.TempDir("", "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+	return filepath.Join(dir, name)
+}
+
+// edit writes the document as an external editor would, making sure its modification time changes
+func edit(t *testing.T, path, doc string) {
+	assert.NoError(t, ioutil.WriteFile(path, []byte(doc), 0644))
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, future, future))
+}
+
+func TestNew(t *testing.T) {
+	var v testStruct
+	fn := tmpPath(t, "data.json")
+	f, err := New(fn, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, fn, f.Path())
+	assert.Equal(t, JSON, f.format)
+	assert.Equal(t, []string{}, f.Keys())
+	_, err = os.Stat(fn)
+	assert.True(t, os.IsNotExist(err))
+
+	edit(t, fn, `{"foo": {"Foo": "bar"}}`)
+	f, err = New(fn, Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+}
+
+func TestOpenErr(t *testing.T) {
+	fn := tmpPath(t, "data.json")
+	edit(t, fn, `{"foo": `)
+	f, err := New(fn, Options{})
+	assert.Error(t, err)
+	assert.Nil(t, f)
+}
+
+func TestGetSetDel(t *testing.T) {
+	var v testStruct
+	fn := tmpPath(t, "data.json")
+	f, err := New(fn, Options{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, kv.ErrNotFound, f.Get("foo", &v))
+	assert.NoError(t, f.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, f.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+
+	// Every change is written to the document
+	b, err := ioutil.ReadFile(fn)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"foo": {"Foo": "bar"}}`, string(b))
+
+	assert.NoError(t, f.Del("foo"))
+	assert.Equal(t, kv.ErrNotFound, f.Get("foo", &v))
+	assert.Equal(t, kv.ErrNotFound, f.Del("foo"))
+	b, err = ioutil.ReadFile(fn)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{}`, string(b))
+
+	assert.Error(t, f.Set("bar", func() {}))
+	assert.Equal(t, []string{}, f.Keys())
+
+	// No temp files are left behind
+	files, err := ioutil.ReadDir(filepath.Dir(fn))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestSetErr(t *testing.T) {
+	fn := tmpPath(t, "data.json")
+	f, err := New(fn, Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Set("foo", "bar"))
+
+	// A failed write leaves the store unchanged
+	assert.NoError(t, os.Chmod(filepath.Dir(fn), 0500))
+	defer os.Chmod(filepath.Dir(fn), 0700)
+	if ioutil.WriteFile(filepath.Join(filepath.Dir(fn), "probe"), nil, 0644) == nil {
+		t.Skip("permissions aren't enforced")
+	}
+	assert.Error(t, f.Set("foo", "baz"))
+	assert.Error(t, f.Set("bar", "baz"))
+	var s string
+	assert.NoError(t, f.Get("foo", &s))
+	assert.Equal(t, "bar", s)
+	assert.Equal(t, []string{"foo"}, f.Keys())
+}
+
+func TestYAML(t *testing.T) {
+	var v testStruct
+	fn := tmpPath(t, "data.yml")
+	f, err := New(fn, Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Set("foo", testStruct{"bar"}))
+	b, err := ioutil.ReadFile(fn)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo:\n    Foo: bar\n", string(b))
+
+	edit(t, fn, "foo:\n  Foo: baz\nlist: [1, 2]\n")
+	assert.NoError(t, f.Get("foo", &v))
+	assert.Equal(t, testStruct{"baz"}, v)
+	var list []int
+	assert.NoError(t, f.Get("list", &list))
+	assert.Equal(t, []int{1, 2}, list)
+}
+
+func TestExternalEdit(t *testing.T) {
+	var v testStruct
+	fn := tmpPath(t, "data.json")
+	f, err := New(fn, Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, f.SetMulti(map[string]interface{}{
+		"foo": testStruct{"foo"},
+		"bar": testStruct{"bar"},
+	}))
+
+	events, cancel := f.Watch("")
+	defer cancel()
+	edit(t, fn, `{"foo": {"Foo": "edited"}, "baz": {"Foo": "baz"}}`)
+	assert.Equal(t, []string{"baz", "foo"}, f.Keys())
+	assert.NoError(t, f.Get("foo", &v))
+	assert.Equal(t, testStruct{"edited"}, v)
+
+	// Watchers are notified of the keys the edit changed
+	got := map[string]kv.EventType{}
+	for i := 0; i < 3; i++ {
+		e := <-events
+		got[e.Key] = e.Type
+	}
+	assert.Equal(t, map[string]kv.EventType{"foo": kv.EventPut, "baz": kv.EventPut, "bar": kv.EventDelete}, got)
+
+	// Removing the document clears the store
+	assert.NoError(t, os.Remove(fn))
+	assert.Equal(t, []string{}, f.Keys())
+}
+
+func TestManualFlush(t *testing.T) {
+	var v testStruct
+	fn := tmpPath(t, "data.json")
+	edit(t, fn, `{"foo": {"Foo": "foo"}}`)
+	f, err := New(fn, Options{ManualFlush: true})
+	assert.NoError(t, err)
+
+	assert.NoError(t, f.Set("bar", testStruct{"bar"}))
+	assert.NoError(t, f.Del("foo"))
+	b, err := ioutil.ReadFile(fn)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"foo": {"Foo": "foo"}}`, string(b))
+
+	// Pending changes take precedence over external edits
+	edit(t, fn, `{"baz": {"Foo": "baz"}}`)
+	assert.Equal(t, []string{"bar"}, f.Keys())
+	assert.NoError(t, f.Get("bar", &v))
+
+	assert.NoError(t, f.Flush())
+	b, err = ioutil.ReadFile(fn)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"bar": {"Foo": "bar"}}`, string(b))
+	assert.NoError(t, f.Flush())
+
+	assert.NoError(t, f.Set("baz", testStruct{"baz"}))
+	assert.NoError(t, f.Close())
+	b, err = ioutil.ReadFile(fn)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"bar": {"Foo": "bar"}, "baz": {"Foo": "baz"}}`, string(b))
+}
+
+func TestMulti(t *testing.T) {
+	f, err := New(tmpPath(t, "data.json"), Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, f.SetMulti(map[string]interface{}{
+		"foo": testStruct{"foo"},
+		"bar": testStruct{"bar"},
+	}))
+
+	m := map[string]testStruct{}
+	assert.NoError(t, f.GetMulti([]string{"foo", "bar", "baz"}, m))
+	assert.Equal(t, map[string]testStruct{"foo": {"foo"}, "bar": {"bar"}}, m)
+
+	assert.NoError(t, f.DelMulti([]string{"foo", "baz"}))
+	assert.Equal(t, []string{"bar"}, f.Keys())
+	assert.Error(t, f.SetMulti(map[string]interface{}{"foo": func() {}}))
+}
+
+func TestRaw(t *testing.T) {
+	f, err := New(tmpPath(t, "data.json"), Options{})
+	assert.NoError(t, err)
+	assert.True(t, codec.Equal(codec.JSON, f.Codec()))
+
+	assert.NoError(t, f.SetRaw("foo", codec.WithExpiry([]byte(`"foo"`), time.Now().Add(time.Minute))))
+	assert.NoError(t, f.SetRaw("bar", codec.WithExpiry([]byte(`"bar"`), time.Now().Add(-time.Second))))
+	assert.Equal(t, ErrInvalidJSON, f.SetRaw("baz", []byte(`{`)))
+	assert.Equal(t, []string{"foo"}, f.Keys())
+	b, err := f.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte(`"foo"`), b)
+	_, err = f.GetRaw("bar")
+	assert.Equal(t, kv.ErrNotFound, err)
+}
+
+func TestTransferClear(t *testing.T) {
+	f, err := New(tmpPath(t, "data.json"), Options{})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Set("foo", testStruct{"foo"}))
+	assert.NoError(t, f.Set("bar", testStruct{"bar"}))
+
+	m := memory.New(memory.Options{})
+	assert.NoError(t, f.Transfer(m))
+	var v map[string]interface{}
+	assert.NoError(t, m.Get("foo", &v))
+	assert.Equal(t, map[string]interface{}{"Foo": "foo"}, v)
+
+	events, cancel := f.Watch("")
+	defer cancel()
+	assert.NoError(t, f.Clear())
+	assert.Equal(t, []string{}, f.Keys())
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		e := <-events
+		assert.Equal(t, kv.EventDelete, e.Type)
+		got[e.Key] = true
+	}
+	assert.Equal(t, map[string]bool{"foo": true, "bar": true}, got)
+}
+
+func TestWatch(t *testing.T) {
+	f, err := New(tmpPath(t, "data.json"), Options{})
+	assert.NoError(t, err)
+
+	events, cancel := f.Watch("foo")
+	assert.NoError(t, f.Set("bar", testStruct{"bar"}))
+	assert.NoError(t, f.Set("foo", testStruct{"foo"}))
+	assert.NoError(t, f.Del("foo"))
+	assert.Equal(t, kv.Event{Type: kv.EventPut, Key: "foo", Value: []byte(`{"Foo":"foo"}`)}, <-events)
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "foo"}, <-events)
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+}
@@ -0,0 +1,82 @@
+package file
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the format of the document holding the dataset
+type Format int
+
+const (
+	// Auto picks YAML for paths ending in .yaml or .yml and JSON otherwise
+	Auto Format = iota
+	// JSON stores the dataset as an indented JSON object
+	JSON
+	// YAML stores the dataset as a YAML mapping
+	YAML
+)
+
+// formatOf resolves Auto to the format matching the extension of path
+func formatOf(path string, f Format) Format {
+	if f != Auto {
+		return f
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return YAML
+	}
+	return JSON
+}
+
+// decode parses a document into its key/value pairs, with each value encoded as JSON. An empty
+// document holds no pairs.
+func decode(b []byte, f Format) (map[string]json.RawMessage, error) {
+	data := map[string]json.RawMessage{}
+	if len(strings.TrimSpace(string(b))) == 0 {
+		return data, nil
+	}
+	if f != YAML {
+		if err := json.Unmarshal(b, &data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	for key, val := range doc {
+		raw, err := json.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = raw
+	}
+	return data, nil
+}
+
+// encode formats the key/value pairs as a document, sorted by key
+func encode(data map[string]json.RawMessage, f Format) ([]byte, error) {
+	if f != YAML {
+		b, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	}
+
+	doc := make(map[string]interface{}, len(data))
+	for key, raw := range data {
+		var val interface{}
+		if err := json.Unmarshal(raw, &val); err != nil {
+			return nil, err
+		}
+		doc[key] = val
+	}
+	return yaml.Marshal(doc)
+}
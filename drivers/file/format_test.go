@@ -0,0 +1,60 @@
+package file
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatOf(t *testing.T) {
+	assert.Equal(t, JSON, formatOf("data.json", Auto))
+	assert.Equal(t, JSON, formatOf("data", Auto))
+	assert.Equal(t, YAML, formatOf("data.yaml", Auto))
+	assert.Equal(t, YAML, formatOf("data.YML", Auto))
+	assert.Equal(t, JSON, formatOf("data.yaml", JSON))
+	assert.Equal(t, YAML, formatOf("data.json", YAML))
+}
+
+func TestEncodeDecode(t *testing.T) {
+	data := map[string]json.RawMessage{
+		"foo": json.RawMessage(`{"Foo":"bar","N":3}`),
+		"bar": json.RawMessage(`[1,2]`),
+	}
+
+	b, err := encode(data, JSON)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n  \"bar\": [\n    1,\n    2\n  ],\n  \"foo\": {\n    \"Foo\": \"bar\",\n    \"N\": 3\n  }\n}\n", string(b))
+	decoded, err := decode(b, JSON)
+	assert.NoError(t, err)
+	assert.Equal(t, data, compact(t, decoded))
+
+	b, err = encode(data, YAML)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar:\n    - 1\n    - 2\nfoo:\n    Foo: bar\n    \"N\": 3\n", string(b))
+	decoded, err = decode(b, YAML)
+	assert.NoError(t, err)
+	assert.Equal(t, data, compact(t, decoded))
+
+	for _, f := range []Format{JSON, YAML} {
+		decoded, err = decode([]byte(" \n"), f)
+		assert.NoError(t, err)
+		assert.Len(t, decoded, 0)
+		_, err = decode([]byte("[1, 2"), f)
+		assert.Error(t, err)
+	}
+	_, err = encode(map[string]json.RawMessage{"foo": json.RawMessage(`{`)}, YAML)
+	assert.Error(t, err)
+}
+
+// compact strips the insignificant whitespace of the decoded values
+func compact(t *testing.T, data map[string]json.RawMessage) map[string]json.RawMessage {
+	for key, val := range data {
+		var v interface{}
+		assert.NoError(t, json.Unmarshal(val, &v))
+		b, err := json.Marshal(v)
+		assert.NoError(t, err)
+		data[key] = b
+	}
+	return data
+}
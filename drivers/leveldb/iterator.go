@@ -0,0 +1,69 @@
+package leveldb
+
+import (
+	"bytes"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// iter is a "kv.Iterator" wrapping a goleveldb iterator
+type iter struct {
+	db *DB
+	it iterator.Iterator
+}
+
+// Scan implements the "kv.Scanner".Scan interface using a goleveldb iterator, which reads from an
+// implicit snapshot of the database. Keys are returned in byte order.
+func (db *DB) Scan(prefix, start, end string) kv.Iterator {
+	return &iter{db, db.DB().NewIterator(scanRange(prefix, start, end), nil)}
+}
+
+// scanRange returns the intersection of the prefix range with [start, end)
+func scanRange(prefix, start, end string) *util.Range {
+	r := util.BytesPrefix([]byte(prefix))
+	if bytes.Compare([]byte(start), r.Start) > 0 {
+		r.Start = []byte(start)
+	}
+	if end != "" && (r.Limit == nil || bytes.Compare([]byte(end), r.Limit) < 0) {
+		r.Limit = []byte(end)
+	}
+	return r
+}
+
+// Next implements the "kv.Iterator".Next interface, skipping expired keys
+func (i *iter) Next() bool {
+	for i.it.Next() {
+		if _, exp := codec.SplitExpiry(i.it.Value()); !codec.Expired(exp) {
+			return true
+		}
+	}
+	return false
+}
+
+// Key implements the "kv.Iterator".Key interface
+func (i *iter) Key() string {
+	return string(i.it.Key())
+}
+
+// Value implements the "kv.Iterator".Value interface
+func (i *iter) Value(dstVal interface{}) error {
+	b := i.it.Value()
+	if b == nil {
+		return kv.ErrNotFound
+	}
+	return i.db.unmarshal(b, dstVal)
+}
+
+// Err implements the "kv.Iterator".Err interface
+func (i *iter) Err() error {
+	return i.it.Error()
+}
+
+// Close implements the "kv.Iterator".Close interface
+func (i *iter) Close() error {
+	i.it.Release()
+	return nil
+}
@@ -0,0 +1,11 @@
+package leveldb
+
+import (
+	"testing"
+
+	"github.com/bradberger/gokv/kv/kvtest"
+)
+
+func TestScan(t *testing.T) {
+	kvtest.Scan(t, newTestStore, kvtest.ScanOptions{SetExpired: kvtest.ExpireSoon})
+}
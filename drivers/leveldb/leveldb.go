@@ -1,18 +1,33 @@
 package leveldb
 
 import (
+	"time"
+
 	"github.com/bradberger/gokv/codec"
 	"github.com/bradberger/gokv/kv"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"golang.org/x/net/context"
 )
 
 var (
-	// Codec is the codec used to marshal/unmarshal interfaces into the byte slices required by the Diskv client
+	// Codec is the codec used to marshal/unmarshal interfaces into the byte slices required by the Diskv client,
+	// for DBs created without the kv.WithCodec option
 	Codec codec.Codec
 
 	// ensure struct implements the kv.Store interface
-	_ kv.Store = (*DB)(nil)
+	_ kv.Store         = (*DB)(nil)
+	_ kv.ContextStore  = (*DB)(nil)
+	_ kv.Expirer       = (*DB)(nil)
+	_ kv.Sweeper       = (*DB)(nil)
+	_ kv.Batcher       = (*DB)(nil)
+	_ kv.Scanner       = (*DB)(nil)
+	_ kv.Datastore     = (*DB)(nil)
+	_ kv.Clearer       = (*DB)(nil)
+	_ kv.RawStore      = (*DB)(nil)
+	_ kv.CAS           = (*DB)(nil)
+	_ kv.Transactional = (*DB)(nil)
+	_ kv.Watcher       = (*DB)(nil)
 )
 
 func init() {
@@ -21,42 +36,333 @@ func init() {
 
 // DB is a light wrapper around the leveldb struct
 type DB struct {
-	db *leveldb.DB
+	db   *leveldb.DB
+	opts kv.Options
+
+	// locks serializes writes to a key so read-modify-write operations like Touch are atomic
+	locks kv.KeyLock
+	hub   kv.Hub
 }
 
-// New returns a new key/value store powered by leveldb
-func New(file string, opts *opt.Options) (*DB, error) {
-	db, err := leveldb.OpenFile(file, opts)
+// New returns a new key/value store powered by leveldb. Options like kv.WithCodec configure the DB itself.
+func New(file string, options *opt.Options, opts ...kv.Option) (*DB, error) {
+	db, err := leveldb.OpenFile(file, options)
 	if err != nil {
 		return nil, err
 	}
-	return &DB{db: db}, nil
+	return &DB{db: db, opts: kv.NewOptions(opts...)}, nil
 }
 
 // Get implements the "kv.Store".Get interface
 func (db *DB) Get(key string, dstVal interface{}) error {
+	return db.GetContext(context.Background(), key, dstVal)
+}
+
+// Set implements the "kv.Store".Set interface
+func (db *DB) Set(key string, val interface{}) error {
+	return db.SetContext(context.Background(), key, val)
+}
+
+// Del implements the "kv.Store".Del interface
+func (db *DB) Del(key string) error {
+	return db.DelContext(context.Background(), key)
+}
+
+// GetContext implements the "kv.ContextStore".GetContext interface
+func (db *DB) GetContext(ctx context.Context, key string, dstVal interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, err := db.get(key)
+	if err != nil {
+		return err
+	}
+	return db.unmarshal(b, dstVal)
+}
+
+func (db *DB) get(key string) ([]byte, error) {
 	b, err := db.DB().Get([]byte(key), nil)
+	if err == leveldb.ErrNotFound {
+		err = kv.ErrNotFound
+	}
+	return b, err
+}
+
+// marshal encodes a value to be stored with the expiration exp, which is kept in a header in
+// front of the encoded value. Values which never expire only get a header if they start like one.
+func (db *DB) marshal(value interface{}, exp time.Time) ([]byte, error) {
+	b, err := db.Codec().Marshal(value)
 	if err != nil {
-		if err == leveldb.ErrNotFound {
-			err = kv.ErrNotFound
+		return nil, err
+	}
+	return codec.WithExpiry(b, exp), nil
+}
+
+// unmarshal decodes a stored value, returning kv.ErrCacheMiss if it has expired
+func (db *DB) unmarshal(b []byte, dstVal interface{}) error {
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return kv.ErrCacheMiss
+	}
+	return db.Codec().Unmarshal(b, dstVal)
+}
+
+// SetContext implements the "kv.ContextStore".SetContext interface
+func (db *DB) SetContext(ctx context.Context, key string, val interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, err := db.marshal(val, time.Time{})
+	if err != nil {
+		return err
+	}
+	return db.put(key, b)
+}
+
+func (db *DB) put(key string, b []byte) error {
+	db.locks.Lock(key)
+	defer db.locks.Unlock(key)
+	return db.putLocked(key, b)
+}
+
+// putLocked puts the value and notifies watchers. The key must be locked by the caller.
+func (db *DB) putLocked(key string, b []byte) error {
+	if err := db.DB().Put([]byte(key), b, nil); err != nil {
+		return err
+	}
+	b, _ = codec.SplitExpiry(b)
+	db.hub.Put(key, b)
+	return nil
+}
+
+// delLocked deletes the key and notifies watchers. The key must be locked by the caller.
+func (db *DB) delLocked(key string) error {
+	if err := db.DB().Delete([]byte(key), nil); err != nil {
+		return err
+	}
+	db.hub.Delete(key)
+	return nil
+}
+
+// publisher is a leveldb.BatchReplay which notifies watchers of the changes in a batch
+type publisher struct {
+	hub *kv.Hub
+}
+
+// Put implements the leveldb.BatchReplay interface
+func (p publisher) Put(key, value []byte) {
+	value, _ = codec.SplitExpiry(value)
+	p.hub.Put(string(key), value)
+}
+
+// Delete implements the leveldb.BatchReplay interface
+func (p publisher) Delete(key []byte) {
+	p.hub.Delete(string(key))
+}
+
+// Watch implements the "kv.Watcher".Watch interface for changes made through this DB
+func (db *DB) Watch(prefix string) (<-chan kv.Event, func()) {
+	return db.hub.Watch(prefix)
+}
+
+// DelContext implements the "kv.ContextStore".DelContext interface
+func (db *DB) DelContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.locks.Lock(key)
+	defer db.locks.Unlock(key)
+	return db.delLocked(key)
+}
+
+// SetMulti implements the "kv.Batcher".SetMulti interface, writing all items in a single batch
+func (db *DB) SetMulti(items map[string]interface{}) error {
+	keys := make([]string, 0, len(items))
+	batch := new(leveldb.Batch)
+	for key, val := range items {
+		b, err := db.marshal(val, time.Time{})
+		if err != nil {
+			return err
 		}
+		keys = append(keys, key)
+		batch.Put([]byte(key), b)
+	}
+	return db.write(keys, batch)
+}
+
+// GetMulti implements the "kv.Batcher".GetMulti interface, reading all keys from a single snapshot
+func (db *DB) GetMulti(keys []string, dst interface{}) error {
+	snap, err := db.DB().GetSnapshot()
+	if err != nil {
 		return err
 	}
-	return Codec.Unmarshal(b, dstVal)
+	defer snap.Release()
+	return kv.FillMulti(keys, dst, func(i int, key string, dstVal interface{}) error {
+		b, err := snap.Get([]byte(key), nil)
+		if err != nil {
+			if err == leveldb.ErrNotFound {
+				err = kv.ErrNotFound
+			}
+			return err
+		}
+		return db.unmarshal(b, dstVal)
+	})
 }
 
-// Set implements the "kv.Store".Set interface
-func (db *DB) Set(key string, val interface{}) error {
-	b, err := Codec.Marshal(val)
+// DelMulti implements the "kv.Batcher".DelMulti interface, deleting all keys in a single batch
+func (db *DB) DelMulti(keys []string) error {
+	batch := new(leveldb.Batch)
+	for _, key := range keys {
+		batch.Delete([]byte(key))
+	}
+	return db.write(keys, batch)
+}
+
+// write applies the batch while holding the locks for all its keys
+func (db *DB) write(keys []string, batch *leveldb.Batch) error {
+	db.locks.LockMulti(keys)
+	defer db.locks.UnlockMulti(keys)
+	return db.writeBatch(batch)
+}
+
+// writeBatch applies the batch and notifies watchers of its changes
+func (db *DB) writeBatch(batch *leveldb.Batch) error {
+	if err := db.DB().Write(batch, nil); err != nil {
+		return err
+	}
+	return batch.Replay(publisher{&db.hub})
+}
+
+// SetWithTTL implements the "kv.Expirer".SetWithTTL interface. The expiration is stored
+// in a header in front of the encoded value.
+func (db *DB) SetWithTTL(key string, val interface{}, ttl time.Duration) error {
+	b, err := db.marshal(val, codec.ExpiresAt(ttl))
 	if err != nil {
 		return err
 	}
-	return db.DB().Put([]byte(key), b, nil)
+	return db.put(key, b)
 }
 
-// Del implements the "kv.Store".Del interface
-func (db *DB) Del(key string) error {
-	return db.DB().Delete([]byte(key), nil)
+// TTL implements the "kv.Expirer".TTL interface
+func (db *DB) TTL(key string) (time.Duration, error) {
+	b, err := db.get(key)
+	if err != nil {
+		return 0, err
+	}
+	_, exp := codec.SplitExpiry(b)
+	switch {
+	case exp.IsZero():
+		return kv.NoExpiration, nil
+	case codec.Expired(exp):
+		return 0, kv.ErrCacheMiss
+	}
+	return exp.Sub(time.Now()), nil
+}
+
+// Touch implements the "kv.Expirer".Touch interface
+func (db *DB) Touch(key string, ttl time.Duration) error {
+	db.locks.Lock(key)
+	defer db.locks.Unlock(key)
+	b, err := db.get(key)
+	if err != nil {
+		return err
+	}
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return kv.ErrCacheMiss
+	}
+	return db.putLocked(key, codec.WithExpiry(b, codec.ExpiresAt(ttl)))
+}
+
+// Sweep implements the "kv.Sweeper".Sweep interface, deleting all expired keys.
+// Use kv.SweepEvery() to run it periodically.
+func (db *DB) Sweep() error {
+	iter := db.DB().NewIterator(nil, nil)
+	defer iter.Release()
+	for iter.Next() {
+		if _, exp := codec.SplitExpiry(iter.Value()); codec.Expired(exp) {
+			if err := db.delExpired(string(iter.Key())); err != nil {
+				return err
+			}
+		}
+	}
+	return iter.Error()
+}
+
+// delExpired deletes the key if it's still expired once the key is locked
+func (db *DB) delExpired(key string) error {
+	db.locks.Lock(key)
+	defer db.locks.Unlock(key)
+	b, err := db.get(key)
+	if err != nil {
+		if err == kv.ErrNotFound {
+			err = nil
+		}
+		return err
+	}
+	if _, exp := codec.SplitExpiry(b); !codec.Expired(exp) {
+		return nil
+	}
+	return db.delLocked(key)
+}
+
+// GetRaw implements the "kv.RawStore".GetRaw interface, returning the value as stored, including
+// any expiry header
+func (db *DB) GetRaw(key string) ([]byte, error) {
+	b, err := db.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, exp := codec.SplitExpiry(b); codec.Expired(exp) {
+		return nil, kv.ErrCacheMiss
+	}
+	return b, nil
+}
+
+// SetRaw implements the "kv.RawStore".SetRaw interface
+func (db *DB) SetRaw(key string, b []byte) error {
+	return db.put(key, b)
+}
+
+// Codec implements the "kv.RawStore".Codec interface
+func (db *DB) Codec() codec.Codec {
+	return db.opts.CodecOr(Codec)
+}
+
+// Keys implements the "kv.KeyList".Keys interface. Expired keys are left out. Since the
+// interface can't return an error, nil is returned if the database can't be read.
+func (db *DB) Keys() []string {
+	it := db.Scan("", "", "")
+	defer it.Close()
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil {
+		return nil
+	}
+	return keys
+}
+
+// Transfer implements the "kv.Datastore".Transfer interface, copying all keys to dst.
+// Encoded values are copied as is if dst is a "kv.RawStore" sharing the same codec.
+func (db *DB) Transfer(dst kv.Store) error {
+	return kv.Transfer(db, dst)
+}
+
+// Clear implements the "kv.Clearer".Clear interface, deleting all keys in a single batch.
+// Watchers receive a delete event for every key.
+func (db *DB) Clear() error {
+	iter := db.DB().NewIterator(nil, nil)
+	defer iter.Release()
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		batch.Delete(iter.Key())
+	}
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return db.writeBatch(batch)
 }
 
 // DB returns the underlying LevelDB database
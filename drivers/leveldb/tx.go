@@ -0,0 +1,93 @@
+package leveldb
+
+import (
+	"time"
+
+	"github.com/bradberger/gokv/kv"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// reader is implemented by both leveldb transactions and snapshots
+type reader interface {
+	Get(key []byte, ro *opt.ReadOptions) ([]byte, error)
+}
+
+// tx is a "kv.Tx" wrapping a leveldb transaction, or a snapshot for read only transactions.
+// Writes are mirrored in a batch which is replayed to watchers once the transaction commits.
+type tx struct {
+	db      *DB
+	r       reader
+	tr      *leveldb.Transaction
+	changes leveldb.Batch
+}
+
+// Update implements the "kv.Transactional".Update interface using a leveldb transaction. All
+// keys are locked for the duration, so fn must use tx rather than db or it will deadlock.
+func (db *DB) Update(fn func(tx kv.Tx) error) error {
+	db.locks.LockAll()
+	defer db.locks.UnlockAll()
+	tr, err := db.DB().OpenTransaction()
+	if err != nil {
+		return err
+	}
+	t := &tx{db: db, r: tr, tr: tr}
+	if err := fn(t); err != nil {
+		tr.Discard()
+		return err
+	}
+	if err := tr.Commit(); err != nil {
+		return err
+	}
+	return t.changes.Replay(publisher{&db.hub})
+}
+
+// View implements the "kv.Transactional".View interface using a leveldb snapshot
+func (db *DB) View(fn func(tx kv.Tx) error) error {
+	snap, err := db.DB().GetSnapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.Release()
+	return fn(&tx{db: db, r: snap})
+}
+
+// Set implements the "kv.Tx".Set interface
+func (t *tx) Set(key string, val interface{}) error {
+	if t.tr == nil {
+		return kv.ErrReadOnly
+	}
+	b, err := t.db.marshal(val, time.Time{})
+	if err != nil {
+		return err
+	}
+	if err := t.tr.Put([]byte(key), b, nil); err != nil {
+		return err
+	}
+	t.changes.Put([]byte(key), b)
+	return nil
+}
+
+// Get implements the "kv.Tx".Get interface
+func (t *tx) Get(key string, dstVal interface{}) error {
+	b, err := t.r.Get([]byte(key), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			err = kv.ErrNotFound
+		}
+		return err
+	}
+	return t.db.unmarshal(b, dstVal)
+}
+
+// Del implements the "kv.Tx".Del interface
+func (t *tx) Del(key string) error {
+	if t.tr == nil {
+		return kv.ErrReadOnly
+	}
+	if err := t.tr.Delete([]byte(key), nil); err != nil {
+		return err
+	}
+	t.changes.Delete([]byte(key))
+	return nil
+}
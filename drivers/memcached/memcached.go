@@ -0,0 +1,372 @@
+// Package memcached implements a key/value store backed by one or more memcached servers
+package memcached
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// maxRelativeExpiration is the longest expiration memcached treats as relative to now. Longer
+// expirations are sent as absolute unix timestamps.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
+var (
+	// Codec is the codec used to marshal/unmarshal interfaces into the byte slices stored in memcached,
+	// for stores created without the kv.WithCodec option. The default codec is Gob
+	Codec codec.Codec
+
+	// ensure struct implements the kv.Store interface
+	_ kv.Store    = (*Memcached)(nil)
+	_ kv.Expirer  = (*Memcached)(nil)
+	_ kv.Batcher  = (*Memcached)(nil)
+	_ kv.Clearer  = (*Memcached)(nil)
+	_ kv.CAS      = (*Memcached)(nil)
+	_ kv.RawStore = (*Memcached)(nil)
+	_ kv.Watcher  = (*Memcached)(nil)
+)
+
+func init() {
+	Codec = codec.Gob
+}
+
+// Memcached is a memcached backed key/value store. Memcached may evict keys at any time, so
+// missing keys are reported as kv.ErrCacheMiss. Values set with a TTL carry an expiry header
+// so it can be read back, on top of the expiration memcached enforces itself.
+type Memcached struct {
+	c    *memcache.Client
+	opts kv.Options
+	hub  kv.Hub
+}
+
+// New returns a new key/value store spreading keys across the given memcached servers. Options
+// like kv.WithCodec configure the store itself.
+func New(servers []string, opts ...kv.Option) *Memcached {
+	return NewClient(memcache.New(servers...), opts...)
+}
+
+// NewClient returns a new key/value store using the given memcache client. Options like
+// kv.WithCodec configure the store itself.
+func NewClient(c *memcache.Client, opts ...kv.Option) *Memcached {
+	return &Memcached{c: c, opts: kv.NewOptions(opts...)}
+}
+
+// mapErr converts memcache errors to their kv equivalents
+func mapErr(err error) error {
+	switch err {
+	case memcache.ErrCacheMiss:
+		return kv.ErrCacheMiss
+	case memcache.ErrCASConflict, memcache.ErrNotStored:
+		return kv.ErrVersionMismatch
+	}
+	return err
+}
+
+// expiration converts an expiration time to a memcached expiration. Zero means the item never
+// expires.
+func expiration(exp time.Time) int32 {
+	if exp.IsZero() {
+		return 0
+	}
+	ttl := exp.Sub(time.Now())
+	if ttl > maxRelativeExpiration {
+		return int32(exp.Unix())
+	}
+	secs := int32((ttl + time.Second - 1) / time.Second)
+	if secs <= 0 {
+		// Negative expirations make memcached expire the item immediately
+		return -1
+	}
+	return secs
+}
+
+// item returns a memcache item for the encoded value, adding an expiry header if exp is set
+func item(key string, b []byte, exp time.Time) *memcache.Item {
+	return &memcache.Item{Key: key, Value: codec.WithExpiry(b, exp), Expiration: expiration(exp)}
+}
+
+// unmarshal decodes a stored value, returning kv.ErrCacheMiss if it has expired
+func (m *Memcached) unmarshal(b []byte, dstVal interface{}) error {
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return kv.ErrCacheMiss
+	}
+	return m.Codec().Unmarshal(b, dstVal)
+}
+
+// Set implements the "kv.Store".Set() interface
+func (m *Memcached) Set(key string, value interface{}) error {
+	b, err := m.Codec().Marshal(value)
+	if err != nil {
+		return err
+	}
+	return m.put(item(key, b, time.Time{}))
+}
+
+// put stores the item and notifies watchers
+func (m *Memcached) put(it *memcache.Item) error {
+	if err := m.c.Set(it); err != nil {
+		return mapErr(err)
+	}
+	m.published(it)
+	return nil
+}
+
+// published notifies watchers that the item was stored
+func (m *Memcached) published(it *memcache.Item) {
+	b, _ := codec.SplitExpiry(it.Value)
+	m.hub.Put(it.Key, b)
+}
+
+// Get implements the "kv.Store".Get() interface
+func (m *Memcached) Get(key string, dstVal interface{}) error {
+	it, err := m.c.Get(key)
+	if err != nil {
+		return mapErr(err)
+	}
+	return m.unmarshal(it.Value, dstVal)
+}
+
+// Del implements the "kv.Store".Del() interface
+func (m *Memcached) Del(key string) error {
+	if err := m.c.Delete(key); err != nil {
+		return mapErr(err)
+	}
+	m.hub.Delete(key)
+	return nil
+}
+
+// SetWithTTL implements the "kv.Expirer".SetWithTTL() interface
+func (m *Memcached) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	b, err := m.Codec().Marshal(value)
+	if err != nil {
+		return err
+	}
+	return m.put(item(key, b, codec.ExpiresAt(ttl)))
+}
+
+// TTL implements the "kv.Expirer".TTL() interface, reading the expiry header of the value
+func (m *Memcached) TTL(key string) (time.Duration, error) {
+	it, err := m.c.Get(key)
+	if err != nil {
+		return 0, mapErr(err)
+	}
+	_, exp := codec.SplitExpiry(it.Value)
+	switch {
+	case exp.IsZero():
+		return kv.NoExpiration, nil
+	case codec.Expired(exp):
+		return 0, kv.ErrCacheMiss
+	}
+	return exp.Sub(time.Now()), nil
+}
+
+// Touch implements the "kv.Expirer".Touch() interface. The value is rewritten with a new expiry
+// header, using a compare-and-swap so concurrent writes aren't overwritten.
+func (m *Memcached) Touch(key string, ttl time.Duration) error {
+	for {
+		it, err := m.c.Get(key)
+		if err != nil {
+			return mapErr(err)
+		}
+		b, exp := codec.SplitExpiry(it.Value)
+		if codec.Expired(exp) {
+			return kv.ErrCacheMiss
+		}
+		touched := item(key, b, codec.ExpiresAt(ttl))
+		touched.CasID = it.CasID
+		switch err := m.c.CompareAndSwap(touched); err {
+		case nil:
+			m.hub.Put(key, b)
+			return nil
+		case memcache.ErrCASConflict:
+			continue
+		default:
+			return mapErr(err)
+		}
+	}
+}
+
+// SetMulti implements the "kv.Batcher".SetMulti() interface. The memcached text protocol has no
+// multi-set command, so items are set one at a time.
+func (m *Memcached) SetMulti(items map[string]interface{}) error {
+	for key, value := range items {
+		if err := m.Set(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMulti implements the "kv.Batcher".GetMulti() interface, fetching all keys with one request
+// per server
+func (m *Memcached) GetMulti(keys []string, dst interface{}) error {
+	items, err := m.c.GetMulti(keys)
+	if err != nil {
+		return mapErr(err)
+	}
+	return kv.FillMulti(keys, dst, func(i int, key string, dstVal interface{}) error {
+		it, ok := items[key]
+		if !ok {
+			return kv.ErrCacheMiss
+		}
+		return m.unmarshal(it.Value, dstVal)
+	})
+}
+
+// DelMulti implements the "kv.Batcher".DelMulti() interface, deleting the keys one at a time.
+// Missing keys are ignored.
+func (m *Memcached) DelMulti(keys []string) error {
+	for _, key := range keys {
+		if err := m.Del(key); err != nil && err != kv.ErrCacheMiss {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetWithVersion implements the "kv.CAS".GetWithVersion() interface. The version is the
+// memcached CAS token of the item.
+func (m *Memcached) GetWithVersion(key string, dstVal interface{}) (uint64, error) {
+	it, err := m.c.Get(key)
+	if err != nil {
+		return 0, mapErr(err)
+	}
+	return it.CasID, m.unmarshal(it.Value, dstVal)
+}
+
+// SetIfVersion implements the "kv.CAS".SetIfVersion() interface using the cas command. Memcached
+// doesn't return the new CAS token, so it's read back after the swap. If the key was changed
+// again in between the returned version is zero, which never matches.
+func (m *Memcached) SetIfVersion(key string, value interface{}, version uint64) (uint64, error) {
+	b, err := m.Codec().Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	it := item(key, b, time.Time{})
+	it.CasID = version
+	if err := m.c.CompareAndSwap(it); err != nil {
+		if err == memcache.ErrCacheMiss {
+			err = kv.ErrVersionMismatch
+		}
+		return 0, mapErr(err)
+	}
+	m.published(it)
+	return m.version(it), nil
+}
+
+// SetIfNotExists implements the "kv.CAS".SetIfNotExists() interface using the add command
+func (m *Memcached) SetIfNotExists(key string, value interface{}) (uint64, error) {
+	b, err := m.Codec().Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	it := item(key, b, time.Time{})
+	if err := m.c.Add(it); err != nil {
+		return 0, mapErr(err)
+	}
+	m.published(it)
+	return m.version(it), nil
+}
+
+// version reads the CAS token of the stored item, returning zero if its value has changed
+func (m *Memcached) version(it *memcache.Item) uint64 {
+	cur, err := m.c.Get(it.Key)
+	if err != nil || !bytes.Equal(cur.Value, it.Value) {
+		return 0
+	}
+	return cur.CasID
+}
+
+// DelIfVersion implements the "kv.CAS".DelIfVersion() interface. The text protocol can't delete
+// conditionally, so the item is swapped for an empty one which expires immediately.
+func (m *Memcached) DelIfVersion(key string, version uint64) error {
+	err := m.c.CompareAndSwap(&memcache.Item{Key: key, Expiration: -1, CasID: version})
+	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			err = kv.ErrVersionMismatch
+		}
+		return mapErr(err)
+	}
+	m.hub.Delete(key)
+	return nil
+}
+
+// Increment atomically increments the counter stored at key by delta, returning the new value.
+// Memcached can only increment decimal numbers, so the counter must have been set with
+// SetCounter or a codec encoding integers as text, like codec.JSON.
+func (m *Memcached) Increment(key string, delta uint64) (uint64, error) {
+	n, err := m.c.Increment(key, delta)
+	if err != nil {
+		return 0, mapErr(err)
+	}
+	m.hub.Put(key, []byte(strconv.FormatUint(n, 10)))
+	return n, nil
+}
+
+// Decrement atomically decrements the counter stored at key by delta, returning the new value.
+// Memcached caps counters at zero rather than wrapping around.
+func (m *Memcached) Decrement(key string, delta uint64) (uint64, error) {
+	n, err := m.c.Decrement(key, delta)
+	if err != nil {
+		return 0, mapErr(err)
+	}
+	m.hub.Put(key, []byte(strconv.FormatUint(n, 10)))
+	return n, nil
+}
+
+// SetCounter sets the counter at key to value, stored as a decimal number regardless of the codec
+func (m *Memcached) SetCounter(key string, value uint64) error {
+	return m.put(&memcache.Item{Key: key, Value: []byte(strconv.FormatUint(value, 10))})
+}
+
+// GetRaw implements the "kv.RawStore".GetRaw() interface, returning the value as stored,
+// including any expiry header
+func (m *Memcached) GetRaw(key string) ([]byte, error) {
+	it, err := m.c.Get(key)
+	if err != nil {
+		return nil, mapErr(err)
+	}
+	if _, exp := codec.SplitExpiry(it.Value); codec.Expired(exp) {
+		return nil, kv.ErrCacheMiss
+	}
+	return it.Value, nil
+}
+
+// SetRaw implements the "kv.RawStore".SetRaw() interface. An expiry header is also set as the
+// memcached expiration.
+func (m *Memcached) SetRaw(key string, b []byte) error {
+	b, exp := codec.SplitExpiry(b)
+	return m.put(item(key, b, exp))
+}
+
+// Codec implements the "kv.RawStore".Codec() interface
+func (m *Memcached) Codec() codec.Codec {
+	return m.opts.CodecOr(Codec)
+}
+
+// Clear implements the "kv.Clearer".Clear() interface by flushing all servers. Memcached can't
+// list its keys, so watchers aren't notified.
+func (m *Memcached) Clear() error {
+	return m.c.FlushAll()
+}
+
+// Watch implements the "kv.Watcher".Watch() interface for changes made through this store.
+// Changes made by other memcached clients aren't reported.
+func (m *Memcached) Watch(prefix string) (<-chan kv.Event, func()) {
+	return m.hub.Watch(prefix)
+}
+
+// Client returns the underlying memcache client
+func (m *Memcached) Client() *memcache.Client {
+	return m.c
+}
+
+// Close closes the idle connections to the servers
+func (m *Memcached) Close() error {
+	return m.c.Close()
+}
@@ -0,0 +1,287 @@
+package memcached
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+type testStruct struct {
+	Foo string
+}
+
+// newTestMemcached returns a store connected to a fake in-process memcached server
+func newTestMemcached(t *testing.T, opts ...kv.Option) (*Memcached, *fakeServer) {
+	s := newFakeServer(t)
+	m := New([]string{s.Addr()}, opts...)
+	t.Cleanup(func() {
+		m.Close()
+	})
+	return m, s
+}
+
+func TestNew(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	assert.NotNil(t, m.Client())
+	assert.NoError(t, m.Client().Ping())
+	assert.True(t, codec.Equal(Codec, m.Codec()))
+	m, _ = newTestMemcached(t, kv.WithCodec(codec.JSON))
+	assert.True(t, codec.Equal(codec.JSON, m.Codec()))
+}
+
+func TestGetSetDel(t *testing.T) {
+	var v testStruct
+	m, _ := newTestMemcached(t)
+	assert.Equal(t, kv.ErrCacheMiss, m.Get("foo", &v))
+	assert.NoError(t, m.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, m.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+	assert.NoError(t, m.Del("foo"))
+	assert.Equal(t, kv.ErrCacheMiss, m.Get("foo", &v))
+	assert.Equal(t, kv.ErrCacheMiss, m.Del("foo"))
+}
+
+func TestSetErr(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	m = NewClient(m.Client(), kv.WithCodec(codec.ErrTestCodec))
+	assert.Error(t, m.Set("foo", testStruct{"bar"}))
+	assert.Error(t, m.SetWithTTL("foo", testStruct{"bar"}, time.Minute))
+	_, err := m.SetIfNotExists("foo", testStruct{"bar"})
+	assert.Error(t, err)
+}
+
+// TestRawValues checks blobs are stored verbatim with codec.RawOr
+func TestRawValues(t *testing.T) {
+	var s string
+	var b []byte
+	var buf bytes.Buffer
+	m, _ := newTestMemcached(t, kv.WithCodec(codec.RawOr(codec.Gob)))
+	assert.NoError(t, m.Set("foo", []byte("\x89PNG")))
+	raw, err := m.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x89PNG"), raw)
+	assert.NoError(t, m.Get("foo", &b))
+	assert.Equal(t, []byte("\x89PNG"), b)
+	assert.NoError(t, m.Set("bar", strings.NewReader("bar")))
+	assert.NoError(t, m.Get("bar", &s))
+	assert.Equal(t, "bar", s)
+	assert.NoError(t, m.Get("bar", &buf))
+	assert.Equal(t, "bar", buf.String())
+
+	// Blobs which start like an expiry header aren't mistaken for one
+	ttl := []byte("\xffTTL\x00\x00\x00\x00\x00\x00\x00\x01foo")
+	assert.NoError(t, m.Set("baz", ttl))
+	assert.NoError(t, m.Get("baz", &b))
+	assert.Equal(t, ttl, b)
+}
+
+func TestExpiration(t *testing.T) {
+	assert.Equal(t, int32(0), expiration(time.Time{}))
+	assert.Equal(t, int32(2), expiration(time.Now().Add(1500*time.Millisecond)))
+	assert.Equal(t, int32(-1), expiration(time.Now().Add(-time.Second)))
+	exp := time.Now().Add(60 * 24 * time.Hour)
+	assert.Equal(t, int32(exp.Unix()), expiration(exp))
+}
+
+func TestTTL(t *testing.T) {
+	var v testStruct
+	m, s := newTestMemcached(t)
+	assert.NoError(t, m.Set("foo", testStruct{"foo"}))
+	ttl, err := m.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+
+	assert.NoError(t, m.SetWithTTL("bar", testStruct{"bar"}, time.Minute))
+	ttl, err = m.TTL("bar")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 59*time.Second && ttl <= time.Minute)
+	assert.NoError(t, m.Get("bar", &v))
+	assert.Equal(t, "bar", v.Foo)
+
+	// The server expires the item on its own
+	s.FastForward(2 * time.Minute)
+	assert.Equal(t, kv.ErrCacheMiss, m.Get("bar", &v))
+	_, err = m.TTL("bar")
+	assert.Equal(t, kv.ErrCacheMiss, err)
+	assert.Equal(t, kv.ErrCacheMiss, m.Touch("bar", time.Minute))
+
+	assert.NoError(t, m.Touch("foo", time.Minute))
+	ttl, err = m.TTL("foo")
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Second)
+	assert.NoError(t, m.Get("foo", &v))
+	assert.Equal(t, "foo", v.Foo)
+	s.FastForward(2 * time.Minute)
+	assert.Equal(t, kv.ErrCacheMiss, m.Get("foo", &v))
+}
+
+func TestMulti(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	assert.NoError(t, m.SetMulti(map[string]interface{}{
+		"foo": testStruct{"foo"},
+		"bar": testStruct{"bar"},
+	}))
+
+	dst := map[string]testStruct{}
+	assert.NoError(t, m.GetMulti([]string{"foo", "bar", "baz"}, dst))
+	assert.Equal(t, map[string]testStruct{"foo": {"foo"}, "bar": {"bar"}}, dst)
+
+	var sl []*testStruct
+	assert.NoError(t, m.GetMulti([]string{"foo", "baz", "bar"}, &sl))
+	assert.Equal(t, []*testStruct{{"foo"}, nil, {"bar"}}, sl)
+
+	assert.NoError(t, m.DelMulti([]string{"foo", "bar", "baz"}))
+	assert.Equal(t, kv.ErrCacheMiss, m.Get("foo", &testStruct{}))
+	assert.Equal(t, kv.ErrCacheMiss, m.Get("bar", &testStruct{}))
+}
+
+func TestCAS(t *testing.T) {
+	var v testStruct
+	m, _ := newTestMemcached(t)
+
+	ver, err := m.SetIfNotExists("foo", testStruct{"foo"})
+	assert.NoError(t, err)
+	assert.NotZero(t, ver)
+	_, err = m.SetIfNotExists("foo", testStruct{"bar"})
+	assert.Equal(t, kv.ErrVersionMismatch, err)
+
+	got, err := m.GetWithVersion("foo", &v)
+	assert.NoError(t, err)
+	assert.Equal(t, ver, got)
+	assert.Equal(t, "foo", v.Foo)
+
+	ver2, err := m.SetIfVersion("foo", testStruct{"bar"}, ver)
+	assert.NoError(t, err)
+	assert.NotEqual(t, ver, ver2)
+	_, err = m.SetIfVersion("foo", testStruct{"baz"}, ver)
+	assert.Equal(t, kv.ErrVersionMismatch, err)
+	_, err = m.SetIfVersion("missing", testStruct{"baz"}, ver)
+	assert.Equal(t, kv.ErrVersionMismatch, err)
+
+	assert.Equal(t, kv.ErrVersionMismatch, m.DelIfVersion("foo", ver))
+	assert.NoError(t, m.DelIfVersion("foo", ver2))
+	assert.Equal(t, kv.ErrCacheMiss, m.Get("foo", &v))
+	assert.Equal(t, kv.ErrVersionMismatch, m.DelIfVersion("foo", ver2))
+
+	_, err = m.GetWithVersion("foo", &v)
+	assert.Equal(t, kv.ErrCacheMiss, err)
+}
+
+func TestCASConcurrent(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	_, err := m.SetIfNotExists("counter", 0)
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				for {
+					var n int
+					ver, err := m.GetWithVersion("counter", &n)
+					if !assert.NoError(t, err) {
+						return
+					}
+					if _, err = m.SetIfVersion("counter", n+1, ver); err == nil {
+						break
+					}
+					if !assert.Equal(t, kv.ErrVersionMismatch, err) {
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var n int
+	assert.NoError(t, m.Get("counter", &n))
+	assert.Equal(t, 80, n)
+}
+
+func TestIncrementDecrement(t *testing.T) {
+	m, _ := newTestMemcached(t)
+	_, err := m.Increment("counter", 1)
+	assert.Equal(t, kv.ErrCacheMiss, err)
+
+	assert.NoError(t, m.SetCounter("counter", 10))
+	n, err := m.Increment("counter", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(15), n)
+	n, err = m.Decrement("counter", 20)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), n)
+
+	// Gob encoded values can't be incremented, but JSON integers can
+	assert.NoError(t, m.Set("gob", 1))
+	_, err = m.Increment("gob", 1)
+	assert.Error(t, err)
+
+	m = NewClient(m.Client(), kv.WithCodec(codec.JSON))
+	var i int
+	assert.NoError(t, m.Set("json", 1))
+	n, err = m.Increment("json", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), n)
+	assert.NoError(t, m.Get("json", &i))
+	assert.Equal(t, 3, i)
+}
+
+func TestRawClear(t *testing.T) {
+	var v testStruct
+	m, _ := newTestMemcached(t)
+	m2, s2 := newTestMemcached(t)
+	assert.NoError(t, m.SetWithTTL("foo", testStruct{"foo"}, time.Minute))
+
+	b, err := m.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.NoError(t, m2.SetRaw("foo", b))
+	ttl, err := m2.TTL("foo")
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Second)
+	assert.NoError(t, m2.Get("foo", &v))
+	assert.Equal(t, "foo", v.Foo)
+	s2.FastForward(2 * time.Minute)
+	assert.Equal(t, kv.ErrCacheMiss, m2.Get("foo", &v))
+
+	assert.NoError(t, m.Clear())
+	_, err = m.GetRaw("foo")
+	assert.Equal(t, kv.ErrCacheMiss, err)
+}
+
+func TestWatch(t *testing.T) {
+	var vv testStruct
+	m, _ := newTestMemcached(t)
+
+	events, cancel := m.Watch("foo")
+	assert.NoError(t, m.Set("bar", testStruct{"bar"}))
+	assert.NoError(t, m.SetWithTTL("foo", testStruct{"foo"}, time.Minute))
+	assert.NoError(t, m.Touch("foo", time.Hour))
+	assert.NoError(t, m.DelMulti([]string{"foo", "bar"}))
+
+	e := <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+
+	// Touch rewrites the key, so watchers see the unchanged value again
+	vv = testStruct{}
+	e = <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "foo"}, <-events)
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+}
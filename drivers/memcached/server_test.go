@@ -0,0 +1,226 @@
+package memcached
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is an in-process memcached server speaking the subset of the text protocol used
+// by the memcache client, so tests run without a real server
+type fakeServer struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	items  map[string]*fakeItem
+	cas    uint64
+	offset time.Duration
+}
+
+type fakeItem struct {
+	value []byte
+	flags uint32
+	exp   time.Time
+	cas   uint64
+}
+
+// newFakeServer starts a fake server listening on a random local port, which is stopped once
+// the test completes
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln, items: make(map[string]*fakeItem)}
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+	})
+	return s
+}
+
+// Addr returns the address the server listens on
+func (s *fakeServer) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// FastForward moves the server clock forward, expiring items
+func (s *fakeServer) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		if err := s.command(rw, args); err != nil {
+			return
+		}
+		if err := rw.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) command(rw *bufio.ReadWriter, args []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch cmd := args[0]; cmd {
+	case "get", "gets":
+		for _, key := range args[1:] {
+			it := s.get(key)
+			if it == nil {
+				continue
+			}
+			if cmd == "gets" {
+				fmt.Fprintf(rw, "VALUE %s %d %d %d\r\n", key, it.flags, len(it.value), it.cas)
+			} else {
+				fmt.Fprintf(rw, "VALUE %s %d %d\r\n", key, it.flags, len(it.value))
+			}
+			rw.Write(it.value)
+			rw.WriteString("\r\n")
+		}
+		rw.WriteString("END\r\n")
+	case "set", "add", "replace", "cas":
+		if len(args) < 5 || (cmd == "cas" && len(args) < 6) {
+			rw.WriteString("ERROR\r\n")
+			return nil
+		}
+		flags, _ := strconv.ParseUint(args[2], 10, 32)
+		exptime, _ := strconv.ParseInt(args[3], 10, 64)
+		size, err := strconv.Atoi(args[4])
+		if err != nil {
+			rw.WriteString("CLIENT_ERROR bad data chunk\r\n")
+			return nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(rw, data); err != nil {
+			return err
+		}
+		var casID uint64
+		if cmd == "cas" {
+			casID, _ = strconv.ParseUint(args[5], 10, 64)
+		}
+		rw.WriteString(s.store(cmd, args[1], data[:size], uint32(flags), exptime, casID) + "\r\n")
+	case "delete":
+		if s.get(args[1]) == nil {
+			rw.WriteString("NOT_FOUND\r\n")
+			return nil
+		}
+		delete(s.items, args[1])
+		rw.WriteString("DELETED\r\n")
+	case "touch":
+		it := s.get(args[1])
+		if it == nil {
+			rw.WriteString("NOT_FOUND\r\n")
+			return nil
+		}
+		exptime, _ := strconv.ParseInt(args[2], 10, 64)
+		it.exp = s.expiry(exptime)
+		rw.WriteString("TOUCHED\r\n")
+	case "incr", "decr":
+		it := s.get(args[1])
+		if it == nil {
+			rw.WriteString("NOT_FOUND\r\n")
+			return nil
+		}
+		n, err := strconv.ParseUint(string(it.value), 10, 64)
+		if err != nil {
+			rw.WriteString("CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+			return nil
+		}
+		delta, _ := strconv.ParseUint(args[2], 10, 64)
+		switch {
+		case cmd == "incr":
+			n += delta
+		case delta > n:
+			n = 0
+		default:
+			n -= delta
+		}
+		s.cas++
+		it.value, it.cas = []byte(strconv.FormatUint(n, 10)), s.cas
+		rw.WriteString(string(it.value) + "\r\n")
+	case "flush_all":
+		s.items = make(map[string]*fakeItem)
+		rw.WriteString("OK\r\n")
+	case "version":
+		rw.WriteString("VERSION fake\r\n")
+	default:
+		rw.WriteString("ERROR\r\n")
+	}
+	return nil
+}
+
+func (s *fakeServer) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+// expiry converts a memcached expiration to a time. Expirations up to 30 days are relative,
+// longer ones are unix timestamps and negative ones expire immediately.
+func (s *fakeServer) expiry(exptime int64) time.Time {
+	switch {
+	case exptime == 0:
+		return time.Time{}
+	case exptime < 0:
+		return s.now()
+	case exptime <= int64(maxRelativeExpiration/time.Second):
+		return s.now().Add(time.Duration(exptime) * time.Second)
+	}
+	return time.Unix(exptime, 0)
+}
+
+// get returns the item of the key, removing it if it has expired
+func (s *fakeServer) get(key string) *fakeItem {
+	it, ok := s.items[key]
+	if !ok {
+		return nil
+	}
+	if !it.exp.IsZero() && !s.now().Before(it.exp) {
+		delete(s.items, key)
+		return nil
+	}
+	return it
+}
+
+// store handles the storage commands, returning the response line
+func (s *fakeServer) store(cmd, key string, value []byte, flags uint32, exptime int64, casID uint64) string {
+	cur := s.get(key)
+	switch {
+	case cmd == "add" && cur != nil, cmd == "replace" && cur == nil:
+		return "NOT_STORED"
+	case cmd == "cas" && cur == nil:
+		return "NOT_FOUND"
+	case cmd == "cas" && cur.cas != casID:
+		return "EXISTS"
+	}
+	s.cas++
+	s.items[key] = &fakeItem{value: value, flags: flags, exp: s.expiry(exptime), cas: s.cas}
+	return "STORED"
+}
@@ -0,0 +1,242 @@
+package memory
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// Policy determines which key is evicted once a bounded store is full
+type Policy int
+
+const (
+	// LRU evicts the least recently used key
+	LRU Policy = iota
+	// LFU evicts the least frequently used key, breaking ties by evicting the least recently used one
+	LFU
+	// ARC is the Adaptive Replacement Cache policy, which balances between recency and frequency
+	// by keeping track of recently evicted keys
+	ARC
+)
+
+// evictor tracks the keys of a shard to decide which one to evict. It isn't safe for
+// concurrent use, the shard must be locked.
+type evictor interface {
+	// add records a new key
+	add(key string)
+	// hit records an access to an existing key
+	hit(key string)
+	// remove forgets a key which was deleted
+	remove(key string)
+	// evict picks a key to evict to make room for the incoming key and forgets it
+	evict(incoming string) string
+}
+
+func newEvictor(p Policy, capacity int) evictor {
+	switch p {
+	case LFU:
+		return newLFU()
+	case ARC:
+		return newARC(capacity)
+	}
+	return newLRU()
+}
+
+// lru is an evictor keeping keys in order of their last access
+type lru struct {
+	ll    *list.List
+	elems map[string]*list.Element
+}
+
+func newLRU() *lru {
+	return &lru{ll: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (l *lru) add(key string) {
+	l.elems[key] = l.ll.PushFront(key)
+}
+
+func (l *lru) hit(key string) {
+	if e, ok := l.elems[key]; ok {
+		l.ll.MoveToFront(e)
+	}
+}
+
+func (l *lru) remove(key string) {
+	if e, ok := l.elems[key]; ok {
+		l.ll.Remove(e)
+		delete(l.elems, key)
+	}
+}
+
+func (l *lru) evict(incoming string) string {
+	key := l.ll.Back().Value.(string)
+	l.remove(key)
+	return key
+}
+
+func (l *lru) has(key string) bool {
+	_, ok := l.elems[key]
+	return ok
+}
+
+func (l *lru) len() int {
+	return l.ll.Len()
+}
+
+// lfuEntry is a key tracked by the lfu evictor
+type lfuEntry struct {
+	key   string
+	freq  int
+	seq   uint64
+	index int
+}
+
+// lfuHeap is a min heap of entries ordered by frequency, then by last access
+type lfuHeap []*lfuEntry
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *lfuHeap) Push(x interface{}) {
+	e := x.(*lfuEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *lfuHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// lfu is an evictor keeping keys in order of their access count
+type lfu struct {
+	h       lfuHeap
+	entries map[string]*lfuEntry
+	seq     uint64
+}
+
+func newLFU() *lfu {
+	return &lfu{entries: make(map[string]*lfuEntry)}
+}
+
+func (l *lfu) add(key string) {
+	l.seq++
+	e := &lfuEntry{key: key, freq: 1, seq: l.seq}
+	l.entries[key] = e
+	heap.Push(&l.h, e)
+}
+
+func (l *lfu) hit(key string) {
+	if e, ok := l.entries[key]; ok {
+		l.seq++
+		e.freq++
+		e.seq = l.seq
+		heap.Fix(&l.h, e.index)
+	}
+}
+
+func (l *lfu) remove(key string) {
+	if e, ok := l.entries[key]; ok {
+		heap.Remove(&l.h, e.index)
+		delete(l.entries, key)
+	}
+}
+
+func (l *lfu) evict(incoming string) string {
+	key := l.h[0].key
+	l.remove(key)
+	return key
+}
+
+// arc is an evictor implementing the Adaptive Replacement Cache policy. t1 holds keys seen once
+// recently and t2 keys seen at least twice. b1 and b2 are "ghost" lists of keys recently evicted
+// from t1 and t2, which adapt the target size p of t1 when they're seen again.
+type arc struct {
+	c, p           int
+	t1, t2, b1, b2 *lru
+}
+
+func newARC(capacity int) *arc {
+	return &arc{c: capacity, t1: newLRU(), t2: newLRU(), b1: newLRU(), b2: newLRU()}
+}
+
+func (a *arc) add(key string) {
+	switch {
+	case a.b1.has(key):
+		a.b1.remove(key)
+		a.t2.add(key)
+	case a.b2.has(key):
+		a.b2.remove(key)
+		a.t2.add(key)
+	default:
+		a.t1.add(key)
+	}
+	for a.t1.len()+a.b1.len() > a.c && a.b1.len() > 0 {
+		a.b1.evict("")
+	}
+	for a.t1.len()+a.t2.len()+a.b1.len()+a.b2.len() > 2*a.c && a.b2.len() > 0 {
+		a.b2.evict("")
+	}
+}
+
+func (a *arc) hit(key string) {
+	switch {
+	case a.t1.has(key):
+		a.t1.remove(key)
+		a.t2.add(key)
+	case a.t2.has(key):
+		a.t2.hit(key)
+	}
+}
+
+func (a *arc) remove(key string) {
+	a.t1.remove(key)
+	a.t2.remove(key)
+	a.b1.remove(key)
+	a.b2.remove(key)
+}
+
+func (a *arc) evict(incoming string) string {
+	// A hit in a ghost list means the list it was evicted from should have been larger
+	switch {
+	case a.b1.has(incoming):
+		delta := 1
+		if a.b2.len() > a.b1.len() {
+			delta = a.b2.len() / a.b1.len()
+		}
+		if a.p += delta; a.p > a.c {
+			a.p = a.c
+		}
+	case a.b2.has(incoming):
+		delta := 1
+		if a.b1.len() > a.b2.len() {
+			delta = a.b1.len() / a.b2.len()
+		}
+		if a.p -= delta; a.p < 0 {
+			a.p = 0
+		}
+	}
+
+	fromT1 := a.t1.len() > 0 && (a.t1.len() > a.p || (a.b2.has(incoming) && a.t1.len() == a.p))
+	if fromT1 || a.t2.len() == 0 {
+		key := a.t1.evict(incoming)
+		a.b1.add(key)
+		return key
+	}
+	key := a.t2.evict(incoming)
+	a.b2.add(key)
+	return key
+}
@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fill adds the keys to the evictor, evicting a key whenever it holds capacity keys
+func fill(e evictor, capacity int, held map[string]bool, keys ...string) (evicted []string) {
+	for _, key := range keys {
+		if held[key] {
+			e.hit(key)
+			continue
+		}
+		if len(held) >= capacity {
+			victim := e.evict(key)
+			delete(held, victim)
+			evicted = append(evicted, victim)
+		}
+		e.add(key)
+		held[key] = true
+	}
+	return
+}
+
+func TestLRU(t *testing.T) {
+	held := map[string]bool{}
+	e := newLRU()
+	assert.Equal(t, []string{"a", "c"}, fill(e, 2, held, "a", "b", "b", "c", "b", "d"))
+	e.remove("b")
+	assert.Equal(t, "d", e.evict(""))
+	assert.Equal(t, 0, e.len())
+}
+
+func TestLFU(t *testing.T) {
+	held := map[string]bool{}
+	e := newLFU()
+	assert.Equal(t, []string{"b", "c"}, fill(e, 2, held, "a", "a", "a", "b", "c", "d"))
+	e.remove("a")
+	assert.Equal(t, "d", e.evict(""))
+	assert.Len(t, e.h, 0)
+}
+
+func TestARC(t *testing.T) {
+	held := map[string]bool{}
+	e := newARC(2)
+
+	// "a" is seen twice so it's kept over keys only seen once
+	assert.Equal(t, []string{"b", "c"}, fill(e, 2, held, "a", "a", "b", "c", "d"))
+	assert.True(t, e.t2.has("a"))
+	assert.True(t, e.b1.has("b") || e.b1.has("c"))
+
+	// Seeing a recently evicted key again grows the target size of t1
+	assert.Equal(t, 0, e.p)
+	fill(e, 2, held, "c")
+	assert.Equal(t, 1, e.p)
+	assert.True(t, e.t2.has("c"))
+
+	// Ghost lists never hold more than the capacity
+	fill(e, 2, held, "e", "f", "g", "h", "i")
+	assert.True(t, e.t1.len()+e.b1.len() <= 2)
+	assert.True(t, e.t1.len()+e.t2.len()+e.b1.len()+e.b2.len() <= 4)
+
+	e.remove("c")
+	assert.False(t, e.t1.has("c") || e.t2.has("c") || e.b1.has("c") || e.b2.has("c"))
+}
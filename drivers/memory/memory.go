@@ -0,0 +1,445 @@
+// Package memory implements an in-memory key/value store with optional size bound, eviction
+// policies and per-key expiration
+package memory
+
+import (
+	"hash/fnv"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"golang.org/x/net/context"
+)
+
+// DefaultShards is the number of shards used if Options.Shards isn't set
+const DefaultShards = 32
+
+// minShardItems is the fewest keys a shard of a bounded store holds, so keys aren't evicted long
+// before the store is full because a few shards received more keys than others
+const minShardItems = 64
+
+var (
+	// Codec is the codec used to marshal/unmarshal values unless the store uses zero-copy mode or
+	// is created with kv.WithCodec. The default codec is Gob
+	Codec codec.Codec
+
+	// ensure struct implements the kv.Store interface
+	_ kv.Store        = (*Store)(nil)
+	_ kv.ContextStore = (*Store)(nil)
+	_ kv.Expirer      = (*Store)(nil)
+	_ kv.Sweeper      = (*Store)(nil)
+	_ kv.Datastore    = (*Store)(nil)
+	_ kv.Clearer      = (*Store)(nil)
+	_ kv.Watcher      = (*Store)(nil)
+	_ kv.RawStore     = (*Store)(nil)
+)
+
+func init() {
+	Codec = codec.Gob
+}
+
+// Options configures a memory Store
+type Options struct {
+	// Shards is the number of independently locked maps the keys are spread across. More shards
+	// mean less lock contention. Defaults to DefaultShards.
+	Shards int
+
+	// MaxItems bounds the number of keys held, zero means unbounded. The bound is split evenly
+	// across shards, so keys may be evicted slightly before the store as a whole is full. Bounded
+	// stores use fewer shards if needed so each shard holds at least 64 keys.
+	MaxItems int
+
+	// Eviction is the policy used to pick which key to evict once MaxItems is reached.
+	// Defaults to LRU.
+	Eviction Policy
+
+	// ZeroCopy stores values as is instead of encoding them with Codec, which is faster but
+	// means values are shared with callers and must not be modified after Set or Get. Get
+	// requires dstVal to be a pointer to the stored type, or to the type it points to.
+	ZeroCopy bool
+}
+
+// Store is a concurrency-safe in-memory key/value store
+type Store struct {
+	shards   []*shard
+	policy   Policy
+	zeroCopy bool
+	opts     kv.Options
+	hub      kv.Hub
+}
+
+// shard is a part of the store with its own lock
+type shard struct {
+	sync.Mutex
+	items    map[string]*item
+	capacity int
+	evictor  evictor
+}
+
+// item is a stored value. data holds the encoded value, or value holds the value itself in
+// zero-copy mode unless the item was set with SetRaw. Items are never modified once stored, so
+// they can be read after the shard is unlocked.
+type item struct {
+	data  []byte
+	value interface{}
+	exp   time.Time
+}
+
+func (it *item) expired() bool {
+	return codec.Expired(it.exp)
+}
+
+// New returns a new in-memory store. Options like kv.WithCodec configure the codec, which
+// defaults to the package level Codec.
+func New(options Options, opts ...kv.Option) *Store {
+	n := options.Shards
+	if n <= 0 {
+		n = DefaultShards
+	}
+	if max := options.MaxItems / minShardItems; options.MaxItems > 0 && n > max {
+		n = max
+		if n < 1 {
+			n = 1
+		}
+	}
+	s := &Store{
+		shards:   make([]*shard, n),
+		policy:   options.Eviction,
+		zeroCopy: options.ZeroCopy,
+		opts:     kv.NewOptions(opts...),
+	}
+	for i := range s.shards {
+		sh := &shard{items: make(map[string]*item)}
+		if options.MaxItems > 0 {
+			sh.capacity = options.MaxItems / n
+			if i < options.MaxItems%n {
+				sh.capacity++
+			}
+			sh.evictor = newEvictor(options.Eviction, sh.capacity)
+		}
+		s.shards[i] = sh
+	}
+	return s
+}
+
+// Codec returns the codec used to marshal/unmarshal values
+func (s *Store) Codec() codec.Codec {
+	return s.opts.CodecOr(Codec)
+}
+
+func (s *Store) shard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+// Set implements the "kv.Store".Set() interface
+func (s *Store) Set(key string, value interface{}) error {
+	return s.set(key, value, time.Time{})
+}
+
+// Get implements the "kv.Store".Get() interface
+func (s *Store) Get(key string, dstVal interface{}) error {
+	it, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	if s.zeroCopy && it.data == nil {
+		return assign(it.value, dstVal)
+	}
+	return s.Codec().Unmarshal(it.data, dstVal)
+}
+
+// Del implements the "kv.Store".Del() interface
+func (s *Store) Del(key string) error {
+	sh := s.shard(key)
+	sh.Lock()
+	_, ok := sh.items[key]
+	if ok {
+		sh.remove(key)
+	}
+	sh.Unlock()
+	if !ok {
+		return kv.ErrNotFound
+	}
+	s.hub.Delete(key)
+	return nil
+}
+
+// SetContext implements the "kv.ContextStore".SetContext() interface
+func (s *Store) SetContext(ctx context.Context, key string, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Set(key, value)
+}
+
+// GetContext implements the "kv.ContextStore".GetContext() interface
+func (s *Store) GetContext(ctx context.Context, key string, dstVal interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Get(key, dstVal)
+}
+
+// DelContext implements the "kv.ContextStore".DelContext() interface
+func (s *Store) DelContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Del(key)
+}
+
+func (s *Store) set(key string, value interface{}, exp time.Time) error {
+	it := &item{value: value, exp: exp}
+	if !s.zeroCopy {
+		b, err := s.Codec().Marshal(value)
+		if err != nil {
+			return err
+		}
+		it = &item{data: b, exp: exp}
+	}
+	s.put(key, it)
+	return nil
+}
+
+// put stores the item, evicting another key if the shard is full, and notifies watchers
+func (s *Store) put(key string, it *item) {
+	sh := s.shard(key)
+	sh.Lock()
+	evicted, ok := sh.put(key, it)
+	sh.Unlock()
+	if ok {
+		s.hub.Delete(evicted)
+	}
+	s.publish(key, it)
+}
+
+// publish notifies watchers that the key was set to the item
+func (s *Store) publish(key string, it *item) {
+	var val []byte
+	if it.data != nil {
+		val = append([]byte(nil), it.data...)
+	}
+	s.hub.Put(key, val)
+}
+
+// get returns the item of the key, removing it if it has expired
+func (s *Store) get(key string) (*item, error) {
+	sh := s.shard(key)
+	sh.Lock()
+	it, ok := sh.items[key]
+	switch {
+	case !ok:
+		sh.Unlock()
+		return nil, kv.ErrNotFound
+	case it.expired():
+		sh.remove(key)
+		sh.Unlock()
+		s.hub.Delete(key)
+		return nil, kv.ErrCacheMiss
+	}
+	if sh.evictor != nil {
+		sh.evictor.hit(key)
+	}
+	sh.Unlock()
+	return it, nil
+}
+
+// put stores the item, returning the key evicted to make room for it if any. The shard must be locked.
+func (sh *shard) put(key string, it *item) (evicted string, ok bool) {
+	if _, exists := sh.items[key]; exists {
+		sh.items[key] = it
+		if sh.evictor != nil {
+			sh.evictor.hit(key)
+		}
+		return
+	}
+	if sh.evictor != nil {
+		if len(sh.items) >= sh.capacity {
+			evicted, ok = sh.evictor.evict(key), true
+			delete(sh.items, evicted)
+		}
+		sh.evictor.add(key)
+	}
+	sh.items[key] = it
+	return
+}
+
+// remove deletes the key. The shard must be locked.
+func (sh *shard) remove(key string) {
+	delete(sh.items, key)
+	if sh.evictor != nil {
+		sh.evictor.remove(key)
+	}
+}
+
+// assign sets the value pointed to by dstVal to v, dereferencing v if it's a pointer to the type
+func assign(v, dstVal interface{}) error {
+	dv := reflect.ValueOf(dstVal)
+	if dv.Kind() != reflect.Ptr || dv.IsNil() {
+		return kv.ErrInvalidDstVal
+	}
+	dst, rv := dv.Elem(), reflect.ValueOf(v)
+	switch {
+	case !rv.IsValid():
+		dst.Set(reflect.Zero(dst.Type()))
+	case rv.Type().AssignableTo(dst.Type()):
+		dst.Set(rv)
+	case rv.Kind() == reflect.Ptr && !rv.IsNil() && rv.Elem().Type().AssignableTo(dst.Type()):
+		dst.Set(rv.Elem())
+	default:
+		return kv.ErrInvalidDstVal
+	}
+	return nil
+}
+
+// GetRaw implements the "kv.RawStore".GetRaw() interface. The value is prefixed with its
+// expiration like codec.WithExpiry() does, and values stored in zero-copy mode are encoded first.
+func (s *Store) GetRaw(key string) ([]byte, error) {
+	it, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	data := it.data
+	if s.zeroCopy && data == nil {
+		if data, err = s.Codec().Marshal(it.value); err != nil {
+			return nil, err
+		}
+	}
+	return codec.WithExpiry(append([]byte(nil), data...), it.exp), nil
+}
+
+// SetRaw implements the "kv.RawStore".SetRaw() interface, keeping the expiration of values
+// returned by GetRaw. The value is stored encoded even in zero-copy mode.
+func (s *Store) SetRaw(key string, b []byte) error {
+	data, exp := codec.SplitExpiry(b)
+	s.put(key, &item{data: append([]byte{}, data...), exp: exp})
+	return nil
+}
+
+// Exists returns true if the key exists and hasn't expired
+func (s *Store) Exists(key string) bool {
+	sh := s.shard(key)
+	sh.Lock()
+	defer sh.Unlock()
+	it, ok := sh.items[key]
+	return ok && !it.expired()
+}
+
+// Len returns the number of keys held, including expired keys which haven't been removed yet
+func (s *Store) Len() int {
+	var n int
+	for _, sh := range s.shards {
+		sh.Lock()
+		n += len(sh.items)
+		sh.Unlock()
+	}
+	return n
+}
+
+// SetWithTTL implements the "kv.Expirer".SetWithTTL() interface
+func (s *Store) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	return s.set(key, value, codec.ExpiresAt(ttl))
+}
+
+// TTL implements the "kv.Expirer".TTL() interface
+func (s *Store) TTL(key string) (time.Duration, error) {
+	sh := s.shard(key)
+	sh.Lock()
+	it, ok := sh.items[key]
+	sh.Unlock()
+	switch {
+	case !ok:
+		return 0, kv.ErrNotFound
+	case it.exp.IsZero():
+		return kv.NoExpiration, nil
+	case it.expired():
+		return 0, kv.ErrCacheMiss
+	}
+	return it.exp.Sub(time.Now()), nil
+}
+
+// Touch implements the "kv.Expirer".Touch() interface
+func (s *Store) Touch(key string, ttl time.Duration) error {
+	sh := s.shard(key)
+	sh.Lock()
+	it, ok := sh.items[key]
+	switch {
+	case !ok:
+		sh.Unlock()
+		return kv.ErrNotFound
+	case it.expired():
+		sh.Unlock()
+		return kv.ErrCacheMiss
+	}
+	sh.items[key] = &item{data: it.data, value: it.value, exp: codec.ExpiresAt(ttl)}
+	sh.Unlock()
+	s.publish(key, it)
+	return nil
+}
+
+// Sweep implements the "kv.Sweeper".Sweep() interface, removing all expired keys. Expired keys
+// are also removed when they're read, so sweeping is only needed to reclaim memory of keys which
+// aren't. Use kv.SweepEvery() to run it periodically.
+func (s *Store) Sweep() error {
+	for _, sh := range s.shards {
+		var expired []string
+		sh.Lock()
+		for key, it := range sh.items {
+			if it.expired() {
+				sh.remove(key)
+				expired = append(expired, key)
+			}
+		}
+		sh.Unlock()
+		s.hub.Delete(expired...)
+	}
+	return nil
+}
+
+// Keys implements the "kv.KeyList".Keys() interface. Expired keys are left out.
+func (s *Store) Keys() []string {
+	keys := []string{}
+	for _, sh := range s.shards {
+		sh.Lock()
+		for key, it := range sh.items {
+			if !it.expired() {
+				keys = append(keys, key)
+			}
+		}
+		sh.Unlock()
+	}
+	return keys
+}
+
+// Transfer implements the "kv.Datastore".Transfer() interface, copying all keys to dst
+func (s *Store) Transfer(dst kv.Store) error {
+	return kv.Transfer(s, dst)
+}
+
+// Clear implements the "kv.Clearer".Clear() interface. Watchers receive a delete event for every key.
+func (s *Store) Clear() error {
+	for _, sh := range s.shards {
+		sh.Lock()
+		keys := make([]string, 0, len(sh.items))
+		for key := range sh.items {
+			keys = append(keys, key)
+		}
+		sh.items = make(map[string]*item)
+		if sh.evictor != nil {
+			sh.evictor = newEvictor(s.policy, sh.capacity)
+		}
+		sh.Unlock()
+		s.hub.Delete(keys...)
+	}
+	return nil
+}
+
+// Watch implements the "kv.Watcher".Watch() interface. In zero-copy mode the Value of put
+// events is nil, since values aren't encoded.
+func (s *Store) Watch(prefix string) (<-chan kv.Event, func()) {
+	return s.hub.Watch(prefix)
+}
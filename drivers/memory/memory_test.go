@@ -0,0 +1,346 @@
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type testStruct struct {
+	Foo string
+}
+
+func TestNew(t *testing.T) {
+	s := New(Options{})
+	assert.Len(t, s.shards, DefaultShards)
+	assert.Nil(t, s.shards[0].evictor)
+
+	s = New(Options{Shards: 4, MaxItems: 300, Eviction: LFU})
+	assert.Len(t, s.shards, 4)
+	assert.Equal(t, 75, s.shards[0].capacity)
+	assert.IsType(t, &lfu{}, s.shards[0].evictor)
+
+	s = New(Options{MaxItems: 2, Eviction: ARC})
+	assert.Len(t, s.shards, 1)
+	assert.Equal(t, 2, s.shards[0].capacity)
+	assert.IsType(t, &arc{}, s.shards[0].evictor)
+
+	// Shards hold at least 64 keys, and their capacities add up to MaxItems
+	s = New(Options{MaxItems: 1000})
+	assert.Len(t, s.shards, 15)
+	total := 0
+	for _, sh := range s.shards {
+		assert.True(t, sh.capacity >= 66)
+		total += sh.capacity
+	}
+	assert.Equal(t, 1000, total)
+}
+
+func TestCapacity(t *testing.T) {
+	// A bounded store holds close to MaxItems keys before evicting any
+	for max, min := range map[int]int{100: 100, 1000: 900} {
+		s := New(Options{MaxItems: max})
+		for i := 0; i < max; i++ {
+			assert.NoError(t, s.Set(fmt.Sprintf("key-%d", i), i))
+		}
+		assert.True(t, s.Len() >= min, "max %d, len %d", max, s.Len())
+	}
+}
+
+func TestGetSetDel(t *testing.T) {
+	var v testStruct
+	s := New(Options{})
+	assert.Equal(t, kv.ErrNotFound, s.Get("foo", &v))
+	assert.NoError(t, s.Set("foo", testStruct{"bar"}))
+	assert.True(t, s.Exists("foo"))
+	assert.Equal(t, 1, s.Len())
+	assert.NoError(t, s.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+	assert.NoError(t, s.Del("foo"))
+	assert.False(t, s.Exists("foo"))
+	assert.Equal(t, kv.ErrNotFound, s.Del("foo"))
+}
+
+func TestEncodedCopy(t *testing.T) {
+	v := &testStruct{"bar"}
+	s := New(Options{})
+	assert.NoError(t, s.Set("foo", v))
+	v.Foo = "baz"
+
+	var vv testStruct
+	assert.NoError(t, s.Get("foo", &vv))
+	assert.Equal(t, "bar", vv.Foo)
+}
+
+func TestSetErr(t *testing.T) {
+	s := New(Options{}, kv.WithCodec(codec.ErrTestCodec))
+	assert.Error(t, s.Set("foo", testStruct{"bar"}))
+	assert.False(t, s.Exists("foo"))
+}
+
+// TestRawValues checks blobs are stored verbatim with codec.RawOr
+func TestRawValues(t *testing.T) {
+	var str string
+	var b []byte
+	var buf bytes.Buffer
+	s := New(Options{}, kv.WithCodec(codec.RawOr(codec.Gob)))
+	assert.NoError(t, s.Set("foo", []byte("\x89PNG")))
+	assert.NoError(t, s.Get("foo", &b))
+	assert.Equal(t, []byte("\x89PNG"), b)
+	assert.NoError(t, s.Set("bar", strings.NewReader("bar")))
+	assert.NoError(t, s.Get("bar", &str))
+	assert.Equal(t, "bar", str)
+	assert.NoError(t, s.Get("bar", &buf))
+	assert.Equal(t, "bar", buf.String())
+
+	// Blobs which start like an expiry header aren't mistaken for one
+	ttl := []byte("\xffTTL\x00\x00\x00\x00\x00\x00\x00\x01foo")
+	assert.NoError(t, s.Set("baz", ttl))
+	assert.NoError(t, s.Get("baz", &b))
+	assert.Equal(t, ttl, b)
+}
+
+func TestCodec(t *testing.T) {
+	var v testStruct
+	s := New(Options{}, kv.WithCodec(codec.JSON))
+	assert.True(t, codec.Equal(codec.JSON, s.Codec()))
+	assert.True(t, codec.Equal(Codec, New(Options{}).Codec()))
+	assert.NoError(t, s.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, s.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+}
+
+func TestZeroCopy(t *testing.T) {
+	v := &testStruct{"bar"}
+	s := New(Options{ZeroCopy: true})
+	assert.NoError(t, s.Set("foo", v))
+
+	var p *testStruct
+	assert.NoError(t, s.Get("foo", &p))
+	assert.True(t, v == p)
+
+	var vv testStruct
+	assert.NoError(t, s.Get("foo", &vv))
+	assert.Equal(t, "bar", vv.Foo)
+
+	var i interface{}
+	assert.NoError(t, s.Get("foo", &i))
+	assert.Equal(t, v, i)
+
+	var str string
+	assert.Equal(t, kv.ErrInvalidDstVal, s.Get("foo", &str))
+	assert.Equal(t, kv.ErrInvalidDstVal, s.Get("foo", vv))
+
+	assert.NoError(t, s.Set("nil", nil))
+	assert.NoError(t, s.Get("nil", &p))
+	assert.Nil(t, p)
+}
+
+func TestContext(t *testing.T) {
+	var s string
+	m := New(Options{})
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, m.SetContext(ctx, "foo", "bar"))
+	assert.NoError(t, m.GetContext(ctx, "foo", &s))
+	assert.Equal(t, "bar", s)
+
+	cancel()
+	assert.Equal(t, context.Canceled, m.SetContext(ctx, "bar", "baz"))
+	assert.Equal(t, context.Canceled, m.GetContext(ctx, "foo", &s))
+	assert.Equal(t, context.Canceled, m.DelContext(ctx, "foo"))
+	assert.True(t, m.Exists("foo"))
+	assert.False(t, m.Exists("bar"))
+}
+
+func TestTTL(t *testing.T) {
+	var v testStruct
+	s := New(Options{})
+	assert.NoError(t, s.Set("foo", testStruct{"foo"}))
+	ttl, err := s.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+
+	assert.NoError(t, s.SetWithTTL("bar", testStruct{"bar"}, time.Millisecond))
+	ttl, err = s.TTL("bar")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	_, err = s.TTL("bar")
+	assert.Equal(t, kv.ErrCacheMiss, err)
+	assert.Equal(t, kv.ErrCacheMiss, s.Touch("bar", time.Minute))
+	assert.Equal(t, kv.ErrCacheMiss, s.Get("bar", &v))
+	assert.Equal(t, kv.ErrNotFound, s.Get("bar", &v))
+
+	assert.NoError(t, s.Touch("foo", time.Minute))
+	ttl, err = s.TTL("foo")
+	assert.NoError(t, err)
+	assert.True(t, ttl > time.Second)
+	assert.NoError(t, s.Get("foo", &v))
+	assert.Equal(t, "foo", v.Foo)
+	assert.Equal(t, kv.ErrNotFound, s.Touch("baz", time.Minute))
+	_, err = s.TTL("baz")
+	assert.Equal(t, kv.ErrNotFound, err)
+}
+
+func TestSweep(t *testing.T) {
+	s := New(Options{})
+	assert.NoError(t, s.Set("foo", "foo"))
+	assert.NoError(t, s.SetWithTTL("bar", "bar", time.Millisecond))
+	assert.NoError(t, s.SetWithTTL("baz", "baz", time.Minute))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, 3, s.Len())
+	assert.NoError(t, s.Sweep())
+	assert.Equal(t, 2, s.Len())
+	assert.False(t, s.Exists("bar"))
+}
+
+func TestEviction(t *testing.T) {
+	var v string
+	for _, p := range []Policy{LRU, LFU, ARC} {
+		s := New(Options{Shards: 1, MaxItems: 2, Eviction: p})
+		assert.NoError(t, s.Set("foo", "foo"))
+		assert.NoError(t, s.Set("bar", "bar"))
+		assert.NoError(t, s.Get("foo", &v))
+		assert.NoError(t, s.Set("baz", "baz"))
+		assert.Equal(t, 2, s.Len(), "policy %d", p)
+		assert.True(t, s.Exists("foo"), "policy %d", p)
+		assert.False(t, s.Exists("bar"), "policy %d", p)
+		assert.True(t, s.Exists("baz"), "policy %d", p)
+
+		// Updating an existing key never evicts
+		assert.NoError(t, s.Set("baz", "qux"))
+		assert.Equal(t, 2, s.Len(), "policy %d", p)
+	}
+}
+
+func TestKeysTransferClear(t *testing.T) {
+	var v string
+	s, s2 := New(Options{ZeroCopy: true}), New(Options{})
+	assert.NoError(t, s.Set("foo", "foo"))
+	assert.NoError(t, s.SetWithTTL("bar", "bar", time.Minute))
+	assert.NoError(t, s.SetWithTTL("baz", "baz", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	keys := s.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+
+	assert.NoError(t, s.Transfer(s2))
+	keys = s2.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+	assert.NoError(t, s2.Get("foo", &v))
+	assert.Equal(t, "foo", v)
+
+	assert.NoError(t, s.Clear())
+	assert.Len(t, s.Keys(), 0)
+	assert.Equal(t, kv.ErrNotFound, s.Get("foo", &v))
+	assert.NoError(t, s.Set("foo", "foo"))
+	assert.Len(t, s.Keys(), 1)
+}
+
+func TestTransferEncoded(t *testing.T) {
+	var v testStruct
+	var str string
+	s, s2 := New(Options{}), New(Options{})
+	assert.NoError(t, s.Set("foo", testStruct{"foo"}))
+	assert.NoError(t, s.SetWithTTL("bar", "bar", time.Minute))
+	assert.NoError(t, s.Transfer(s2))
+	assert.NoError(t, s2.Get("foo", &v))
+	assert.Equal(t, testStruct{"foo"}, v)
+	assert.NoError(t, s2.Get("bar", &str))
+	assert.Equal(t, "bar", str)
+	ttl, err := s2.TTL("bar")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 59*time.Second && ttl <= time.Minute)
+	ttl, err = s2.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+}
+
+func TestRaw(t *testing.T) {
+	var v testStruct
+	s, zc := New(Options{}), New(Options{ZeroCopy: true})
+	for _, st := range []*Store{s, zc} {
+		assert.NoError(t, st.SetWithTTL("foo", testStruct{"foo"}, time.Minute))
+		b, err := st.GetRaw("foo")
+		assert.NoError(t, err)
+		data, exp := codec.SplitExpiry(b)
+		assert.False(t, exp.IsZero())
+		assert.NoError(t, Codec.Unmarshal(data, &v))
+		assert.Equal(t, testStruct{"foo"}, v)
+
+		// Raw values are decoded by Get, also in zero-copy mode
+		assert.NoError(t, st.SetRaw("bar", b))
+		v = testStruct{}
+		assert.NoError(t, st.Get("bar", &v))
+		assert.Equal(t, testStruct{"foo"}, v)
+		ttl, err := st.TTL("bar")
+		assert.NoError(t, err)
+		assert.True(t, ttl > 59*time.Second && ttl <= time.Minute)
+
+		_, err = st.GetRaw("baz")
+		assert.Equal(t, kv.ErrNotFound, err)
+	}
+	s = New(Options{ZeroCopy: true}, kv.WithCodec(codec.ErrTestCodec))
+	assert.NoError(t, s.Set("foo", testStruct{"foo"}))
+	_, err := s.GetRaw("foo")
+	assert.Error(t, err)
+}
+
+func TestWatch(t *testing.T) {
+	var v testStruct
+	s := New(Options{Shards: 1, MaxItems: 1})
+	events, cancel := s.Watch("foo")
+	defer cancel()
+
+	assert.NoError(t, s.Set("foo", testStruct{"foo"}))
+	e := <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &v))
+	assert.Equal(t, testStruct{"foo"}, v)
+
+	// Touch rewrites the key, so watchers see the unchanged value again
+	v = testStruct{}
+	assert.NoError(t, s.Touch("foo", time.Minute))
+	e = <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &v))
+	assert.Equal(t, testStruct{"foo"}, v)
+
+	// Evicted keys are reported as deleted
+	assert.NoError(t, s.Set("bar", testStruct{"bar"}))
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "foo"}, <-events)
+}
+
+func TestConcurrent(t *testing.T) {
+	s := New(Options{MaxItems: 100, Eviction: ARC})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var v int
+			for j := 0; j < 500; j++ {
+				key := fmt.Sprintf("key-%d", (i*j)%200)
+				s.Set(key, j)
+				s.Get(key, &v)
+				if j%10 == 0 {
+					s.Del(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.True(t, s.Len() <= 100)
+}
@@ -0,0 +1,89 @@
+package pebble
+
+import (
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+)
+
+// current returns the encoded value of the key without its expiry header, or nil if the key
+// doesn't exist or has expired. The key must be locked by the caller.
+func (db *DB) current(key string) ([]byte, error) {
+	b, err := get(db.DB(), key)
+	if err == kv.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return nil, nil
+	}
+	return b, nil
+}
+
+// GetWithVersion implements the "kv.CAS".GetWithVersion interface. The version is a hash of
+// the encoded value.
+func (db *DB) GetWithVersion(key string, dstVal interface{}) (uint64, error) {
+	b, err := get(db.DB(), key)
+	if err != nil {
+		return 0, err
+	}
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return 0, kv.ErrCacheMiss
+	}
+	return kv.Version(b), db.Codec().Unmarshal(b, dstVal)
+}
+
+// SetIfVersion implements the "kv.CAS".SetIfVersion interface. Pebble has no conditional
+// writes, so the key is locked while the version is checked and the value written.
+func (db *DB) SetIfVersion(key string, val interface{}, version uint64) (uint64, error) {
+	return db.setIf(key, val, func(cur []byte) bool {
+		return cur != nil && kv.Version(cur) == version
+	})
+}
+
+// SetIfNotExists implements the "kv.CAS".SetIfNotExists interface
+func (db *DB) SetIfNotExists(key string, val interface{}) (uint64, error) {
+	return db.setIf(key, val, func(cur []byte) bool {
+		return cur == nil
+	})
+}
+
+// setIf sets the value if ok returns true for the current value of the key
+func (db *DB) setIf(key string, val interface{}, ok func(cur []byte) bool) (uint64, error) {
+	b, err := db.Codec().Marshal(val)
+	if err != nil {
+		return 0, err
+	}
+	db.locks.Lock(key)
+	defer db.locks.Unlock(key)
+	cur, err := db.current(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok(cur) {
+		return 0, kv.ErrVersionMismatch
+	}
+	if err := db.putLocked(key, codec.WithExpiry(b, time.Time{})); err != nil {
+		return 0, err
+	}
+	return kv.Version(b), nil
+}
+
+// DelIfVersion implements the "kv.CAS".DelIfVersion interface
+func (db *DB) DelIfVersion(key string, version uint64) error {
+	db.locks.Lock(key)
+	defer db.locks.Unlock(key)
+	cur, err := db.current(key)
+	if err != nil {
+		return err
+	}
+	if cur == nil || kv.Version(cur) != version {
+		return kv.ErrVersionMismatch
+	}
+	return db.delLocked(key)
+}
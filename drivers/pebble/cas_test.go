@@ -0,0 +1,11 @@
+package pebble
+
+import (
+	"testing"
+
+	"github.com/bradberger/gokv/kv/kvtest"
+)
+
+func TestCAS(t *testing.T) {
+	kvtest.CAS(t, newTestStore)
+}
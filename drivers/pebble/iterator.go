@@ -0,0 +1,116 @@
+package pebble
+
+import (
+	"bytes"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"github.com/cockroachdb/pebble"
+)
+
+// iterable is implemented by the Pebble database and its snapshots
+type iterable interface {
+	NewIter(o *pebble.IterOptions) (*pebble.Iterator, error)
+}
+
+// iter is a "kv.Iterator" wrapping a Pebble iterator
+type iter struct {
+	db      *DB
+	it      *pebble.Iterator
+	started bool
+	err     error
+}
+
+// Scan implements the "kv.Scanner".Scan interface using a Pebble iterator bounded to the range,
+// which reads from an implicit snapshot of the database. Keys are returned in byte order.
+func (db *DB) Scan(prefix, start, end string) kv.Iterator {
+	return db.scan(db.DB(), prefix, start, end)
+}
+
+// scan returns an iterator over the range of r
+func (db *DB) scan(r iterable, prefix, start, end string) kv.Iterator {
+	it, err := r.NewIter(scanRange(prefix, start, end))
+	if err != nil {
+		return &iter{db: db, err: err}
+	}
+	return &iter{db: db, it: it}
+}
+
+// scanRange returns the bounds of the intersection of the prefix range with [start, end)
+func scanRange(prefix, start, end string) *pebble.IterOptions {
+	o := &pebble.IterOptions{LowerBound: []byte(prefix), UpperBound: prefixEnd([]byte(prefix))}
+	if bytes.Compare([]byte(start), o.LowerBound) > 0 {
+		o.LowerBound = []byte(start)
+	}
+	if end != "" && (o.UpperBound == nil || bytes.Compare([]byte(end), o.UpperBound) < 0) {
+		o.UpperBound = []byte(end)
+	}
+	return o
+}
+
+// prefixEnd returns the smallest key greater than all keys with the prefix, or nil if there's none
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Next implements the "kv.Iterator".Next interface, skipping expired keys
+func (i *iter) Next() bool {
+	if i.it == nil {
+		return false
+	}
+	for {
+		if !i.started {
+			i.started = true
+			i.it.First()
+		} else {
+			i.it.Next()
+		}
+		if !i.it.Valid() {
+			return false
+		}
+		if _, exp := codec.SplitExpiry(i.it.Value()); !codec.Expired(exp) {
+			return true
+		}
+	}
+}
+
+// Key implements the "kv.Iterator".Key interface
+func (i *iter) Key() string {
+	if i.it == nil || !i.it.Valid() {
+		return ""
+	}
+	return string(i.it.Key())
+}
+
+// Value implements the "kv.Iterator".Value interface
+func (i *iter) Value(dstVal interface{}) error {
+	if i.it == nil || !i.it.Valid() {
+		return kv.ErrNotFound
+	}
+	return i.db.unmarshal(i.it.Value(), dstVal)
+}
+
+// Err implements the "kv.Iterator".Err interface
+func (i *iter) Err() error {
+	if i.err != nil || i.it == nil {
+		return i.err
+	}
+	return i.it.Error()
+}
+
+// Close implements the "kv.Iterator".Close interface
+func (i *iter) Close() error {
+	if i.it == nil {
+		return nil
+	}
+	it := i.it
+	i.it = nil
+	return it.Close()
+}
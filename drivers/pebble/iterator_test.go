@@ -0,0 +1,45 @@
+package pebble
+
+import (
+	"testing"
+
+	"github.com/bradberger/gokv/kv"
+	"github.com/bradberger/gokv/kv/kvtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// scanKeys collects the keys of an iterator
+func scanKeys(t *testing.T, it kv.Iterator) []string {
+	keys := []string{}
+	for it.Next() {
+		var v testStruct
+		assert.NoError(t, it.Value(&v))
+		assert.Equal(t, it.Key(), v.Foo)
+		keys = append(keys, it.Key())
+	}
+	assert.NoError(t, it.Err())
+	assert.NoError(t, it.Close())
+	return keys
+}
+
+func TestScan(t *testing.T) {
+	kvtest.Scan(t, newTestStore, kvtest.ScanOptions{SetExpired: kvtest.ExpireSoon})
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Nil(t, prefixEnd(nil))
+	assert.Equal(t, []byte("b"), prefixEnd([]byte("a")))
+	assert.Equal(t, []byte("ac"), prefixEnd([]byte("ab")))
+	assert.Equal(t, []byte("b"), prefixEnd([]byte("a\xff")))
+	assert.Nil(t, prefixEnd([]byte("\xff\xff")))
+
+	o := scanRange("a", "a2", "b")
+	assert.Equal(t, []byte("a2"), o.LowerBound)
+	assert.Equal(t, []byte("b"), o.UpperBound)
+	o = scanRange("a", "", "a3")
+	assert.Equal(t, []byte("a"), o.LowerBound)
+	assert.Equal(t, []byte("a3"), o.UpperBound)
+	o = scanRange("", "", "")
+	assert.Equal(t, []byte(""), o.LowerBound)
+	assert.Nil(t, o.UpperBound)
+}
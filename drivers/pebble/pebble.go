@@ -0,0 +1,431 @@
+// Package pebble implements a key/value store powered by Pebble. It mirrors the API of the leveldb
+// driver, so moving from goleveldb only takes changing the import path.
+package pebble
+
+import (
+	"io"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"github.com/cockroachdb/pebble"
+	"golang.org/x/net/context"
+)
+
+var (
+	// Codec is the codec used to marshal/unmarshal interfaces into the byte slices stored in Pebble,
+	// for DBs created without the kv.WithCodec option.
+	// The default codec is Gob
+	Codec codec.Codec
+
+	// ensure struct implements the kv.Store interface
+	_ kv.Store         = (*DB)(nil)
+	_ kv.ContextStore  = (*DB)(nil)
+	_ kv.Expirer       = (*DB)(nil)
+	_ kv.Sweeper       = (*DB)(nil)
+	_ kv.Batcher       = (*DB)(nil)
+	_ kv.Scanner       = (*DB)(nil)
+	_ kv.Datastore     = (*DB)(nil)
+	_ kv.Clearer       = (*DB)(nil)
+	_ kv.RawStore      = (*DB)(nil)
+	_ kv.CAS           = (*DB)(nil)
+	_ kv.Transactional = (*DB)(nil)
+	_ kv.Watcher       = (*DB)(nil)
+)
+
+func init() {
+	Codec = codec.Gob
+}
+
+// DB is a light wrapper around the Pebble database. Writes are synced to disk before returning.
+type DB struct {
+	db   *pebble.DB
+	opts kv.Options
+
+	// locks serializes writes to a key so read-modify-write operations like Touch are atomic
+	locks kv.KeyLock
+	hub   kv.Hub
+}
+
+// New returns a new key/value store powered by Pebble. If options is nil the default options are
+// used. Options like kv.WithCodec configure the DB itself.
+func New(dir string, options *pebble.Options, opts ...kv.Option) (*DB, error) {
+	db, err := pebble.Open(dir, options)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db: db, opts: kv.NewOptions(opts...)}, nil
+}
+
+// Get implements the "kv.Store".Get interface
+func (db *DB) Get(key string, dstVal interface{}) error {
+	return db.GetContext(context.Background(), key, dstVal)
+}
+
+// Set implements the "kv.Store".Set interface
+func (db *DB) Set(key string, val interface{}) error {
+	return db.SetContext(context.Background(), key, val)
+}
+
+// Del implements the "kv.Store".Del interface
+func (db *DB) Del(key string) error {
+	return db.DelContext(context.Background(), key)
+}
+
+// GetContext implements the "kv.ContextStore".GetContext interface
+func (db *DB) GetContext(ctx context.Context, key string, dstVal interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, err := get(db.DB(), key)
+	if err != nil {
+		return err
+	}
+	return db.unmarshal(b, dstVal)
+}
+
+// getter is implemented by the Pebble database, its snapshots and indexed batches
+type getter interface {
+	Get(key []byte) ([]byte, io.Closer, error)
+}
+
+// get returns a copy of the value of the key. Pebble values are only valid until their closer is
+// closed, so they can't be returned as is.
+func get(g getter, key string) ([]byte, error) {
+	b, closer, err := g.Get([]byte(key))
+	if err != nil {
+		if err == pebble.ErrNotFound {
+			err = kv.ErrNotFound
+		}
+		return nil, err
+	}
+	defer closer.Close()
+	return append([]byte(nil), b...), nil
+}
+
+// marshal encodes a value to be stored with the expiration exp, which is kept in a header in
+// front of the encoded value. Values which never expire only get a header if they start like one.
+func (db *DB) marshal(value interface{}, exp time.Time) ([]byte, error) {
+	b, err := db.Codec().Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return codec.WithExpiry(b, exp), nil
+}
+
+// unmarshal decodes a stored value, returning kv.ErrCacheMiss if it has expired
+func (db *DB) unmarshal(b []byte, dstVal interface{}) error {
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return kv.ErrCacheMiss
+	}
+	return db.Codec().Unmarshal(b, dstVal)
+}
+
+// SetContext implements the "kv.ContextStore".SetContext interface
+func (db *DB) SetContext(ctx context.Context, key string, val interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b, err := db.marshal(val, time.Time{})
+	if err != nil {
+		return err
+	}
+	return db.put(key, b)
+}
+
+func (db *DB) put(key string, b []byte) error {
+	db.locks.Lock(key)
+	defer db.locks.Unlock(key)
+	return db.putLocked(key, b)
+}
+
+// putLocked puts the value and notifies watchers. The key must be locked by the caller.
+func (db *DB) putLocked(key string, b []byte) error {
+	if err := db.DB().Set([]byte(key), b, pebble.Sync); err != nil {
+		return err
+	}
+	b, _ = codec.SplitExpiry(b)
+	db.hub.Put(key, b)
+	return nil
+}
+
+// delLocked deletes the key and notifies watchers. The key must be locked by the caller.
+func (db *DB) delLocked(key string) error {
+	if err := db.DB().Delete([]byte(key), pebble.Sync); err != nil {
+		return err
+	}
+	db.hub.Delete(key)
+	return nil
+}
+
+// Watch implements the "kv.Watcher".Watch interface for changes made through this DB
+func (db *DB) Watch(prefix string) (<-chan kv.Event, func()) {
+	return db.hub.Watch(prefix)
+}
+
+// DelContext implements the "kv.ContextStore".DelContext interface
+func (db *DB) DelContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	db.locks.Lock(key)
+	defer db.locks.Unlock(key)
+	return db.delLocked(key)
+}
+
+// batch is a Pebble batch which records its changes, so watchers can be notified once it's committed
+type batch struct {
+	b       *pebble.Batch
+	changes []kv.Event
+}
+
+// set sets the value of the key in the batch
+func (b *batch) set(key string, val []byte) error {
+	if err := b.b.Set([]byte(key), val, nil); err != nil {
+		return err
+	}
+	val, _ = codec.SplitExpiry(val)
+	b.changes = append(b.changes, kv.Event{Type: kv.EventPut, Key: key, Value: val})
+	return nil
+}
+
+// del deletes the key in the batch
+func (b *batch) del(key string) error {
+	if err := b.b.Delete([]byte(key), nil); err != nil {
+		return err
+	}
+	b.changes = append(b.changes, kv.Event{Type: kv.EventDelete, Key: key})
+	return nil
+}
+
+// commit commits the batch and notifies watchers of its changes
+func (b *batch) commit(hub *kv.Hub) error {
+	if err := b.b.Commit(pebble.Sync); err != nil {
+		return err
+	}
+	for i := range b.changes {
+		hub.Publish(b.changes[i])
+	}
+	return nil
+}
+
+// SetMulti implements the "kv.Batcher".SetMulti interface, writing all items in a single batch
+func (db *DB) SetMulti(items map[string]interface{}) error {
+	keys := make([]string, 0, len(items))
+	b := &batch{b: db.DB().NewBatch()}
+	defer b.b.Close()
+	for key, val := range items {
+		enc, err := db.marshal(val, time.Time{})
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+		if err := b.set(key, enc); err != nil {
+			return err
+		}
+	}
+	return db.write(keys, b)
+}
+
+// GetMulti implements the "kv.Batcher".GetMulti interface, reading all keys from a single snapshot
+func (db *DB) GetMulti(keys []string, dst interface{}) error {
+	snap := db.DB().NewSnapshot()
+	defer snap.Close()
+	return kv.FillMulti(keys, dst, func(i int, key string, dstVal interface{}) error {
+		b, err := get(snap, key)
+		if err != nil {
+			return err
+		}
+		return db.unmarshal(b, dstVal)
+	})
+}
+
+// DelMulti implements the "kv.Batcher".DelMulti interface, deleting all keys in a single batch
+func (db *DB) DelMulti(keys []string) error {
+	b := &batch{b: db.DB().NewBatch()}
+	defer b.b.Close()
+	for _, key := range keys {
+		if err := b.del(key); err != nil {
+			return err
+		}
+	}
+	return db.write(keys, b)
+}
+
+// write commits the batch while holding the locks for all its keys
+func (db *DB) write(keys []string, b *batch) error {
+	db.locks.LockMulti(keys)
+	defer db.locks.UnlockMulti(keys)
+	return b.commit(&db.hub)
+}
+
+// SetWithTTL implements the "kv.Expirer".SetWithTTL interface. The expiration is stored
+// in a header in front of the encoded value.
+func (db *DB) SetWithTTL(key string, val interface{}, ttl time.Duration) error {
+	b, err := db.marshal(val, codec.ExpiresAt(ttl))
+	if err != nil {
+		return err
+	}
+	return db.put(key, b)
+}
+
+// TTL implements the "kv.Expirer".TTL interface
+func (db *DB) TTL(key string) (time.Duration, error) {
+	b, err := get(db.DB(), key)
+	if err != nil {
+		return 0, err
+	}
+	_, exp := codec.SplitExpiry(b)
+	switch {
+	case exp.IsZero():
+		return kv.NoExpiration, nil
+	case codec.Expired(exp):
+		return 0, kv.ErrCacheMiss
+	}
+	return exp.Sub(time.Now()), nil
+}
+
+// Touch implements the "kv.Expirer".Touch interface
+func (db *DB) Touch(key string, ttl time.Duration) error {
+	db.locks.Lock(key)
+	defer db.locks.Unlock(key)
+	b, err := get(db.DB(), key)
+	if err != nil {
+		return err
+	}
+	b, exp := codec.SplitExpiry(b)
+	if codec.Expired(exp) {
+		return kv.ErrCacheMiss
+	}
+	return db.putLocked(key, codec.WithExpiry(b, codec.ExpiresAt(ttl)))
+}
+
+// Sweep implements the "kv.Sweeper".Sweep interface, deleting all expired keys.
+// Use kv.SweepEvery() to run it periodically.
+func (db *DB) Sweep() error {
+	var expired []string
+	err := db.each(func(key, val []byte) {
+		if _, exp := codec.SplitExpiry(val); codec.Expired(exp) {
+			expired = append(expired, string(key))
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range expired {
+		if err := db.delExpired(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// each calls fn with every key/value pair of the database, including expired ones. The slices are
+// only valid until fn returns.
+func (db *DB) each(fn func(key, val []byte)) error {
+	it, err := db.DB().NewIter(nil)
+	if err != nil {
+		return err
+	}
+	for it.First(); it.Valid(); it.Next() {
+		fn(it.Key(), it.Value())
+	}
+	if err := it.Error(); err != nil {
+		it.Close()
+		return err
+	}
+	return it.Close()
+}
+
+// delExpired deletes the key if it's still expired once the key is locked
+func (db *DB) delExpired(key string) error {
+	db.locks.Lock(key)
+	defer db.locks.Unlock(key)
+	b, err := get(db.DB(), key)
+	if err != nil {
+		if err == kv.ErrNotFound {
+			err = nil
+		}
+		return err
+	}
+	if _, exp := codec.SplitExpiry(b); !codec.Expired(exp) {
+		return nil
+	}
+	return db.delLocked(key)
+}
+
+// GetRaw implements the "kv.RawStore".GetRaw interface, returning the value as stored, including
+// any expiry header
+func (db *DB) GetRaw(key string) ([]byte, error) {
+	b, err := get(db.DB(), key)
+	if err != nil {
+		return nil, err
+	}
+	if _, exp := codec.SplitExpiry(b); codec.Expired(exp) {
+		return nil, kv.ErrCacheMiss
+	}
+	return b, nil
+}
+
+// SetRaw implements the "kv.RawStore".SetRaw interface
+func (db *DB) SetRaw(key string, b []byte) error {
+	return db.put(key, b)
+}
+
+// Codec implements the "kv.RawStore".Codec interface
+func (db *DB) Codec() codec.Codec {
+	return db.opts.CodecOr(Codec)
+}
+
+// Keys implements the "kv.KeyList".Keys interface. Expired keys are left out. Since the
+// interface can't return an error, nil is returned if the database can't be read.
+func (db *DB) Keys() []string {
+	it := db.Scan("", "", "")
+	defer it.Close()
+	keys := []string{}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if it.Err() != nil {
+		return nil
+	}
+	return keys
+}
+
+// Transfer implements the "kv.Datastore".Transfer interface, copying all keys to dst.
+// Encoded values are copied as is if dst is a "kv.RawStore" sharing the same codec.
+func (db *DB) Transfer(dst kv.Store) error {
+	return kv.Transfer(db, dst)
+}
+
+// Clear implements the "kv.Clearer".Clear interface with a single range deletion, which is much
+// cheaper than deleting the keys one by one. Watchers receive a delete event for every key.
+func (db *DB) Clear() error {
+	db.locks.LockAll()
+	defer db.locks.UnlockAll()
+	var keys []string
+	err := db.each(func(key, val []byte) {
+		keys = append(keys, string(key))
+	})
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	// The end of the range is exclusive, so extend it just past the last key
+	end := append([]byte(keys[len(keys)-1]), 0)
+	if err := db.DB().DeleteRange([]byte(keys[0]), end, pebble.Sync); err != nil {
+		return err
+	}
+	db.hub.Delete(keys...)
+	return nil
+}
+
+// DB returns the underlying Pebble database
+func (db *DB) DB() *pebble.DB {
+	return db.db
+}
+
+// Close is used to close the database.
+func (db *DB) Close() error {
+	return db.DB().Close()
+}
@@ -0,0 +1,361 @@
+package pebble
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type testStruct struct {
+	Foo string
+}
+
+func tmpDir() string {
+	tmpDir, err := ioutil.TempDir("", "pebble")
+	if err != nil {
+		panic(err)
+	}
+	return tmpDir
+}
+
+// newTestDB opens a database in a temp dir which is removed once the test completes
+func newTestDB(t *testing.T) *DB {
+	dir := tmpDir()
+	db, err := New(dir, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return db
+}
+
+// newTestStore returns a new database for the kvtest conformance tests
+func newTestStore(t *testing.T) kv.Store {
+	return newTestDB(t)
+}
+
+func TestNew(t *testing.T) {
+	dir := tmpDir()
+	db, err := New(dir, nil)
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+	assert.NotNil(t, db.DB())
+	assert.Equal(t, db.db, db.DB())
+}
+
+func TestOpenErr(t *testing.T) {
+	f, err := ioutil.TempFile("", "pebble")
+	assert.NoError(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+
+	db, err := New(f.Name(), nil)
+	assert.Error(t, err)
+	assert.Nil(t, db)
+}
+
+func TestSet(t *testing.T) {
+	v := testStruct{"bar"}
+	dir := tmpDir()
+	db, err := New(dir, nil)
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+	assert.NoError(t, db.Set("foo", &v))
+}
+
+func TestSetErr(t *testing.T) {
+	v := testStruct{"bar"}
+	dir := tmpDir()
+	db, err := New(dir, nil, kv.WithCodec(codec.ErrTestCodec))
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+	assert.Error(t, db.Set("foo", v))
+}
+
+func TestCodec(t *testing.T) {
+	var v testStruct
+	dir := tmpDir()
+	db, err := New(dir, nil, kv.WithCodec(codec.JSON))
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+	assert.True(t, codec.Equal(codec.JSON, db.Codec()))
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	b, err := db.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Foo":"bar"}`, string(b))
+	assert.NoError(t, db.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+}
+
+// TestRawValues checks blobs are stored verbatim with codec.RawOr
+func TestRawValues(t *testing.T) {
+	var s string
+	var b []byte
+	var buf bytes.Buffer
+	dir := tmpDir()
+	db, err := New(dir, nil, kv.WithCodec(codec.RawOr(codec.Gob)))
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+	assert.NoError(t, db.Set("foo", []byte("\x89PNG")))
+	raw, err := db.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x89PNG"), raw)
+	assert.NoError(t, db.Get("foo", &b))
+	assert.Equal(t, []byte("\x89PNG"), b)
+	assert.NoError(t, db.Set("bar", strings.NewReader("bar")))
+	assert.NoError(t, db.Get("bar", &s))
+	assert.Equal(t, "bar", s)
+	assert.NoError(t, db.Get("bar", &buf))
+	assert.Equal(t, "bar", buf.String())
+
+	// Blobs which start like an expiry header aren't mistaken for one
+	ttl := []byte("\xffTTL\x00\x00\x00\x00\x00\x00\x00\x01foo")
+	assert.NoError(t, db.Set("baz", ttl))
+	assert.NoError(t, db.Get("baz", &b))
+	assert.Equal(t, ttl, b)
+}
+
+func TestGet(t *testing.T) {
+	v := testStruct{"bar"}
+	vv := testStruct{}
+	dir := tmpDir()
+	db, err := New(dir, nil)
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+	assert.NoError(t, db.Set("foo", &v))
+	assert.NoError(t, db.Get("foo", &vv))
+	assert.EqualValues(t, v, vv)
+}
+
+func TestDel(t *testing.T) {
+	v := testStruct{"bar"}
+	vv := testStruct{}
+	dir := tmpDir()
+	db, err := New(dir, nil)
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+	assert.NoError(t, db.Set("foo", &v))
+	assert.NoError(t, db.Del("foo"))
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &vv))
+}
+
+func TestContext(t *testing.T) {
+	v := testStruct{"bar"}
+	vv := testStruct{}
+	dir := tmpDir()
+	db, err := New(dir, nil)
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, db.SetContext(ctx, "foo", &v))
+	assert.NoError(t, db.GetContext(ctx, "foo", &vv))
+	assert.EqualValues(t, v, vv)
+
+	cancel()
+	assert.Equal(t, context.Canceled, db.SetContext(ctx, "bar", &v))
+	assert.Equal(t, context.Canceled, db.GetContext(ctx, "foo", &vv))
+	assert.Equal(t, context.Canceled, db.DelContext(ctx, "foo"))
+	assert.Equal(t, kv.ErrNotFound, db.Get("bar", &vv))
+	assert.NoError(t, db.Get("foo", &vv))
+}
+
+func TestTTL(t *testing.T) {
+	v := testStruct{"bar"}
+	vv := testStruct{}
+	dir := tmpDir()
+	db, err := New(dir, nil)
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+	assert.NoError(t, db.SetWithTTL("foo", v, time.Minute))
+	assert.NoError(t, db.Get("foo", &vv))
+	assert.EqualValues(t, v, vv)
+
+	ttl, err := db.TTL("foo")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0 && ttl <= time.Minute)
+
+	assert.NoError(t, db.Touch("foo", 0))
+	ttl, err = db.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+
+	assert.NoError(t, db.Touch("foo", time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, kv.ErrCacheMiss, db.Get("foo", &vv))
+	assert.Equal(t, kv.ErrCacheMiss, db.Touch("foo", time.Minute))
+	_, err = db.TTL("foo")
+	assert.Equal(t, kv.ErrCacheMiss, err)
+
+	_, err = db.TTL("bar")
+	assert.Equal(t, kv.ErrNotFound, err)
+	assert.Equal(t, kv.ErrNotFound, db.Touch("bar", time.Minute))
+}
+
+func TestSweep(t *testing.T) {
+	v := testStruct{"bar"}
+	vv := testStruct{}
+	dir := tmpDir()
+	db, err := New(dir, nil)
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+	assert.NoError(t, db.SetWithTTL("foo", v, time.Millisecond))
+	assert.NoError(t, db.SetWithTTL("bar", v, time.Minute))
+	assert.NoError(t, db.Set("baz", v))
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, db.Sweep())
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &vv))
+	assert.NoError(t, db.Get("bar", &vv))
+	assert.NoError(t, db.Get("baz", &vv))
+}
+
+func TestMulti(t *testing.T) {
+	dir := tmpDir()
+	db, err := New(dir, nil)
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+	assert.NoError(t, db.SetMulti(map[string]interface{}{
+		"foo": testStruct{"foo"},
+		"bar": testStruct{"bar"},
+	}))
+
+	m := map[string]testStruct{}
+	assert.NoError(t, db.GetMulti([]string{"foo", "bar", "baz"}, m))
+	assert.Equal(t, map[string]testStruct{"foo": {"foo"}, "bar": {"bar"}}, m)
+
+	var sl []*testStruct
+	assert.NoError(t, db.GetMulti([]string{"foo", "baz", "bar"}, &sl))
+	assert.Equal(t, []*testStruct{{"foo"}, nil, {"bar"}}, sl)
+
+	assert.NoError(t, db.DelMulti([]string{"foo", "bar", "baz"}))
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &testStruct{}))
+	assert.Equal(t, kv.ErrNotFound, db.Get("bar", &testStruct{}))
+}
+
+func TestKeysTransferClear(t *testing.T) {
+	vv := testStruct{}
+	dir, dir2 := tmpDir(), tmpDir()
+	db, err := New(dir, nil)
+	assert.NoError(t, err)
+	db2, err := New(dir2, nil)
+	assert.NoError(t, err)
+	defer func() {
+		db.Close()
+		db2.Close()
+		os.RemoveAll(dir)
+		os.RemoveAll(dir2)
+	}()
+
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, db.SetWithTTL("bar", testStruct{"bar"}, time.Minute))
+	assert.NoError(t, db.SetWithTTL("baz", testStruct{"bar"}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	keys := db.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+
+	assert.NoError(t, db.Transfer(db2))
+	keys = db2.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+	assert.NoError(t, db2.Get("foo", &vv))
+	assert.EqualValues(t, testStruct{"bar"}, vv)
+	ttl, err := db2.TTL("bar")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+
+	events, cancel := db.Watch("")
+	defer cancel()
+	assert.NoError(t, db.Clear())
+	assert.Len(t, db.Keys(), 0)
+	deleted := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		e := <-events
+		assert.Equal(t, kv.EventDelete, e.Type)
+		deleted[e.Key] = true
+	}
+	assert.Equal(t, map[string]bool{"foo": true, "bar": true, "baz": true}, deleted)
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &vv))
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	assert.Len(t, db.Keys(), 1)
+}
+
+func TestWatch(t *testing.T) {
+	var vv testStruct
+	dir := tmpDir()
+	db, err := New(dir, nil)
+	assert.NoError(t, err)
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+
+	events, cancel := db.Watch("foo")
+	assert.NoError(t, db.Set("bar", testStruct{"bar"}))
+	assert.NoError(t, db.SetWithTTL("foo", testStruct{"foo"}, time.Minute))
+	assert.NoError(t, db.Touch("foo", time.Hour))
+	assert.NoError(t, db.DelMulti([]string{"foo", "bar"}))
+
+	e := <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+
+	// Touch rewrites the key, so watchers see the unchanged value again
+	vv = testStruct{}
+	e = <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "foo"}, <-events)
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+}
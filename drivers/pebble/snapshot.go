@@ -0,0 +1,41 @@
+package pebble
+
+import (
+	"github.com/bradberger/gokv/kv"
+	"github.com/cockroachdb/pebble"
+)
+
+// ensure struct implements the kv.Scanner interface
+var _ kv.Scanner = (*Snapshot)(nil)
+
+// Snapshot is a consistent, read only view of the database at the time it was taken. It must be
+// closed when done, since it keeps Pebble from discarding the data it can see.
+type Snapshot struct {
+	db   *DB
+	snap *pebble.Snapshot
+}
+
+// Snapshot returns a snapshot of the current state of the database
+func (db *DB) Snapshot() *Snapshot {
+	return &Snapshot{db, db.DB().NewSnapshot()}
+}
+
+// Get gets the value of the key as it was when the snapshot was taken
+func (s *Snapshot) Get(key string, dstVal interface{}) error {
+	b, err := get(s.snap, key)
+	if err != nil {
+		return err
+	}
+	return s.db.unmarshal(b, dstVal)
+}
+
+// Scan implements the "kv.Scanner".Scan interface, iterating over the keys as they were when the
+// snapshot was taken. Keys which expired since are skipped.
+func (s *Snapshot) Scan(prefix, start, end string) kv.Iterator {
+	return s.db.scan(s.snap, prefix, start, end)
+}
+
+// Close releases the snapshot
+func (s *Snapshot) Close() error {
+	return s.snap.Close()
+}
@@ -0,0 +1,41 @@
+package pebble
+
+import (
+	"os"
+	"testing"
+
+	"github.com/bradberger/gokv/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	var v testStruct
+	dir := tmpDir()
+	db, err := New(dir, nil)
+	defer func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}()
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Set("a1", testStruct{"a1"}))
+	assert.NoError(t, db.Set("a2", testStruct{"a2"}))
+	snap := db.Snapshot()
+	defer snap.Close()
+
+	// Changes made after the snapshot was taken aren't visible in it
+	assert.NoError(t, db.Set("a1", testStruct{"changed"}))
+	assert.NoError(t, db.Del("a2"))
+	assert.NoError(t, db.Set("a3", testStruct{"a3"}))
+
+	assert.NoError(t, snap.Get("a1", &v))
+	assert.Equal(t, testStruct{"a1"}, v)
+	assert.NoError(t, snap.Get("a2", &v))
+	assert.Equal(t, testStruct{"a2"}, v)
+	assert.Equal(t, kv.ErrNotFound, snap.Get("a3", &v))
+	assert.Equal(t, []string{"a1", "a2"}, scanKeys(t, snap.Scan("a", "", "")))
+
+	assert.NoError(t, db.Get("a1", &v))
+	assert.Equal(t, testStruct{"changed"}, v)
+	assert.Equal(t, []string{"a1", "a3"}, db.Keys())
+}
@@ -0,0 +1,64 @@
+package pebble
+
+import (
+	"time"
+
+	"github.com/bradberger/gokv/kv"
+)
+
+// tx is a "kv.Tx" reading from an indexed batch, or a snapshot for read only transactions
+type tx struct {
+	db *DB
+	r  getter
+	b  *batch
+}
+
+// Update implements the "kv.Transactional".Update interface using an indexed batch, which reads
+// its own writes and is committed atomically. All keys are locked for the duration, so fn must use
+// tx rather than db or it will deadlock.
+func (db *DB) Update(fn func(tx kv.Tx) error) error {
+	db.locks.LockAll()
+	defer db.locks.UnlockAll()
+	b := &batch{b: db.DB().NewIndexedBatch()}
+	defer b.b.Close()
+	if err := fn(&tx{db: db, r: b.b, b: b}); err != nil {
+		return err
+	}
+	return b.commit(&db.hub)
+}
+
+// View implements the "kv.Transactional".View interface using a Pebble snapshot
+func (db *DB) View(fn func(tx kv.Tx) error) error {
+	snap := db.DB().NewSnapshot()
+	defer snap.Close()
+	return fn(&tx{db: db, r: snap})
+}
+
+// Set implements the "kv.Tx".Set interface
+func (t *tx) Set(key string, val interface{}) error {
+	if t.b == nil {
+		return kv.ErrReadOnly
+	}
+	b, err := t.db.marshal(val, time.Time{})
+	if err != nil {
+		return err
+	}
+	return t.b.set(key, b)
+}
+
+// Get implements the "kv.Tx".Get interface
+func (t *tx) Get(key string, dstVal interface{}) error {
+	b, err := get(t.r, key)
+	if err != nil {
+		return err
+	}
+	return t.db.unmarshal(b, dstVal)
+}
+
+// Del implements the "kv.Tx".Del interface
+func (t *tx) Del(key string) error {
+	if t.b == nil {
+		return kv.ErrReadOnly
+	}
+	return t.b.del(key)
+}
@@ -0,0 +1,112 @@
+package redis
+
+import (
+	"github.com/bradberger/gokv/kv"
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+// iterator is a "kv.Iterator" over the keys returned by SCAN. The values of each batch of keys
+// are loaded with a single MGET.
+type iterator struct {
+	r                  *Redis
+	prefix, start, end string
+	cursor             int
+	done, closed       bool
+
+	keys []string
+	vals [][]byte
+	seen map[string]bool
+
+	key string
+	val []byte
+	err error
+}
+
+// Scan implements the "kv.Scanner".Scan() interface using SCAN, so keys are not returned in any
+// particular order.
+func (r *Redis) Scan(prefix, start, end string) kv.Iterator {
+	return &iterator{r: r, prefix: prefix, start: start, end: end, seen: make(map[string]bool)}
+}
+
+// Next implements the "kv.Iterator".Next() interface, skipping keys outside of the range. Keys
+// deleted or expired since the scan started are skipped as well.
+func (it *iterator) Next() bool {
+	if it.err != nil || it.closed {
+		return false
+	}
+	for {
+		for len(it.keys) > 0 {
+			key, val := it.keys[0], it.vals[0]
+			it.keys, it.vals = it.keys[1:], it.vals[1:]
+			if val != nil {
+				it.key, it.val = key, val
+				return true
+			}
+		}
+		if it.done {
+			it.key, it.val = "", nil
+			return false
+		}
+		if it.err = it.fetch(); it.err != nil {
+			return false
+		}
+	}
+}
+
+// fetch loads the next batch of keys and their values
+func (it *iterator) fetch() error {
+	conn := it.r.pool.Get()
+	defer conn.Close()
+	reply, err := redigo.Values(conn.Do("SCAN", it.cursor, "MATCH", pattern(it.prefix), "COUNT", scanCount))
+	if err != nil {
+		return err
+	}
+	if it.cursor, err = redigo.Int(reply[0], nil); err != nil {
+		return err
+	}
+	it.done = it.cursor == 0
+	keys, err := redigo.Strings(reply[1], nil)
+	if err != nil {
+		return err
+	}
+
+	// SCAN may return a key more than once
+	it.keys = it.keys[:0]
+	for _, key := range keys {
+		if kv.InRange(key, it.prefix, it.start, it.end) && !it.seen[key] {
+			it.seen[key] = true
+			it.keys = append(it.keys, key)
+		}
+	}
+	if len(it.keys) == 0 {
+		it.vals = nil
+		return nil
+	}
+	it.vals, err = redigo.ByteSlices(conn.Do("MGET", redigo.Args{}.AddFlat(it.keys)...))
+	return err
+}
+
+// Key implements the "kv.Iterator".Key() interface
+func (it *iterator) Key() string {
+	return it.key
+}
+
+// Value implements the "kv.Iterator".Value() interface
+func (it *iterator) Value(dstVal interface{}) error {
+	if it.val == nil {
+		return kv.ErrNotFound
+	}
+	return it.r.Codec().Unmarshal(it.val, dstVal)
+}
+
+// Err implements the "kv.Iterator".Err() interface
+func (it *iterator) Err() error {
+	return it.err
+}
+
+// Close implements the "kv.Iterator".Close() interface
+func (it *iterator) Close() error {
+	it.closed = true
+	it.keys, it.vals, it.seen = nil, nil, nil
+	return nil
+}
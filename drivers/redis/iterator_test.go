@@ -0,0 +1,56 @@
+package redis
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/bradberger/gokv/kv"
+	"github.com/bradberger/gokv/kv/kvtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// scanKeys collects the keys of an iterator in sorted order
+func scanKeys(t *testing.T, it kv.Iterator) []string {
+	keys := []string{}
+	for it.Next() {
+		var v testStruct
+		assert.NoError(t, it.Value(&v))
+		assert.Equal(t, it.Key(), v.Foo)
+		keys = append(keys, it.Key())
+	}
+	assert.NoError(t, it.Err())
+	assert.NoError(t, it.Close())
+	sort.Strings(keys)
+	return keys
+}
+
+func TestScan(t *testing.T) {
+	kvtest.Scan(t, newTestStore, kvtest.ScanOptions{Unordered: true})
+}
+
+func TestScanPattern(t *testing.T) {
+	// Prefixes are matched literally even though SCAN takes a glob pattern
+	r, _ := newTestRedis(t)
+	for _, key := range []string{"a1", "a*"} {
+		assert.NoError(t, r.Set(key, testStruct{key}))
+	}
+	assert.Equal(t, []string{"a*", "a1"}, scanKeys(t, r.Scan("a", "", "")))
+	assert.Equal(t, []string{"a*"}, scanKeys(t, r.Scan("a*", "", "")))
+
+	it := r.Scan("", "", "")
+	assert.NoError(t, it.Close())
+	assert.False(t, it.Next())
+	assert.Equal(t, kv.ErrNotFound, it.Value(&testStruct{}))
+}
+
+func TestScanBatches(t *testing.T) {
+	r, _ := newTestRedis(t)
+	want := make([]string, 0, 3*scanCount)
+	for i := 0; i < 3*scanCount; i++ {
+		key := fmt.Sprintf("key-%03d", i)
+		assert.NoError(t, r.Set(key, testStruct{key}))
+		want = append(want, key)
+	}
+	assert.Equal(t, want, scanKeys(t, r.Scan("key-", "", "")))
+}
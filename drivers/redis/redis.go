@@ -0,0 +1,385 @@
+// Package redis implements a key/value store backed by a Redis server
+package redis
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	redigo "github.com/gomodule/redigo/redis"
+	"golang.org/x/net/context"
+)
+
+// scanCount is the number of keys requested per SCAN call
+const scanCount = 100
+
+var (
+	// Codec is the codec used to marshal/unmarshal interfaces into the byte slices stored in Redis,
+	// for stores created without the kv.WithCodec option. The default codec is Gob
+	Codec codec.Codec
+
+	// ensure struct implements the kv.Store interface
+	_ kv.Store        = (*Redis)(nil)
+	_ kv.ContextStore = (*Redis)(nil)
+	_ kv.Expirer      = (*Redis)(nil)
+	_ kv.Batcher      = (*Redis)(nil)
+	_ kv.Scanner      = (*Redis)(nil)
+	_ kv.Datastore    = (*Redis)(nil)
+	_ kv.Clearer      = (*Redis)(nil)
+	_ kv.RawStore     = (*Redis)(nil)
+	_ kv.Watcher      = (*Redis)(nil)
+)
+
+func init() {
+	Codec = codec.Gob
+}
+
+// Redis is a Redis backed key/value store. Expiration is handled by Redis itself.
+type Redis struct {
+	pool *redigo.Pool
+	opts kv.Options
+	hub  kv.Hub
+}
+
+// New returns a new key/value store connecting to the Redis server at addr with the dial options,
+// which may be nil. The connection is checked before returning. Options like kv.WithCodec
+// configure the store itself.
+func New(addr string, options []redigo.DialOption, opts ...kv.Option) (*Redis, error) {
+	r := NewPool(&redigo.Pool{
+		Dial: func() (redigo.Conn, error) {
+			return redigo.Dial("tcp", addr, options...)
+		},
+		MaxIdle:     8,
+		IdleTimeout: 4 * time.Minute,
+	}, opts...)
+	if _, err := r.do(context.Background(), "PING"); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewPool returns a new key/value store using connections from the given pool. Options like
+// kv.WithCodec configure the store itself.
+func NewPool(pool *redigo.Pool, opts ...kv.Option) *Redis {
+	return &Redis{pool: pool, opts: kv.NewOptions(opts...)}
+}
+
+// do runs a single command on a connection from the pool
+func (r *Redis) do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return redigo.DoContext(conn, ctx, cmd, args...)
+}
+
+// Set implements the "kv.Store".Set() interface
+func (r *Redis) Set(key string, value interface{}) error {
+	return r.SetContext(context.Background(), key, value)
+}
+
+// Get implements the "kv.Store".Get() interface
+func (r *Redis) Get(key string, dstVal interface{}) error {
+	return r.GetContext(context.Background(), key, dstVal)
+}
+
+// Del implements the "kv.Store".Del() interface
+func (r *Redis) Del(key string) error {
+	return r.DelContext(context.Background(), key)
+}
+
+// SetContext implements the "kv.ContextStore".SetContext() interface
+func (r *Redis) SetContext(ctx context.Context, key string, value interface{}) error {
+	b, err := r.Codec().Marshal(value)
+	if err != nil {
+		return err
+	}
+	return r.put(ctx, key, b, 0)
+}
+
+// put stores the encoded value, expiring it after ttl if it's positive, and notifies watchers
+func (r *Redis) put(ctx context.Context, key string, b []byte, ttl time.Duration) error {
+	args := []interface{}{key, b}
+	if ttl > 0 {
+		args = append(args, "PX", milliseconds(ttl))
+	}
+	if _, err := r.do(ctx, "SET", args...); err != nil {
+		return err
+	}
+	r.hub.Put(key, b)
+	return nil
+}
+
+// milliseconds returns the duration in milliseconds, rounded up so short TTLs don't become zero
+func milliseconds(d time.Duration) int64 {
+	return int64((d + time.Millisecond - 1) / time.Millisecond)
+}
+
+// GetContext implements the "kv.ContextStore".GetContext() interface
+func (r *Redis) GetContext(ctx context.Context, key string, dstVal interface{}) error {
+	b, err := redigo.Bytes(r.do(ctx, "GET", key))
+	if err != nil {
+		return notFound(err)
+	}
+	return r.Codec().Unmarshal(b, dstVal)
+}
+
+// notFound converts the nil reply of missing keys to kv.ErrNotFound
+func notFound(err error) error {
+	if err == redigo.ErrNil {
+		return kv.ErrNotFound
+	}
+	return err
+}
+
+// DelContext implements the "kv.ContextStore".DelContext() interface
+func (r *Redis) DelContext(ctx context.Context, key string) error {
+	n, err := redigo.Int(r.do(ctx, "DEL", key))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return kv.ErrNotFound
+	}
+	r.hub.Delete(key)
+	return nil
+}
+
+// SetMulti implements the "kv.Batcher".SetMulti() interface, setting all items atomically with MSET
+func (r *Redis) SetMulti(items map[string]interface{}) error {
+	if len(items) == 0 {
+		return nil
+	}
+	encoded := make(map[string][]byte, len(items))
+	args := make([]interface{}, 0, 2*len(items))
+	for key, value := range items {
+		b, err := r.Codec().Marshal(value)
+		if err != nil {
+			return err
+		}
+		encoded[key] = b
+		args = append(args, key, b)
+	}
+	if _, err := r.do(context.Background(), "MSET", args...); err != nil {
+		return err
+	}
+	for key, b := range encoded {
+		r.hub.Put(key, b)
+	}
+	return nil
+}
+
+// GetMulti implements the "kv.Batcher".GetMulti() interface, reading all keys with a single MGET
+func (r *Redis) GetMulti(keys []string, dst interface{}) error {
+	if len(keys) == 0 {
+		return kv.FillMulti(keys, dst, nil)
+	}
+	vals, err := redigo.ByteSlices(r.do(context.Background(), "MGET", redigo.Args{}.AddFlat(keys)...))
+	if err != nil {
+		return err
+	}
+	return kv.FillMulti(keys, dst, func(i int, key string, dstVal interface{}) error {
+		if vals[i] == nil {
+			return kv.ErrNotFound
+		}
+		return r.Codec().Unmarshal(vals[i], dstVal)
+	})
+}
+
+// DelMulti implements the "kv.Batcher".DelMulti() interface, deleting all keys with a single DEL
+func (r *Redis) DelMulti(keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if _, err := r.do(context.Background(), "DEL", redigo.Args{}.AddFlat(keys)...); err != nil {
+		return err
+	}
+	r.hub.Delete(keys...)
+	return nil
+}
+
+// SetWithTTL implements the "kv.Expirer".SetWithTTL() interface using SET with the PX option
+func (r *Redis) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	b, err := r.Codec().Marshal(value)
+	if err != nil {
+		return err
+	}
+	return r.put(context.Background(), key, b, ttl)
+}
+
+// TTL implements the "kv.Expirer".TTL() interface
+func (r *Redis) TTL(key string) (time.Duration, error) {
+	ms, err := redigo.Int64(r.do(context.Background(), "PTTL", key))
+	if err != nil {
+		return 0, err
+	}
+	return pttl(ms)
+}
+
+// pttl converts the reply of PTTL, which is -2 for missing keys and -1 for keys without expiration
+func pttl(ms int64) (time.Duration, error) {
+	switch ms {
+	case -2:
+		return 0, kv.ErrNotFound
+	case -1:
+		return kv.NoExpiration, nil
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// Touch implements the "kv.Expirer".Touch() interface. A ttl <= 0 removes the expiration.
+// The value is read in the same transaction so watchers are notified with it.
+func (r *Redis) Touch(key string, ttl time.Duration) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+	conn.Send("MULTI")
+	conn.Send("GET", key)
+	if ttl > 0 {
+		conn.Send("PEXPIRE", key, milliseconds(ttl))
+	} else {
+		conn.Send("PERSIST", key)
+	}
+	reply, err := redigo.Values(conn.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+	// PERSIST replies 0 for keys without expiration too, so the key exists if it has a value
+	b, err := redigo.Bytes(reply[0], nil)
+	if err != nil {
+		return notFound(err)
+	}
+	r.hub.Put(key, b)
+	return nil
+}
+
+// GetRaw implements the "kv.RawStore".GetRaw() interface. The key's expiration is added as an
+// expiry header, so it's kept when the value is copied to another store.
+func (r *Redis) GetRaw(key string) ([]byte, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	conn.Send("MULTI")
+	conn.Send("GET", key)
+	conn.Send("PTTL", key)
+	reply, err := redigo.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+	b, err := redigo.Bytes(reply[0], nil)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	ms, err := redigo.Int64(reply[1], nil)
+	if err != nil {
+		return nil, err
+	}
+	var exp time.Time
+	if ms > 0 {
+		exp = time.Now().Add(time.Duration(ms) * time.Millisecond)
+	}
+	return codec.WithExpiry(b, exp), nil
+}
+
+// SetRaw implements the "kv.RawStore".SetRaw() interface. An expiry header is converted to
+// a Redis expiration.
+func (r *Redis) SetRaw(key string, b []byte) error {
+	b, exp := codec.SplitExpiry(b)
+	if exp.IsZero() {
+		return r.put(context.Background(), key, b, 0)
+	}
+	ttl := exp.Sub(time.Now())
+	if ttl <= 0 {
+		return nil
+	}
+	return r.put(context.Background(), key, b, ttl)
+}
+
+// Codec implements the "kv.RawStore".Codec() interface
+func (r *Redis) Codec() codec.Codec {
+	return r.opts.CodecOr(Codec)
+}
+
+// Keys implements the "kv.KeyList".Keys() interface. Since the interface can't return an error,
+// nil is returned if the keys can't be scanned.
+func (r *Redis) Keys() []string {
+	keys := []string{}
+	err := r.scanKeys("", func(batch []string) error {
+		keys = append(keys, batch...)
+		return nil
+	})
+	if err != nil {
+		return nil
+	}
+	return keys
+}
+
+// scanKeys calls fn with each batch of keys with the given prefix returned by SCAN. Redis
+// guarantees keys existing during the whole scan are returned, but may return them twice.
+func (r *Redis) scanKeys(prefix string, fn func(keys []string) error) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+	cursor := 0
+	for {
+		reply, err := redigo.Values(conn.Do("SCAN", cursor, "MATCH", pattern(prefix), "COUNT", scanCount))
+		if err != nil {
+			return err
+		}
+		if cursor, err = redigo.Int(reply[0], nil); err != nil {
+			return err
+		}
+		keys, err := redigo.Strings(reply[1], nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(keys); err != nil {
+			return err
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// globReplacer escapes the characters which have a special meaning in SCAN MATCH patterns
+var globReplacer = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// pattern returns the SCAN MATCH pattern for keys with the prefix
+func pattern(prefix string) string {
+	return globReplacer.Replace(prefix) + "*"
+}
+
+// Transfer implements the "kv.Datastore".Transfer() interface, copying all keys to dst.
+// Encoded values are copied as is if dst is a "kv.RawStore" sharing the same codec.
+func (r *Redis) Transfer(dst kv.Store) error {
+	return kv.Transfer(r, dst)
+}
+
+// Clear implements the "kv.Clearer".Clear() interface, deleting all keys of the selected Redis
+// database. Keys are deleted in batches rather than with FLUSHDB, so watchers receive a delete
+// event for every key.
+func (r *Redis) Clear() error {
+	return r.scanKeys("", func(keys []string) error {
+		if len(keys) == 0 {
+			return nil
+		}
+		return r.DelMulti(keys)
+	})
+}
+
+// Watch implements the "kv.Watcher".Watch() interface for changes made through this store.
+// Changes made by other Redis clients aren't reported.
+func (r *Redis) Watch(prefix string) (<-chan kv.Event, func()) {
+	return r.hub.Watch(prefix)
+}
+
+// Pool returns the underlying connection pool
+func (r *Redis) Pool() *redigo.Pool {
+	return r.pool
+}
+
+// Close closes the connection pool
+func (r *Redis) Close() error {
+	return r.pool.Close()
+}
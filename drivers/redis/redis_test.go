@@ -0,0 +1,247 @@
+package redis
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type testStruct struct {
+	Foo string
+}
+
+// newTestRedis returns a store connected to an in-process Redis server
+func newTestRedis(t *testing.T, opts ...kv.Option) (*Redis, *miniredis.Miniredis) {
+	m := miniredis.RunT(t)
+	r, err := New(m.Addr(), nil, opts...)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		r.Close()
+	})
+	return r, m
+}
+
+// newTestStore returns a new client for the kvtest conformance tests
+func newTestStore(t *testing.T) kv.Store {
+	r, _ := newTestRedis(t)
+	return r
+}
+
+func TestNew(t *testing.T) {
+	r, _ := newTestRedis(t)
+	assert.NotNil(t, r.Pool())
+}
+
+func TestDialErr(t *testing.T) {
+	m := miniredis.NewMiniRedis()
+	assert.NoError(t, m.Start())
+	addr := m.Addr()
+	m.Close()
+	r, err := New(addr, nil)
+	assert.Error(t, err)
+	assert.Nil(t, r)
+}
+
+func TestGetSetDel(t *testing.T) {
+	var v testStruct
+	r, m := newTestRedis(t)
+	assert.Equal(t, kv.ErrNotFound, r.Get("foo", &v))
+	assert.NoError(t, r.Set("foo", testStruct{"bar"}))
+	assert.True(t, m.Exists("foo"))
+	assert.NoError(t, r.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+	assert.NoError(t, r.Del("foo"))
+	assert.False(t, m.Exists("foo"))
+	assert.Equal(t, kv.ErrNotFound, r.Del("foo"))
+}
+
+func TestSetErr(t *testing.T) {
+	r, m := newTestRedis(t)
+	r = NewPool(r.Pool(), kv.WithCodec(codec.ErrTestCodec))
+	assert.Error(t, r.Set("foo", testStruct{"bar"}))
+	assert.False(t, m.Exists("foo"))
+}
+
+// TestRawValues checks blobs are stored verbatim with codec.RawOr
+func TestRawValues(t *testing.T) {
+	var s string
+	var b []byte
+	var buf bytes.Buffer
+	r, _ := newTestRedis(t, kv.WithCodec(codec.RawOr(codec.Gob)))
+	assert.NoError(t, r.Set("foo", []byte("\x89PNG")))
+	raw, err := r.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x89PNG"), raw)
+	assert.NoError(t, r.Get("foo", &b))
+	assert.Equal(t, []byte("\x89PNG"), b)
+	assert.NoError(t, r.Set("bar", strings.NewReader("bar")))
+	assert.NoError(t, r.Get("bar", &s))
+	assert.Equal(t, "bar", s)
+	assert.NoError(t, r.Get("bar", &buf))
+	assert.Equal(t, "bar", buf.String())
+
+	// Blobs which start like an expiry header aren't mistaken for one
+	ttl := []byte("\xffTTL\x00\x00\x00\x00\x00\x00\x00\x01foo")
+	assert.NoError(t, r.Set("baz", ttl))
+	assert.NoError(t, r.Get("baz", &b))
+	assert.Equal(t, ttl, b)
+}
+
+func TestCodec(t *testing.T) {
+	var v testStruct
+	r, m := newTestRedis(t)
+	j := NewPool(r.Pool(), kv.WithCodec(codec.JSON))
+	assert.True(t, codec.Equal(codec.JSON, j.Codec()))
+	assert.True(t, codec.Equal(Codec, r.Codec()))
+	assert.NoError(t, j.Set("foo", testStruct{"bar"}))
+	b, err := m.Get("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Foo":"bar"}`, b)
+	assert.NoError(t, j.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+
+	// New takes the same options
+	j, _ = newTestRedis(t, kv.WithCodec(codec.JSON))
+	assert.True(t, codec.Equal(codec.JSON, j.Codec()))
+}
+
+func TestContext(t *testing.T) {
+	var s string
+	r, m := newTestRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, r.SetContext(ctx, "foo", "bar"))
+	assert.NoError(t, r.GetContext(ctx, "foo", &s))
+	assert.Equal(t, "bar", s)
+
+	cancel()
+	assert.Equal(t, context.Canceled, r.SetContext(ctx, "bar", "baz"))
+	assert.Equal(t, context.Canceled, r.GetContext(ctx, "foo", &s))
+	assert.Equal(t, context.Canceled, r.DelContext(ctx, "foo"))
+	assert.True(t, m.Exists("foo"))
+	assert.False(t, m.Exists("bar"))
+}
+
+func TestTTL(t *testing.T) {
+	var v testStruct
+	r, m := newTestRedis(t)
+	assert.NoError(t, r.Set("foo", testStruct{"foo"}))
+	ttl, err := r.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+
+	assert.NoError(t, r.SetWithTTL("bar", testStruct{"bar"}, 1500*time.Microsecond))
+	ttl, err = r.TTL("bar")
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Millisecond, ttl)
+	m.FastForward(5 * time.Millisecond)
+	_, err = r.TTL("bar")
+	assert.Equal(t, kv.ErrNotFound, err)
+	assert.Equal(t, kv.ErrNotFound, r.Get("bar", &v))
+	assert.Equal(t, kv.ErrNotFound, r.Touch("bar", time.Minute))
+
+	assert.NoError(t, r.Touch("foo", time.Minute))
+	ttl, err = r.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl)
+	assert.NoError(t, r.Touch("foo", 0))
+	ttl, err = r.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+	assert.Equal(t, kv.ErrNotFound, r.Touch("baz", 0))
+}
+
+func TestMulti(t *testing.T) {
+	r, _ := newTestRedis(t)
+	assert.NoError(t, r.SetMulti(map[string]interface{}{
+		"foo": testStruct{"foo"},
+		"bar": testStruct{"bar"},
+	}))
+
+	m := map[string]testStruct{}
+	assert.NoError(t, r.GetMulti([]string{"foo", "bar", "baz"}, m))
+	assert.Equal(t, map[string]testStruct{"foo": {"foo"}, "bar": {"bar"}}, m)
+
+	var sl []*testStruct
+	assert.NoError(t, r.GetMulti([]string{"foo", "baz", "bar"}, &sl))
+	assert.Equal(t, []*testStruct{{"foo"}, nil, {"bar"}}, sl)
+
+	assert.NoError(t, r.DelMulti([]string{"foo", "bar", "baz"}))
+	assert.Equal(t, kv.ErrNotFound, r.Get("foo", &testStruct{}))
+	assert.Equal(t, kv.ErrNotFound, r.Get("bar", &testStruct{}))
+
+	assert.NoError(t, r.SetMulti(nil))
+	assert.NoError(t, r.GetMulti(nil, m))
+	assert.NoError(t, r.DelMulti(nil))
+}
+
+func TestKeysTransferClear(t *testing.T) {
+	vv := testStruct{}
+	r, m := newTestRedis(t)
+	r2, _ := newTestRedis(t)
+
+	assert.NoError(t, r.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, r.SetWithTTL("bar", testStruct{"bar"}, time.Minute))
+	assert.NoError(t, r.SetWithTTL("baz", testStruct{"bar"}, time.Millisecond))
+	m.FastForward(5 * time.Millisecond)
+	keys := r.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+
+	assert.NoError(t, r.Transfer(r2))
+	keys = r2.Keys()
+	sort.Strings(keys)
+	assert.Equal(t, []string{"bar", "foo"}, keys)
+	assert.NoError(t, r2.Get("foo", &vv))
+	assert.EqualValues(t, testStruct{"bar"}, vv)
+	ttl, err := r2.TTL("bar")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+	ttl, err = r2.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+
+	assert.NoError(t, r.Clear())
+	assert.Len(t, r.Keys(), 0)
+	assert.Equal(t, kv.ErrNotFound, r.Get("foo", &vv))
+	assert.NoError(t, r.Set("foo", testStruct{"bar"}))
+	assert.Len(t, r.Keys(), 1)
+}
+
+func TestWatch(t *testing.T) {
+	var vv testStruct
+	r, _ := newTestRedis(t)
+
+	events, cancel := r.Watch("foo")
+	assert.NoError(t, r.Set("bar", testStruct{"bar"}))
+	assert.NoError(t, r.SetWithTTL("foo", testStruct{"foo"}, time.Minute))
+	assert.NoError(t, r.Touch("foo", time.Hour))
+	assert.NoError(t, r.Clear())
+
+	e := <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+
+	// Touch rewrites the key, so watchers see the unchanged value again
+	vv = testStruct{}
+	e = <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "foo"}, <-events)
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+}
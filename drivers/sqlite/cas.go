@@ -0,0 +1,86 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/bradberger/gokv/kv"
+	"golang.org/x/net/context"
+)
+
+// GetWithVersion implements the "kv.CAS".GetWithVersion() interface. The version is read from the
+// version column.
+func (d *DB) GetWithVersion(key string, dstVal interface{}) (uint64, error) {
+	r, err := d.current(context.Background(), d.DB(), key)
+	if err != nil {
+		return 0, err
+	}
+	return r.version, d.Codec().Unmarshal(r.value, dstVal)
+}
+
+// SetIfVersion implements the "kv.CAS".SetIfVersion() interface. The version is checked and the
+// value written within the same transaction.
+func (d *DB) SetIfVersion(key string, value interface{}, version uint64) (uint64, error) {
+	return d.setIf(key, value, func(cur *row) bool {
+		return cur != nil && cur.version == version
+	})
+}
+
+// SetIfNotExists implements the "kv.CAS".SetIfNotExists() interface
+func (d *DB) SetIfNotExists(key string, value interface{}) (uint64, error) {
+	return d.setIf(key, value, func(cur *row) bool {
+		return cur == nil
+	})
+}
+
+// live reads the row of the key within the transaction, returning nil if the key doesn't exist
+// or has expired
+func (d *DB) live(ctx context.Context, tx *sql.Tx, key string) (*row, error) {
+	r, err := d.current(ctx, tx, key)
+	switch err {
+	case nil:
+		return r, nil
+	case kv.ErrNotFound, kv.ErrCacheMiss:
+		return nil, nil
+	}
+	return nil, err
+}
+
+// setIf sets the value if ok returns true for the current row of the key
+func (d *DB) setIf(key string, value interface{}, ok func(cur *row) bool) (version uint64, err error) {
+	b, err := d.Codec().Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	ctx := context.Background()
+	err = d.update(ctx, func(tx *sql.Tx, c *changes) error {
+		cur, err := d.live(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if !ok(cur) {
+			return kv.ErrVersionMismatch
+		}
+		version, err = d.put(ctx, tx, c, key, b, time.Time{})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// DelIfVersion implements the "kv.CAS".DelIfVersion() interface
+func (d *DB) DelIfVersion(key string, version uint64) error {
+	ctx := context.Background()
+	return d.update(ctx, func(tx *sql.Tx, c *changes) error {
+		cur, err := d.live(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if cur == nil || cur.version != version {
+			return kv.ErrVersionMismatch
+		}
+		return d.del(ctx, tx, c, key)
+	})
+}
@@ -0,0 +1,34 @@
+package sqlite
+
+import (
+	"testing"
+
+	"github.com/bradberger/gokv/kv"
+	"github.com/bradberger/gokv/kv/kvtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCAS(t *testing.T) {
+	kvtest.CAS(t, newTestStore)
+}
+
+func TestCASVersionColumn(t *testing.T) {
+	var v testStruct
+	db := newTestDB(t)
+	ver, err := db.SetIfNotExists("baz", testStruct{"foo"})
+	assert.NoError(t, err)
+
+	// A key set again after being deleted doesn't reuse its old versions
+	assert.NoError(t, db.Del("baz"))
+	_, err = db.SetIfNotExists("baz", testStruct{"foo"})
+	assert.NoError(t, err)
+	_, err = db.SetIfVersion("baz", testStruct{"foo"}, ver)
+	assert.Equal(t, kv.ErrVersionMismatch, err)
+
+	// The version is kept in the version column
+	ver, err = db.GetWithVersion("baz", &v)
+	assert.NoError(t, err)
+	var col uint64
+	assert.NoError(t, db.DB().QueryRow(`SELECT version FROM "test" WHERE key = 'baz'`).Scan(&col))
+	assert.Equal(t, ver, col)
+}
@@ -0,0 +1,119 @@
+package sqlite
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bradberger/gokv/kv"
+	"golang.org/x/net/context"
+)
+
+// scanBatchSize is the number of rows an iterator reads per query
+const scanBatchSize = 100
+
+// iterator is a "kv.Iterator" reading the rows of the range in batches ordered by key. No query
+// is left open between batches, so the database can be used while iterating, and rows changed
+// during the iteration may or may not be returned.
+type iterator struct {
+	d                  *DB
+	prefix, start, end string
+	last               string
+	started, done      bool
+
+	keys []string
+	vals [][]byte
+
+	key string
+	val []byte
+	err error
+}
+
+// Scan implements the "kv.Scanner".Scan() interface. Keys are returned in byte order and expired
+// keys are skipped.
+func (d *DB) Scan(prefix, start, end string) kv.Iterator {
+	if start < prefix {
+		start = prefix
+	}
+	return &iterator{d: d, prefix: prefix, start: start, end: end}
+}
+
+// Next implements the "kv.Iterator".Next() interface
+func (it *iterator) Next() bool {
+	for it.err == nil {
+		if len(it.keys) > 0 {
+			it.key, it.val = it.keys[0], it.vals[0]
+			it.keys, it.vals = it.keys[1:], it.vals[1:]
+			if !strings.HasPrefix(it.key, it.prefix) {
+				break
+			}
+			return true
+		}
+		if it.done {
+			break
+		}
+		it.err = it.fetch()
+	}
+	it.key, it.val, it.done = "", nil, true
+	it.keys, it.vals = nil, nil
+	return false
+}
+
+// fetch reads the next batch of rows following the last key read
+func (it *iterator) fetch() error {
+	q, args := "SELECT key, value FROM {table} WHERE key >= ?", []interface{}{it.start}
+	if it.started {
+		q, args = "SELECT key, value FROM {table} WHERE key > ?", []interface{}{it.last}
+	}
+	if it.end != "" {
+		q, args = q+" AND key < ?", append(args, it.end)
+	}
+	q, args = q+" AND (expires_at IS NULL OR expires_at > ?) ORDER BY key LIMIT ?", append(args, time.Now().UnixNano(), scanBatchSize)
+
+	rows, err := it.d.DB().QueryContext(context.Background(), it.d.query(q), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var val []byte
+		if err := rows.Scan(&key, &val); err != nil {
+			return err
+		}
+		it.keys, it.vals = append(it.keys, key), append(it.vals, val)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	it.started = true
+	it.done = len(it.keys) < scanBatchSize
+	if len(it.keys) > 0 {
+		it.last = it.keys[len(it.keys)-1]
+	}
+	return nil
+}
+
+// Key implements the "kv.Iterator".Key() interface
+func (it *iterator) Key() string {
+	return it.key
+}
+
+// Value implements the "kv.Iterator".Value() interface
+func (it *iterator) Value(dstVal interface{}) error {
+	if it.val == nil {
+		return kv.ErrNotFound
+	}
+	return it.d.Codec().Unmarshal(it.val, dstVal)
+}
+
+// Err implements the "kv.Iterator".Err() interface
+func (it *iterator) Err() error {
+	return it.err
+}
+
+// Close implements the "kv.Iterator".Close() interface
+func (it *iterator) Close() error {
+	it.done = true
+	it.keys, it.vals = nil, nil
+	return nil
+}
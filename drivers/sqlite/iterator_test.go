@@ -0,0 +1,54 @@
+package sqlite
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/bradberger/gokv/kv"
+	"github.com/bradberger/gokv/kv/kvtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// scanKeys collects the keys of an iterator
+func scanKeys(t *testing.T, it kv.Iterator) []string {
+	keys := []string{}
+	for it.Next() {
+		var v testStruct
+		assert.NoError(t, it.Value(&v))
+		assert.Equal(t, it.Key(), v.Foo)
+		keys = append(keys, it.Key())
+	}
+	assert.NoError(t, it.Err())
+	assert.NoError(t, it.Close())
+	return keys
+}
+
+func TestScan(t *testing.T) {
+	kvtest.Scan(t, newTestStore, kvtest.ScanOptions{SetExpired: kvtest.ExpireSoon})
+}
+
+func TestScanBatches(t *testing.T) {
+	db := newTestDB(t)
+	items := map[string]interface{}{}
+	for i := 0; i < 2*scanBatchSize+10; i++ {
+		key := fmt.Sprintf("k%03d", i)
+		items[key] = testStruct{key}
+	}
+	assert.NoError(t, db.SetMulti(items))
+
+	keys := scanKeys(t, db.Scan("k", "", ""))
+	assert.Len(t, keys, 2*scanBatchSize+10)
+	assert.True(t, sort.StringsAreSorted(keys))
+
+	// The database can be written while iterating
+	it := db.Scan("", "", "")
+	defer it.Close()
+	for i := 0; it.Next(); i++ {
+		if i == 0 {
+			assert.NoError(t, db.Del("k209"))
+		}
+		assert.NotEqual(t, "k209", it.Key())
+	}
+	assert.NoError(t, it.Err())
+}
@@ -0,0 +1,477 @@
+// Package sqlite implements a key/value store kept in a SQLite table, so the data can also be
+// inspected with plain SQL
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"golang.org/x/net/context"
+
+	// registers the pure Go "sqlite" database/sql driver
+	_ "modernc.org/sqlite"
+)
+
+// driverName is the name the pure Go SQLite driver is registered under
+const driverName = "sqlite"
+
+var (
+	// Codec is the codec used to marshal/unmarshal interfaces into the blobs stored in the value column,
+	// for DBs created without the kv.WithCodec option. The default codec is Gob
+	Codec codec.Codec
+
+	// ensure struct implements the kv.Store interface
+	_ kv.Store         = (*DB)(nil)
+	_ kv.ContextStore  = (*DB)(nil)
+	_ kv.Expirer       = (*DB)(nil)
+	_ kv.Sweeper       = (*DB)(nil)
+	_ kv.Batcher       = (*DB)(nil)
+	_ kv.Scanner       = (*DB)(nil)
+	_ kv.Datastore     = (*DB)(nil)
+	_ kv.Clearer       = (*DB)(nil)
+	_ kv.RawStore      = (*DB)(nil)
+	_ kv.CAS           = (*DB)(nil)
+	_ kv.Transactional = (*DB)(nil)
+	_ kv.Watcher       = (*DB)(nil)
+)
+
+func init() {
+	Codec = codec.Gob
+}
+
+// DB is a key/value store kept in a SQLite table with the columns key, value, expires_at and
+// version. expires_at holds the expiration as unix nanoseconds and is NULL for keys which never
+// expire. version is the "kv.CAS" version of the key.
+type DB struct {
+	db    *sql.DB
+	table string
+	opts  kv.Options
+	hub   kv.Hub
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// New opens the SQLite database at path, which can be ":memory:", using the pure Go
+// modernc.org/sqlite driver. SQLite only allows a single writer, so the connection pool is limited
+// to one connection. If the table does not exist, it will be created. Options like kv.WithCodec
+// configure the DB itself.
+func New(path string, table string, opts ...kv.Option) (*DB, error) {
+	db, err := sql.Open(driverName, path)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec("PRAGMA busy_timeout = 5000"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	d, err := NewDB(db, table, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// NewDB returns a key/value store using the table of an already opened SQLite database. If the
+// table does not exist, it will be created.
+func NewDB(db *sql.DB, table string, opts ...kv.Option) (*DB, error) {
+	d := &DB{db: db, table: table, opts: kv.NewOptions(opts...)}
+	for _, q := range []string{
+		`CREATE TABLE IF NOT EXISTS {table} (
+			key TEXT NOT NULL PRIMARY KEY,
+			value BLOB NOT NULL,
+			expires_at INTEGER,
+			version INTEGER NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS {index} ON {table} (expires_at)`,
+	} {
+		if _, err := db.Exec(d.query(q)); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// quote quotes a SQL identifier
+func quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// query replaces the {table} and {index} placeholders of q with the quoted table and index names
+func (d *DB) query(q string) string {
+	return strings.NewReplacer("{table}", quote(d.table), "{index}", quote(d.table+"_expires_at")).Replace(q)
+}
+
+// nanos returns exp as unix nanoseconds, or nil for the zero time so it's stored as NULL
+func nanos(exp time.Time) interface{} {
+	if exp.IsZero() {
+		return nil
+	}
+	return exp.UnixNano()
+}
+
+// row is a row of the table
+type row struct {
+	value   []byte
+	exp     time.Time
+	version uint64
+}
+
+// expired returns true if the row has expired
+func (r *row) expired() bool {
+	return codec.Expired(r.exp)
+}
+
+// get reads the row of the key, returning kv.ErrNotFound if it doesn't exist
+func (d *DB) get(ctx context.Context, q queryer, key string) (*row, error) {
+	var r row
+	var exp sql.NullInt64
+	err := q.QueryRowContext(ctx, d.query("SELECT value, expires_at, version FROM {table} WHERE key = ?"), key).
+		Scan(&r.value, &exp, &r.version)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, kv.ErrNotFound
+	case err != nil:
+		return nil, err
+	}
+	if exp.Valid {
+		r.exp = time.Unix(0, exp.Int64)
+	}
+	return &r, nil
+}
+
+// current reads the row of the key, returning kv.ErrCacheMiss if it has expired
+func (d *DB) current(ctx context.Context, q queryer, key string) (*row, error) {
+	r, err := d.get(ctx, q, key)
+	if err != nil {
+		return nil, err
+	}
+	if r.expired() {
+		return nil, kv.ErrCacheMiss
+	}
+	return r, nil
+}
+
+// nextVersion returns a new version for a key currently at version cur. Versions are derived from
+// the clock rather than counted from one, so a key which is deleted and set again doesn't reuse
+// the versions it had before.
+func nextVersion(cur uint64) uint64 {
+	if now := uint64(time.Now().UnixNano()); now > cur {
+		return now
+	}
+	return cur + 1
+}
+
+// changes records the events of a transaction, to be published once it's committed
+type changes []kv.Event
+
+// put writes the value of the key within the transaction and records the event, returning the new
+// version of the key
+func (d *DB) put(ctx context.Context, tx *sql.Tx, c *changes, key string, b []byte, exp time.Time) (uint64, error) {
+	var cur uint64
+	if r, err := d.get(ctx, tx, key); err == nil {
+		cur = r.version
+	} else if err != kv.ErrNotFound {
+		return 0, err
+	}
+	version := nextVersion(cur)
+	_, err := tx.ExecContext(ctx, d.query("INSERT OR REPLACE INTO {table} (key, value, expires_at, version) VALUES (?, ?, ?, ?)"),
+		key, b, nanos(exp), version)
+	if err != nil {
+		return 0, err
+	}
+	*c = append(*c, kv.Event{Type: kv.EventPut, Key: key, Value: b})
+	return version, nil
+}
+
+// del deletes the key within the transaction and records the event. It returns kv.ErrNotFound if
+// the key doesn't exist.
+func (d *DB) del(ctx context.Context, tx *sql.Tx, c *changes, key string) error {
+	res, err := tx.ExecContext(ctx, d.query("DELETE FROM {table} WHERE key = ?"), key)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return kv.ErrNotFound
+	}
+	*c = append(*c, kv.Event{Type: kv.EventDelete, Key: key})
+	return nil
+}
+
+// update runs fn in a transaction, publishing the changes it records to watchers once the
+// transaction has been committed
+func (d *DB) update(ctx context.Context, fn func(tx *sql.Tx, c *changes) error) error {
+	tx, err := d.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var c changes
+	if err := fn(tx, &c); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for i := range c {
+		d.hub.Publish(c[i])
+	}
+	return nil
+}
+
+// Set implements the "kv.Store".Set() interface
+func (d *DB) Set(key string, value interface{}) error {
+	return d.SetContext(context.Background(), key, value)
+}
+
+// Get implements the "kv.Store".Get() interface
+func (d *DB) Get(key string, dstVal interface{}) error {
+	return d.GetContext(context.Background(), key, dstVal)
+}
+
+// Del implements the "kv.Store".Del() interface
+func (d *DB) Del(key string) error {
+	return d.DelContext(context.Background(), key)
+}
+
+// SetContext implements the "kv.ContextStore".SetContext() interface
+func (d *DB) SetContext(ctx context.Context, key string, value interface{}) error {
+	b, err := d.Codec().Marshal(value)
+	if err != nil {
+		return err
+	}
+	return d.set(ctx, key, b, time.Time{})
+}
+
+// set writes the encoded value of the key in its own transaction
+func (d *DB) set(ctx context.Context, key string, b []byte, exp time.Time) error {
+	return d.update(ctx, func(tx *sql.Tx, c *changes) error {
+		_, err := d.put(ctx, tx, c, key, b, exp)
+		return err
+	})
+}
+
+// GetContext implements the "kv.ContextStore".GetContext() interface
+func (d *DB) GetContext(ctx context.Context, key string, dstVal interface{}) error {
+	r, err := d.current(ctx, d.DB(), key)
+	if err != nil {
+		return err
+	}
+	return d.Codec().Unmarshal(r.value, dstVal)
+}
+
+// DelContext implements the "kv.ContextStore".DelContext() interface
+func (d *DB) DelContext(ctx context.Context, key string) error {
+	return d.update(ctx, func(tx *sql.Tx, c *changes) error {
+		return d.del(ctx, tx, c, key)
+	})
+}
+
+// Watch implements the "kv.Watcher".Watch() interface for changes made through this DB. Changes
+// made with SQL or by other processes aren't reported.
+func (d *DB) Watch(prefix string) (<-chan kv.Event, func()) {
+	return d.hub.Watch(prefix)
+}
+
+// SetMulti implements the "kv.Batcher".SetMulti() interface, setting all items in a single transaction
+func (d *DB) SetMulti(items map[string]interface{}) error {
+	encoded := make(map[string][]byte, len(items))
+	for key, value := range items {
+		b, err := d.Codec().Marshal(value)
+		if err != nil {
+			return err
+		}
+		encoded[key] = b
+	}
+	ctx := context.Background()
+	return d.update(ctx, func(tx *sql.Tx, c *changes) error {
+		for key, b := range encoded {
+			if _, err := d.put(ctx, tx, c, key, b, time.Time{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetMulti implements the "kv.Batcher".GetMulti() interface, reading all keys in a single transaction
+func (d *DB) GetMulti(keys []string, dst interface{}) error {
+	return d.View(func(tx kv.Tx) error {
+		return kv.FillMulti(keys, dst, func(i int, key string, dstVal interface{}) error {
+			return tx.Get(key, dstVal)
+		})
+	})
+}
+
+// DelMulti implements the "kv.Batcher".DelMulti() interface, deleting all keys in a single
+// transaction. Missing keys are ignored.
+func (d *DB) DelMulti(keys []string) error {
+	ctx := context.Background()
+	return d.update(ctx, func(tx *sql.Tx, c *changes) error {
+		for _, key := range keys {
+			if err := d.del(ctx, tx, c, key); err != nil && err != kv.ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetWithTTL implements the "kv.Expirer".SetWithTTL() interface. The expiration is stored in the
+// expires_at column.
+func (d *DB) SetWithTTL(key string, value interface{}, ttl time.Duration) error {
+	b, err := d.Codec().Marshal(value)
+	if err != nil {
+		return err
+	}
+	return d.set(context.Background(), key, b, codec.ExpiresAt(ttl))
+}
+
+// TTL implements the "kv.Expirer".TTL() interface
+func (d *DB) TTL(key string) (time.Duration, error) {
+	r, err := d.current(context.Background(), d.DB(), key)
+	if err != nil {
+		return 0, err
+	}
+	if r.exp.IsZero() {
+		return kv.NoExpiration, nil
+	}
+	return r.exp.Sub(time.Now()), nil
+}
+
+// Touch implements the "kv.Expirer".Touch() interface. Only the expires_at column is updated, so
+// the version of the key doesn't change.
+func (d *DB) Touch(key string, ttl time.Duration) error {
+	ctx := context.Background()
+	return d.update(ctx, func(tx *sql.Tx, c *changes) error {
+		r, err := d.current(ctx, tx, key)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, d.query("UPDATE {table} SET expires_at = ? WHERE key = ?"), nanos(codec.ExpiresAt(ttl)), key); err != nil {
+			return err
+		}
+		*c = append(*c, kv.Event{Type: kv.EventPut, Key: key, Value: r.value})
+		return nil
+	})
+}
+
+// Sweep implements the "kv.Sweeper".Sweep() interface, deleting all expired keys in a single
+// transaction. Use kv.SweepEvery() to run it periodically.
+func (d *DB) Sweep() error {
+	ctx := context.Background()
+	return d.update(ctx, func(tx *sql.Tx, c *changes) error {
+		keys, err := d.keys(ctx, tx, "SELECT key FROM {table} WHERE expires_at <= ?", time.Now().UnixNano())
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := d.del(ctx, tx, c, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// keys returns the keys selected by the query
+func (d *DB) keys(ctx context.Context, q queryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.QueryContext(ctx, d.query(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// GetRaw implements the "kv.RawStore".GetRaw() interface. The expiration of the key is added as
+// an expiry header, so it's kept when the value is copied to another store.
+func (d *DB) GetRaw(key string) ([]byte, error) {
+	r, err := d.current(context.Background(), d.DB(), key)
+	if err != nil {
+		return nil, err
+	}
+	return codec.WithExpiry(r.value, r.exp), nil
+}
+
+// SetRaw implements the "kv.RawStore".SetRaw() interface. An expiry header is moved to the
+// expires_at column.
+func (d *DB) SetRaw(key string, b []byte) error {
+	b, exp := codec.SplitExpiry(b)
+	return d.set(context.Background(), key, b, exp)
+}
+
+// Codec implements the "kv.RawStore".Codec() interface
+func (d *DB) Codec() codec.Codec {
+	return d.opts.CodecOr(Codec)
+}
+
+// Keys implements the "kv.KeyList".Keys() interface. Expired keys are left out. Since the
+// interface can't return an error, nil is returned if the table can't be read.
+func (d *DB) Keys() []string {
+	keys, err := d.keys(context.Background(), d.DB(), "SELECT key FROM {table} WHERE expires_at IS NULL OR expires_at > ? ORDER BY key",
+		time.Now().UnixNano())
+	if err != nil {
+		return nil
+	}
+	return keys
+}
+
+// Transfer implements the "kv.Datastore".Transfer() interface, copying all keys to dst.
+// Encoded values are copied as is if dst is a "kv.RawStore" sharing the same codec.
+func (d *DB) Transfer(dst kv.Store) error {
+	return kv.Transfer(d, dst)
+}
+
+// Clear implements the "kv.Clearer".Clear() interface by deleting all rows of the table.
+// Watchers receive a delete event for every key.
+func (d *DB) Clear() error {
+	ctx := context.Background()
+	return d.update(ctx, func(tx *sql.Tx, c *changes) error {
+		keys, err := d.keys(ctx, tx, "SELECT key FROM {table}")
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, d.query("DELETE FROM {table}")); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			*c = append(*c, kv.Event{Type: kv.EventDelete, Key: key})
+		}
+		return nil
+	})
+}
+
+// DB returns the underlying database
+func (d *DB) DB() *sql.DB {
+	return d.db
+}
+
+// Table returns the name of the table in use
+func (d *DB) Table() string {
+	return d.table
+}
+
+// Close closes the underlying database
+func (d *DB) Close() error {
+	return d.DB().Close()
+}
@@ -0,0 +1,324 @@
+package sqlite
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type testStruct struct {
+	Foo string
+}
+
+// newTestDB opens an in-memory database which is closed once the test completes
+func newTestDB(t *testing.T, opts ...kv.Option) *DB {
+	db, err := New(":memory:", "test", opts...)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db
+}
+
+// newTestStore returns a new database for the kvtest conformance tests
+func newTestStore(t *testing.T) kv.Store {
+	return newTestDB(t)
+}
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "kv.db")
+	db, err := New(fn, `my "table"`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, db.db, db.DB())
+	assert.Equal(t, `my "table"`, db.Table())
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, db.Close())
+
+	// The data is kept in the file and can be read with plain SQL
+	db, err = New(fn, `my "table"`)
+	assert.NoError(t, err)
+	defer db.Close()
+	var v testStruct
+	assert.NoError(t, db.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+
+	var n int
+	assert.NoError(t, db.DB().QueryRow(`SELECT COUNT(*) FROM "my ""table""" WHERE key = 'foo'`).Scan(&n))
+	assert.Equal(t, 1, n)
+
+	// Tables are independent of each other
+	other, err := NewDB(db.DB(), "other")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.ErrNotFound, other.Get("foo", &v))
+}
+
+func TestOpenErr(t *testing.T) {
+	db, err := New(filepath.Join(os.DevNull, "kv.db"), "test")
+	assert.Error(t, err)
+	assert.Nil(t, db)
+}
+
+func TestGetSetDel(t *testing.T) {
+	var v testStruct
+	db := newTestDB(t)
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &v))
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, db.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+	assert.NoError(t, db.Set("foo", testStruct{"baz"}))
+	assert.NoError(t, db.Get("foo", &v))
+	assert.Equal(t, testStruct{"baz"}, v)
+	assert.NoError(t, db.Del("foo"))
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &v))
+	assert.Equal(t, kv.ErrNotFound, db.Del("foo"))
+}
+
+func TestSetErr(t *testing.T) {
+	db := newTestDB(t, kv.WithCodec(codec.ErrTestCodec))
+	assert.Error(t, db.Set("foo", testStruct{"bar"}))
+	assert.Error(t, db.SetWithTTL("foo", testStruct{"bar"}, time.Minute))
+	assert.Error(t, db.SetMulti(map[string]interface{}{"foo": testStruct{"bar"}}))
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", nil))
+}
+
+func TestCodec(t *testing.T) {
+	var v testStruct
+	db := newTestDB(t, kv.WithCodec(codec.JSON))
+	assert.True(t, codec.Equal(codec.JSON, db.Codec()))
+	assert.True(t, codec.Equal(Codec, newTestDB(t).Codec()))
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	b, err := db.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"Foo":"bar"}`, string(b))
+	assert.NoError(t, db.Get("foo", &v))
+	assert.Equal(t, testStruct{"bar"}, v)
+}
+
+// TestRawValues checks blobs are stored verbatim with codec.RawOr
+func TestRawValues(t *testing.T) {
+	var s string
+	var b []byte
+	var buf bytes.Buffer
+	db := newTestDB(t, kv.WithCodec(codec.RawOr(codec.Gob)))
+	assert.NoError(t, db.Set("foo", []byte("\x89PNG")))
+	raw, err := db.GetRaw("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("\x89PNG"), raw)
+	assert.NoError(t, db.Get("foo", &b))
+	assert.Equal(t, []byte("\x89PNG"), b)
+	assert.NoError(t, db.Set("bar", strings.NewReader("bar")))
+	assert.NoError(t, db.Get("bar", &s))
+	assert.Equal(t, "bar", s)
+	assert.NoError(t, db.Get("bar", &buf))
+	assert.Equal(t, "bar", buf.String())
+
+	// Blobs which start like an expiry header aren't mistaken for one
+	ttl := []byte("\xffTTL\x00\x00\x00\x00\x00\x00\x00\x01foo")
+	assert.NoError(t, db.Set("baz", ttl))
+	assert.NoError(t, db.Get("baz", &b))
+	assert.Equal(t, ttl, b)
+}
+
+func TestContext(t *testing.T) {
+	var s string
+	db := newTestDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, db.SetContext(ctx, "foo", "bar"))
+	assert.NoError(t, db.GetContext(ctx, "foo", &s))
+	assert.Equal(t, "bar", s)
+
+	cancel()
+	assert.Equal(t, context.Canceled, db.SetContext(ctx, "bar", "baz"))
+	assert.Equal(t, context.Canceled, db.GetContext(ctx, "foo", &s))
+	assert.Equal(t, context.Canceled, db.DelContext(ctx, "foo"))
+	assert.NoError(t, db.Get("foo", &s))
+	assert.Equal(t, kv.ErrNotFound, db.Get("bar", &s))
+}
+
+func TestTTL(t *testing.T) {
+	var v testStruct
+	db := newTestDB(t)
+	assert.NoError(t, db.Set("foo", testStruct{"foo"}))
+	ttl, err := db.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+	_, err = db.TTL("baz")
+	assert.Equal(t, kv.ErrNotFound, err)
+
+	assert.NoError(t, db.SetWithTTL("bar", testStruct{"bar"}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, kv.ErrCacheMiss, db.Get("bar", &v))
+	_, err = db.TTL("bar")
+	assert.Equal(t, kv.ErrCacheMiss, err)
+	assert.Equal(t, kv.ErrCacheMiss, db.Touch("bar", time.Minute))
+	assert.Equal(t, kv.ErrNotFound, db.Touch("baz", time.Minute))
+
+	ver, err := db.GetWithVersion("foo", &v)
+	assert.NoError(t, err)
+	assert.NoError(t, db.Touch("foo", time.Minute))
+	ttl, err = db.TTL("foo")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 59*time.Second && ttl <= time.Minute)
+	newVer, err := db.GetWithVersion("foo", &v)
+	assert.NoError(t, err)
+	assert.Equal(t, ver, newVer)
+	assert.Equal(t, testStruct{"foo"}, v)
+
+	// The expiration is readable with plain SQL
+	var exp int64
+	assert.NoError(t, db.DB().QueryRow(`SELECT expires_at FROM "test" WHERE key = 'foo'`).Scan(&exp))
+	assert.WithinDuration(t, time.Now().Add(time.Minute), time.Unix(0, exp), time.Second)
+
+	assert.NoError(t, db.Touch("foo", 0))
+	ttl, err = db.TTL("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, kv.NoExpiration, ttl)
+}
+
+func TestSweep(t *testing.T) {
+	db := newTestDB(t)
+	events, cancel := db.Watch("")
+	defer cancel()
+
+	assert.NoError(t, db.Set("foo", testStruct{"foo"}))
+	assert.NoError(t, db.SetWithTTL("bar", testStruct{"bar"}, time.Millisecond))
+	assert.NoError(t, db.SetWithTTL("baz", testStruct{"baz"}, time.Minute))
+	time.Sleep(5 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		<-events
+	}
+
+	assert.NoError(t, db.Sweep())
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "bar"}, <-events)
+	var n int
+	assert.NoError(t, db.DB().QueryRow(`SELECT COUNT(*) FROM "test"`).Scan(&n))
+	assert.Equal(t, 2, n)
+}
+
+func TestMulti(t *testing.T) {
+	db := newTestDB(t)
+	assert.NoError(t, db.SetMulti(map[string]interface{}{
+		"foo": testStruct{"foo"},
+		"bar": testStruct{"bar"},
+	}))
+
+	m := map[string]testStruct{}
+	assert.NoError(t, db.GetMulti([]string{"foo", "bar", "baz"}, m))
+	assert.Equal(t, map[string]testStruct{"foo": {"foo"}, "bar": {"bar"}}, m)
+
+	var sl []*testStruct
+	assert.NoError(t, db.GetMulti([]string{"foo", "baz", "bar"}, &sl))
+	assert.Equal(t, []*testStruct{{"foo"}, nil, {"bar"}}, sl)
+
+	assert.NoError(t, db.DelMulti([]string{"foo", "bar", "baz"}))
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &testStruct{}))
+	assert.Equal(t, kv.ErrNotFound, db.Get("bar", &testStruct{}))
+}
+
+func TestRaw(t *testing.T) {
+	db := newTestDB(t)
+	assert.NoError(t, db.SetWithTTL("foo", testStruct{"foo"}, time.Minute))
+	b, err := db.GetRaw("foo")
+	assert.NoError(t, err)
+	val, exp := codec.SplitExpiry(b)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), exp, time.Second)
+
+	// The expiry header is moved to the expires_at column
+	assert.NoError(t, db.SetRaw("bar", b))
+	var stored []byte
+	assert.NoError(t, db.DB().QueryRow(`SELECT value FROM "test" WHERE key = 'bar'`).Scan(&stored))
+	assert.Equal(t, val, stored)
+	ttl, err := db.TTL("bar")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 59*time.Second)
+
+	_, err = db.GetRaw("baz")
+	assert.Equal(t, kv.ErrNotFound, err)
+}
+
+func TestKeysTransferClear(t *testing.T) {
+	vv := testStruct{}
+	db, db2 := newTestDB(t), newTestDB(t)
+
+	assert.NoError(t, db.Set("foo", testStruct{"bar"}))
+	assert.NoError(t, db.SetWithTTL("bar", testStruct{"bar"}, time.Minute))
+	assert.NoError(t, db.SetWithTTL("baz", testStruct{"baz"}, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, []string{"bar", "foo"}, db.Keys())
+
+	assert.NoError(t, db.Transfer(db2))
+	assert.Equal(t, []string{"bar", "foo"}, db2.Keys())
+	assert.NoError(t, db2.Get("foo", &vv))
+	assert.EqualValues(t, testStruct{"bar"}, vv)
+	ttl, err := db2.TTL("bar")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 0)
+
+	events, cancel := db.Watch("")
+	defer cancel()
+	assert.NoError(t, db.Clear())
+	assert.Equal(t, []string{}, db.Keys())
+	assert.Equal(t, kv.ErrNotFound, db.Get("foo", &vv))
+	deleted := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		e := <-events
+		assert.Equal(t, kv.EventDelete, e.Type)
+		deleted[e.Key] = true
+	}
+	assert.Equal(t, map[string]bool{"foo": true, "bar": true, "baz": true}, deleted)
+}
+
+func TestWatch(t *testing.T) {
+	var vv testStruct
+	db := newTestDB(t)
+
+	events, cancel := db.Watch("foo")
+	assert.NoError(t, db.Set("bar", testStruct{"bar"}))
+	assert.NoError(t, db.SetWithTTL("foo", testStruct{"foo"}, time.Minute))
+	assert.NoError(t, db.Touch("foo", time.Hour))
+	assert.NoError(t, db.Update(func(tx kv.Tx) error {
+		return tx.Del("foo")
+	}))
+
+	e := <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+
+	// Touch rewrites the key, so watchers see the unchanged value again
+	vv = testStruct{}
+	e = <-events
+	assert.Equal(t, kv.EventPut, e.Type)
+	assert.Equal(t, "foo", e.Key)
+	assert.NoError(t, Codec.Unmarshal(e.Value, &vv))
+	assert.Equal(t, testStruct{"foo"}, vv)
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "foo"}, <-events)
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+}
+
+func TestNextVersion(t *testing.T) {
+	now := uint64(time.Now().UnixNano())
+	assert.True(t, nextVersion(0) >= now)
+	cur := now + uint64(time.Hour)
+	assert.Equal(t, cur+1, nextVersion(cur))
+}
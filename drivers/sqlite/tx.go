@@ -0,0 +1,67 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/bradberger/gokv/kv"
+	"golang.org/x/net/context"
+)
+
+// tx is a "kv.Tx" wrapping a SQL transaction. A nil c marks a read only transaction.
+type tx struct {
+	d   *DB
+	ctx context.Context
+	tx  *sql.Tx
+	c   *changes
+}
+
+// Update implements the "kv.Transactional".Update() interface using a SQL transaction
+func (d *DB) Update(fn func(tx kv.Tx) error) error {
+	ctx := context.Background()
+	return d.update(ctx, func(stx *sql.Tx, c *changes) error {
+		return fn(&tx{d, ctx, stx, c})
+	})
+}
+
+// View implements the "kv.Transactional".View() interface. The SQL transaction is always rolled
+// back, and writes return kv.ErrReadOnly.
+func (d *DB) View(fn func(tx kv.Tx) error) error {
+	ctx := context.Background()
+	stx, err := d.DB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer stx.Rollback()
+	return fn(&tx{d: d, ctx: ctx, tx: stx})
+}
+
+// Set implements the "kv.Tx".Set() interface
+func (t *tx) Set(key string, value interface{}) error {
+	if t.c == nil {
+		return kv.ErrReadOnly
+	}
+	b, err := t.d.Codec().Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = t.d.put(t.ctx, t.tx, t.c, key, b, time.Time{})
+	return err
+}
+
+// Get implements the "kv.Tx".Get() interface
+func (t *tx) Get(key string, dstVal interface{}) error {
+	r, err := t.d.current(t.ctx, t.tx, key)
+	if err != nil {
+		return err
+	}
+	return t.d.Codec().Unmarshal(r.value, dstVal)
+}
+
+// Del implements the "kv.Tx".Del() interface
+func (t *tx) Del(key string) error {
+	if t.c == nil {
+		return kv.ErrReadOnly
+	}
+	return t.d.del(t.ctx, t.tx, t.c, key)
+}
@@ -3,8 +3,10 @@ package gokv
 
 import (
 	"errors"
+	"reflect"
 	"sync"
 
+	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/bradberger/gokv/kv"
@@ -23,7 +25,10 @@ const (
 )
 
 var (
-	_ kv.Store = (*Client)(nil)
+	_ kv.Store        = (*Client)(nil)
+	_ kv.ContextStore = (*Client)(nil)
+	_ kv.Batcher      = (*Client)(nil)
+	_ kv.Watcher      = (*Client)(nil)
 )
 
 // ReplicationMethod determines whether replication takes place asyncronously or syncronously.
@@ -33,6 +38,10 @@ type ReplicationMethod int
 // Client is a cache client with built in replication to any number of different caches.
 // This allows replication and syncronization across various caches using the set of drivers
 // available as subpackages, including Memcached, Redis, in-memory caches, and more.
+//
+// A Client is safe for concurrent use, including changing its nodes while it serves requests.
+// Each operation resolves the nodes owning its keys once, so it runs against a single topology
+// even if nodes are added or removed before it completes.
 type Client struct {
 	nodes map[string]kv.Store
 	ch    *consistent.Consistent
@@ -40,7 +49,12 @@ type Client struct {
 	replicateNodeCt int
 	replicateMethod ReplicationMethod
 
-	sync.Mutex
+	// hub forwards the change notifications of all nodes which are a "kv.Watcher"
+	hub     kv.Hub
+	unwatch map[string]func()
+
+	// mu guards the nodes, the consistent hash and the replication settings
+	mu sync.RWMutex
 }
 
 // New returns a new initialized cache Client with no nodes.
@@ -48,48 +62,129 @@ func New() *Client {
 	return &Client{nodes: make(map[string]kv.Store, 0), ch: consistent.New(), replicateMethod: ReplicateAsync}
 }
 
-// AddNode adds a cache node with the given name, but only if it doesn't already exist
+// AddNode adds a cache node with the given name, but only if it doesn't already exist. Existing
+// keys stay on their nodes until the client is rebalanced with Rebalance.
 func (c *Client) AddNode(name string, node kv.Store) error {
-	if _, exists := c.nodes[name]; exists {
-		return errors.New("node already exists")
-	}
-	return c.SetNode(name, node)
+	return c.setNode(name, node, func(exists bool) error {
+		if exists {
+			return errors.New("node already exists")
+		}
+		return nil
+	})
 }
 
 // SetNode sets the cache node with the given name, regardless of whether it already exists or not
 func (c *Client) SetNode(name string, node kv.Store) error {
+	return c.setNode(name, node, nil)
+}
+
+// ReplaceNode adds a cache node with the given name, but only if it already exists
+func (c *Client) ReplaceNode(name string, node kv.Store) error {
+	return c.setNode(name, node, func(exists bool) error {
+		if !exists {
+			return errors.New("node does not exist")
+		}
+		return nil
+	})
+}
+
+// setNode sets the named node if check, when given, accepts whether it already exists. The check
+// is made under the same lock as the change so concurrent calls can't both pass it.
+func (c *Client) setNode(name string, node kv.Store, check func(exists bool) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if check != nil {
+		_, exists := c.nodes[name]
+		if err := check(exists); err != nil {
+			return err
+		}
+	}
 	if node == nil {
 		return errors.New("cache node is nil")
 	}
+	c.stopWatching(name)
 	c.nodes[name] = node
 	c.ch.Add(name)
-	return nil
-}
-
-// ReplaceNode adds a cache node with the given name, but only if it already exists
-func (c *Client) ReplaceNode(name string, node kv.Store) error {
-	if _, exists := c.nodes[name]; !exists {
-		return errors.New("node does not exist")
+	if w, ok := node.(kv.Watcher); ok {
+		if c.unwatch == nil {
+			c.unwatch = make(map[string]func())
+		}
+		events, cancel := w.Watch("")
+		c.unwatch[name] = cancel
+		go c.forward(name, events)
 	}
-	return c.SetNode(name, node)
+	return nil
 }
 
-// RemoveNode removes a node with the given name from the node list
+// RemoveNode removes a node with the given name from the node list. Operations which already
+// resolved their nodes may still use it until they complete. Its keys can be moved to the remaining
+// nodes by passing it to Rebalance in RebalanceOptions.Retired.
 func (c *Client) RemoveNode(name string) error {
-	c.Lock()
-	defer c.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopWatching(name)
 	delete(c.nodes, name)
 	c.ch.Remove(name)
 	return nil
 }
 
+// Watch implements the "kv.Watcher".Watch() interface. Events of all nodes which are a
+// "kv.Watcher" are forwarded, so watchers fire regardless of which node received the write.
+// Since replicas see the same writes, an event is only forwarded from the first watchable
+// node owning the key, or from any node which doesn't own it at all.
+func (c *Client) Watch(prefix string) (<-chan kv.Event, func()) {
+	return c.hub.Watch(prefix)
+}
+
+// stopWatching cancels the subscription to the named node's events. The client must be locked.
+func (c *Client) stopWatching(name string) {
+	if cancel, ok := c.unwatch[name]; ok {
+		cancel()
+		delete(c.unwatch, name)
+	}
+}
+
+// forward publishes the events of the named node until its subscription is canceled
+func (c *Client) forward(name string, events <-chan kv.Event) {
+	for e := range events {
+		if c.isEventSource(name, e.Key) {
+			c.hub.Publish(e)
+		}
+	}
+}
+
+// isEventSource reports whether events for the key should be forwarded from the named node
+func (c *Client) isEventSource(name, key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	owners, err := c.ch.GetN(key, c.replicas())
+	if err != nil {
+		return false
+	}
+	var first string
+	var owner bool
+	for _, n := range owners {
+		if _, ok := c.nodes[n].(kv.Watcher); ok && first == "" {
+			first = n
+		}
+		if n == name {
+			owner = true
+		}
+	}
+	return !owner || first == name
+}
+
 // SetReplicateMethod sets the replication method
 func (c *Client) SetReplicateMethod(m ReplicationMethod) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.replicateMethod = m
 }
 
 // ReplicateToN sets how many nodes each key should be replicated to
 func (c *Client) ReplicateToN(numNodes int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if numNodes > len(c.ch.Members()) {
 		return errors.New("invalid number of nodes")
 	}
@@ -97,70 +192,262 @@ func (c *Client) ReplicateToN(numNodes int) error {
 	return nil
 }
 
-func (c *Client) node(nodeName string) kv.Store {
-	return c.nodes[nodeName]
+// replicas returns how many nodes own each key, which is all of them unless set with ReplicateToN.
+// consistent.GetN never returns if asked for more nodes than it finds before wrapping around the
+// ring, so zero is never passed to it. The client must be locked.
+func (c *Client) replicas() int {
+	if c.replicateNodeCt > 0 {
+		return c.replicateNodeCt
+	}
+	return len(c.nodes)
+}
+
+// owners returns the nodes owning the key in order of priority, along with the replication method
+func (c *Client) owners(key string) ([]kv.Store, ReplicationMethod, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names, err := c.ch.GetN(key, c.replicas())
+	if err != nil {
+		return nil, 0, err
+	}
+	nodes := make([]kv.Store, len(names))
+	for i, name := range names {
+		nodes[i] = c.nodes[name]
+	}
+	return nodes, c.replicateMethod, nil
+}
+
+// lookup returns the names of the nodes owning each key in order of priority, the nodes by name
+// and the replication method, all read at once for operations on multiple keys
+func (c *Client) lookup(keys []string) (map[string][]string, map[string]kv.Store, ReplicationMethod, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	owners := make(map[string][]string, len(keys))
+	nodes := make(map[string]kv.Store)
+	for _, key := range keys {
+		names, err := c.ch.GetN(key, c.replicas())
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		owners[key] = names
+		for _, name := range names {
+			nodes[name] = c.nodes[name]
+		}
+	}
+	return owners, nodes, c.replicateMethod, nil
 }
 
 // Set implements the "kv.Store".Set() interface
 func (c *Client) Set(key string, value interface{}) (err error) {
+	return c.SetContext(context.Background(), key, value)
+}
+
+// Get implements the "kv.Store".Get() interface. It checks nodes in order
+// of priority, and returns success if the value exists on any of them.
+func (c *Client) Get(key string, dstVal interface{}) (err error) {
+	return c.GetContext(context.Background(), key, dstVal)
+}
 
-	nodes, err := c.ch.GetN(key, c.replicateNodeCt)
+// Del implements the "kv.Store".Del() interface. It deletes the given key across
+// all replicated nodes and returns error if any of those delete operations fail.
+func (c *Client) Del(key string) (err error) {
+	return c.DelContext(context.Background(), key)
+}
+
+// SetContext implements the "kv.ContextStore".SetContext() interface. With syncronous
+// replication the remaining node writes are canceled as soon as ctx is done or any of
+// them fails. Asyncronous replicas are written in the background and don't use ctx,
+// since it's likely to be canceled as soon as the call returns.
+func (c *Client) SetContext(ctx context.Context, key string, value interface{}) (err error) {
+
+	nodes, method, err := c.owners(key)
 	if err != nil {
 		return
 	}
 
-	if c.replicateMethod == ReplicateSync {
-		var eg errgroup.Group
+	if method == ReplicateSync {
+		eg, egCtx := errgroup.WithContext(ctx)
 		for i := range nodes {
-			nodeName := nodes[i]
+			node := nodes[i]
 			eg.Go(func() error {
-				return c.node(nodeName).Set(key, value)
+				return kv.ContextAdapter(node).SetContext(egCtx, key, value)
 			})
 		}
 		return eg.Wait()
 	}
 
-	err = c.node(nodes[0]).Set(key, value)
+	err = kv.ContextAdapter(nodes[0]).SetContext(ctx, key, value)
 	if len(nodes) > 1 {
 		nodes = nodes[1:]
 		for i := range nodes {
-			go c.node(nodes[i]).Set(key, value)
+			go nodes[i].Set(key, value)
 		}
 	}
 
 	return
 }
 
-// Get implements the "kv.Store".Get() interface. It checks nodes in order
-// of priority, and returns success if the value exists on any of them.
-func (c *Client) Get(key string, dstVal interface{}) (err error) {
-	nodes, err := c.ch.GetN(key, c.replicateNodeCt)
+// GetContext implements the "kv.ContextStore".GetContext() interface. It checks nodes in order
+// of priority, and returns success if the value exists on any of them. If ctx is done before
+// the value is found the context error is returned.
+func (c *Client) GetContext(ctx context.Context, key string, dstVal interface{}) (err error) {
+	nodes, _, err := c.owners(key)
 	if err != nil {
 		return err
 	}
 	for i := range nodes {
-		if err = c.node(nodes[i]).Get(key, dstVal); err == nil {
+		if err = kv.ContextAdapter(nodes[i]).GetContext(ctx, key, dstVal); err == nil {
 			return
 		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 	}
 	return kv.ErrNotFound
 }
 
-// Del implements the "kv.Store".Del() interface. It deletes the given key across
-// all replicated nodes and returns error if any of those delete operations fail.
-func (c *Client) Del(key string) (err error) {
+// DelContext implements the "kv.ContextStore".DelContext() interface. It deletes the given key
+// across all replicated nodes and returns error if any of those delete operations fail, in
+// which case the remaining deletes are canceled.
+func (c *Client) DelContext(ctx context.Context, key string) (err error) {
 
-	nodes, err := c.ch.GetN(key, c.replicateNodeCt)
+	nodes, _, err := c.owners(key)
 	if err != nil {
 		return
 	}
 
-	var eg errgroup.Group
+	eg, egCtx := errgroup.WithContext(ctx)
 	for i := range nodes {
-		name := nodes[i]
+		node := nodes[i]
+		eg.Go(func() error {
+			return kv.ContextAdapter(node).DelContext(egCtx, key)
+		})
+	}
+	return eg.Wait()
+}
+
+// SetMulti implements the "kv.Batcher".SetMulti() interface. Items are grouped by the nodes
+// which own them according to the consistent hash, and each node gets a single batch. Nodes
+// which don't implement kv.Batcher have their items set one at a time.
+func (c *Client) SetMulti(items map[string]interface{}) error {
+
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	owners, nodes, method, err := c.lookup(keys)
+	if err != nil {
+		return err
+	}
+
+	syncNodes := make(map[string]map[string]interface{})
+	async := make(map[string]map[string]interface{})
+	for key, value := range items {
+		for i, name := range owners[key] {
+			groups := async
+			if i == 0 || method == ReplicateSync {
+				groups = syncNodes
+			}
+			if groups[name] == nil {
+				groups[name] = make(map[string]interface{})
+			}
+			groups[name][key] = value
+		}
+	}
+
+	var eg errgroup.Group
+	for name, group := range syncNodes {
+		node, group := nodes[name], group
 		eg.Go(func() error {
-			return c.node(name).Del(key)
+			return kv.SetMulti(node, group)
 		})
 	}
+	for name, group := range async {
+		go kv.SetMulti(nodes[name], group)
+	}
 	return eg.Wait()
 }
+
+// GetMulti implements the "kv.Batcher".GetMulti() interface. Keys are grouped by their highest
+// priority node and fetched with a single batch per node. Keys which aren't found are then
+// retried on the next node in their priority list, just as with Get().
+func (c *Client) GetMulti(keys []string, dst interface{}) error {
+
+	elemType, err := multiElemType(dst)
+	if err != nil {
+		return err
+	}
+
+	owners, nodes, _, err := c.lookup(keys)
+	if err != nil {
+		return err
+	}
+
+	// found holds pointers to the decoded values, keyed by key
+	found := reflect.MakeMap(reflect.MapOf(reflect.TypeOf(""), reflect.PtrTo(elemType)))
+	for attempt := 0; ; attempt++ {
+		groups := make(map[string][]string)
+		for key, names := range owners {
+			if attempt < len(names) && !found.MapIndex(reflect.ValueOf(key)).IsValid() {
+				groups[names[attempt]] = append(groups[names[attempt]], key)
+			}
+		}
+		if len(groups) == 0 {
+			break
+		}
+		for name, group := range groups {
+			// Errors are treated like misses so the keys are tried on the next node
+			kv.GetMulti(nodes[name], group, found.Interface())
+		}
+	}
+
+	return kv.FillMulti(keys, dst, func(i int, key string, dstVal interface{}) error {
+		v := found.MapIndex(reflect.ValueOf(key))
+		if !v.IsValid() {
+			return kv.ErrNotFound
+		}
+		reflect.ValueOf(dstVal).Elem().Set(v.Elem())
+		return nil
+	})
+}
+
+// DelMulti implements the "kv.Batcher".DelMulti() interface. Keys are grouped by node and
+// deleted from every node which owns them with a single batch per node.
+func (c *Client) DelMulti(keys []string) error {
+
+	owners, nodes, _, err := c.lookup(keys)
+	if err != nil {
+		return err
+	}
+	groups := make(map[string][]string)
+	for _, key := range keys {
+		for _, name := range owners[key] {
+			groups[name] = append(groups[name], key)
+		}
+	}
+
+	var eg errgroup.Group
+	for name, group := range groups {
+		node, group := nodes[name], group
+		eg.Go(func() error {
+			return kv.DelMulti(node, group)
+		})
+	}
+	return eg.Wait()
+}
+
+// multiElemType returns the underlying, non-pointer element type of a "kv.Batcher".GetMulti() dst
+func multiElemType(dst interface{}) (reflect.Type, error) {
+	t := reflect.TypeOf(dst)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || (t.Kind() != reflect.Map && t.Kind() != reflect.Slice) {
+		return nil, kv.ErrInvalidDstVal
+	}
+	t = t.Elem()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t, nil
+}
@@ -2,40 +2,21 @@ package gokv
 
 import (
 	"fmt"
-	"io/ioutil"
-	"math/rand"
-	"os"
+	"sync"
 	"testing"
+	"time"
 
-	dv "github.com/bradberger/gokv/drivers/diskv"
+	"github.com/bradberger/gokv/drivers/memory"
 	"github.com/bradberger/gokv/kv"
-	"github.com/peterbourgon/diskv"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
 )
 
-func getTestOptions() diskv.Options {
-	tmpDir, err := ioutil.TempDir("", fmt.Sprintf("diskv-%d", rand.Intn(100)))
-	if err != nil {
-		panic(err)
-	}
-	return diskv.Options{
-		BasePath:     tmpDir,
-		Transform:    func(s string) []string { return []string{} },
-		CacheSizeMax: 1024 * 1024,
-	}
-}
-
 func TestReplicationN(t *testing.T) {
 
-	opts := getTestOptions()
-	opts2 := getTestOptions()
-	db := dv.New(opts)
-	db2 := dv.New(opts2)
-	defer func() {
-		os.RemoveAll(opts.BasePath)
-		os.RemoveAll(opts2.BasePath)
-	}()
+	db := memory.New(memory.Options{})
+	db2 := memory.New(memory.Options{})
 
 	var s string
 	c := New()
@@ -56,11 +37,7 @@ func TestReplicationN(t *testing.T) {
 func TestSetNodes(t *testing.T) {
 	c := New()
 
-	opts := getTestOptions()
-	db := dv.New(opts)
-	defer func() {
-		os.RemoveAll(opts.BasePath)
-	}()
+	db := memory.New(memory.Options{})
 
 	assert.Error(t, c.ReplaceNode("node-01", db))
 	assert.Error(t, c.SetNode("node-01", nil))
@@ -73,11 +50,7 @@ func TestSetNodes(t *testing.T) {
 
 func TestGetErr(t *testing.T) {
 
-	opts := getTestOptions()
-	db := dv.New(opts)
-	defer func() {
-		os.RemoveAll(opts.BasePath)
-	}()
+	db := memory.New(memory.Options{})
 
 	var v string
 	c := New()
@@ -87,16 +60,8 @@ func TestGetErr(t *testing.T) {
 
 func TestReplicationSync(t *testing.T) {
 
-	opts := getTestOptions()
-	opts2 := getTestOptions()
-	db := dv.New(opts)
-	db2 := dv.New(opts2)
-	defer func() {
-		os.RemoveAll(opts.BasePath)
-		os.RemoveAll(opts2.BasePath)
-	}()
-
-	assert.NotEqual(t, opts.BasePath, opts2.BasePath)
+	db := memory.New(memory.Options{})
+	db2 := memory.New(memory.Options{})
 
 	var s string
 	c := New()
@@ -111,7 +76,7 @@ func TestReplicationSync(t *testing.T) {
 	assert.NoError(t, c.nodes["node-01"].Del("foo"))
 	assert.NoError(t, c.Get("foo", &s))
 	assert.Equal(t, "bar", s)
-	assert.True(t, db2.Diskv().Has("foo"))
+	assert.True(t, db2.Exists("foo"))
 	assert.NoError(t, c.nodes["node-02"].Del("foo"))
 	assert.Equal(t, kv.ErrNotFound, c.Del("foo"))
 }
@@ -122,3 +87,188 @@ func TestGetNErr(t *testing.T) {
 	assert.Error(t, c.Set("foo", "bar"))
 	assert.Error(t, c.Get("foo", nil))
 }
+
+func TestContext(t *testing.T) {
+
+	db := memory.New(memory.Options{})
+	db2 := memory.New(memory.Options{})
+
+	var s string
+	c := New()
+	c.AddNode("node-01", db)
+	c.AddNode("node-02", db2)
+	c.ReplicateToN(2)
+	c.SetReplicateMethod(ReplicateSync)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.NoError(t, c.SetContext(ctx, "foo", "bar"))
+	assert.NoError(t, c.GetContext(ctx, "foo", &s))
+	assert.Equal(t, "bar", s)
+
+	cancel()
+	assert.Equal(t, context.Canceled, c.SetContext(ctx, "bar", "baz"))
+	assert.Equal(t, context.Canceled, c.GetContext(ctx, "foo", &s))
+	assert.Equal(t, context.Canceled, c.DelContext(ctx, "foo"))
+	assert.False(t, db.Exists("bar"))
+	assert.False(t, db2.Exists("bar"))
+	assert.True(t, db.Exists("foo"))
+	assert.True(t, db2.Exists("foo"))
+}
+
+func TestMulti(t *testing.T) {
+
+	db := memory.New(memory.Options{})
+	db2 := memory.New(memory.Options{})
+
+	c := New()
+	c.AddNode("node-01", db)
+	c.AddNode("node-02", db2)
+	c.ReplicateToN(1)
+
+	items := map[string]interface{}{}
+	keys := make([]string, 0, 20)
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		items[key] = key
+		keys = append(keys, key)
+	}
+	assert.NoError(t, c.SetMulti(items))
+
+	// With one replica each key should live on exactly one node
+	for _, key := range keys {
+		assert.NotEqual(t, db.Exists(key), db2.Exists(key))
+	}
+
+	m := map[string]string{}
+	assert.NoError(t, c.GetMulti(append(keys, "missing"), m))
+	assert.Len(t, m, 20)
+	assert.Equal(t, "key-3", m["key-3"])
+
+	var sl []string
+	assert.NoError(t, c.GetMulti([]string{"key-1", "missing", "key-2"}, &sl))
+	assert.Equal(t, []string{"key-1", "", "key-2"}, sl)
+	assert.Equal(t, kv.ErrInvalidDstVal, c.GetMulti(keys, "foo"))
+
+	assert.NoError(t, c.DelMulti(keys))
+	for _, key := range keys {
+		assert.False(t, db.Exists(key) || db2.Exists(key))
+	}
+}
+
+func TestMultiFallback(t *testing.T) {
+
+	db := memory.New(memory.Options{})
+	db2 := memory.New(memory.Options{})
+
+	c := New()
+	c.AddNode("node-01", db)
+	c.AddNode("node-02", db2)
+	c.ReplicateToN(2)
+	c.SetReplicateMethod(ReplicateSync)
+
+	assert.NoError(t, c.SetMulti(map[string]interface{}{"foo": "bar"}))
+	assert.NoError(t, db.Del("foo"))
+
+	m := map[string]string{}
+	assert.NoError(t, c.GetMulti([]string{"foo"}, m))
+	assert.Equal(t, "bar", m["foo"])
+}
+
+func TestWatch(t *testing.T) {
+
+	db := memory.New(memory.Options{})
+	db2 := memory.New(memory.Options{})
+
+	c := New()
+	c.AddNode("node-01", db)
+	c.AddNode("node-02", db2)
+	c.SetReplicateMethod(ReplicateSync)
+
+	events, cancel := c.Watch("key-")
+	defer cancel()
+
+	// Replicas see the same write, but it's only reported once
+	assert.NoError(t, c.Set("key-1", "foo"))
+	assert.Equal(t, "key-1", (<-events).Key)
+
+	// Direct writes to the replicas are reported once as well
+	assert.NoError(t, db.Del("key-1"))
+	assert.NoError(t, db2.Del("key-1"))
+	assert.Equal(t, kv.Event{Type: kv.EventDelete, Key: "key-1"}, <-events)
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Removed nodes are no longer watched
+	assert.NoError(t, c.RemoveNode("node-02"))
+	assert.NoError(t, db2.Set("key-2", "foo"))
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConcurrentNodes(t *testing.T) {
+
+	// Run with -race to check topology changes don't race with operations
+	nodes := []*memory.Store{memory.New(memory.Options{}), memory.New(memory.Options{}), memory.New(memory.Options{})}
+
+	c := New()
+	assert.NoError(t, c.AddNode("node-00", nodes[0]))
+	events, cancel := c.Watch("")
+	defer cancel()
+	go func() {
+		for range events {
+		}
+	}()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			name := fmt.Sprintf("node-%02d", i%2+1)
+			c.AddNode(name, nodes[i%2+1])
+			c.ReplicateToN(i%3 + 1)
+			c.SetReplicateMethod(ReplicationMethod(i % 2))
+			c.ReplaceNode(name, nodes[i%2+1])
+			c.SetNode(name, nodes[i%2+1])
+			if i%3 == 0 {
+				assert.NoError(t, c.RemoveNode(name))
+			}
+		}
+	}()
+
+	var ops sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		ops.Add(1)
+		go func(i int) {
+			defer ops.Done()
+			var s string
+			for j := 0; j < 200; j++ {
+				key := fmt.Sprintf("key-%d", j%10)
+				assert.NoError(t, c.Set(key, "foo"))
+				if err := c.Get(key, &s); err != nil {
+					assert.Equal(t, kv.ErrNotFound, err)
+				}
+				c.Del(key)
+				assert.NoError(t, c.SetMulti(map[string]interface{}{key: "foo", "multi": "bar"}))
+				m := map[string]string{}
+				assert.NoError(t, c.GetMulti([]string{key, "multi"}, m))
+				c.DelMulti([]string{key})
+			}
+		}(i)
+	}
+	ops.Wait()
+	close(stop)
+	wg.Wait()
+}
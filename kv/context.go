@@ -0,0 +1,69 @@
+package kv
+
+import "golang.org/x/net/context"
+
+// contextStore wraps a Store which doesn't support contexts, checking the context before each call
+type contextStore struct {
+	s Store
+}
+
+// boundStore wraps a ContextStore, calling it with a fixed context
+type boundStore struct {
+	cs  ContextStore
+	ctx context.Context
+}
+
+// ContextAdapter returns a ContextStore for the given Store. If the store already implements
+// the ContextStore interface it's returned as is. Otherwise the context is checked before each
+// call to the underlying store, which cannot be interrupted once it has started.
+func ContextAdapter(s Store) ContextStore {
+	if cs, ok := s.(ContextStore); ok {
+		return cs
+	}
+	return &contextStore{s}
+}
+
+// StoreAdapter returns a Store which calls the given ContextStore with ctx. It's useful for
+// handing a context aware store to code which only knows about the Store interface.
+func StoreAdapter(cs ContextStore, ctx context.Context) Store {
+	return &boundStore{cs, ctx}
+}
+
+// SetContext implements the "kv.ContextStore".SetContext() interface
+func (s *contextStore) SetContext(ctx context.Context, key string, value interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.s.Set(key, value)
+}
+
+// GetContext implements the "kv.ContextStore".GetContext() interface
+func (s *contextStore) GetContext(ctx context.Context, key string, dstVal interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.s.Get(key, dstVal)
+}
+
+// DelContext implements the "kv.ContextStore".DelContext() interface
+func (s *contextStore) DelContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.s.Del(key)
+}
+
+// Set implements the "kv.Store".Set() interface
+func (s *boundStore) Set(key string, value interface{}) error {
+	return s.cs.SetContext(s.ctx, key, value)
+}
+
+// Get implements the "kv.Store".Get() interface
+func (s *boundStore) Get(key string, dstVal interface{}) error {
+	return s.cs.GetContext(s.ctx, key, dstVal)
+}
+
+// Del implements the "kv.Store".Del() interface
+func (s *boundStore) Del(key string) error {
+	return s.cs.DelContext(s.ctx, key)
+}
@@ -0,0 +1,71 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// mapStore is a minimal Store used for testing the adapters
+type mapStore map[string]interface{}
+
+func (m mapStore) Set(key string, value interface{}) error {
+	m[key] = value
+	return nil
+}
+
+func (m mapStore) Get(key string, dstVal interface{}) error {
+	v, ok := m[key]
+	if !ok {
+		return ErrNotFound
+	}
+	p, ok := dstVal.(*string)
+	if !ok {
+		return ErrInvalidDstVal
+	}
+	*p = v.(string)
+	return nil
+}
+
+func (m mapStore) Del(key string) error {
+	delete(m, key)
+	return nil
+}
+
+func TestContextAdapter(t *testing.T) {
+	var s string
+	m := mapStore{}
+	cs := ContextAdapter(m)
+	ctx := context.Background()
+	assert.NoError(t, cs.SetContext(ctx, "foo", "bar"))
+	assert.NoError(t, cs.GetContext(ctx, "foo", &s))
+	assert.Equal(t, "bar", s)
+	assert.NoError(t, cs.DelContext(ctx, "foo"))
+	assert.Equal(t, ErrNotFound, cs.GetContext(ctx, "foo", &s))
+}
+
+func TestContextAdapterCanceled(t *testing.T) {
+	var s string
+	m := mapStore{"foo": "bar"}
+	cs := ContextAdapter(m)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, cs.SetContext(ctx, "bar", "baz"))
+	assert.Equal(t, context.Canceled, cs.GetContext(ctx, "foo", &s))
+	assert.Equal(t, context.Canceled, cs.DelContext(ctx, "foo"))
+	assert.Len(t, m, 1)
+}
+
+func TestStoreAdapter(t *testing.T) {
+	var s string
+	m := mapStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+	st := StoreAdapter(ContextAdapter(m), ctx)
+	assert.NoError(t, st.Set("foo", "bar"))
+	assert.NoError(t, st.Get("foo", &s))
+	assert.Equal(t, "bar", s)
+	assert.NoError(t, st.Del("foo"))
+	cancel()
+	assert.Equal(t, context.Canceled, st.Set("foo", "bar"))
+}
@@ -3,8 +3,16 @@ package kv
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"golang.org/x/net/context"
 )
 
+// NoExpiration is the TTL reported for keys which never expire
+const NoExpiration time.Duration = -1
+
 // Errors
 var (
 	ErrNotFound  = errors.New("not found")
@@ -14,6 +22,11 @@ var (
 	ErrInvalidDstVal = errors.New("cannot set dst value")
 	// ErrInvalidDataFormat is returned when the data retrieved from a storage engine is not in the expected format
 	ErrInvalidDataFormat = errors.New("Invalid data format")
+	// ErrVersionMismatch is returned by "kv.CAS" operations when the key was changed, created or
+	// deleted since the expected version was read.
+	ErrVersionMismatch = errors.New("version mismatch")
+	// ErrReadOnly is returned when writing within a read only transaction
+	ErrReadOnly = errors.New("transaction is read only")
 )
 
 // Key turns Stringer funcs, byte slices, pointers to strings, etc., into string keys
@@ -42,11 +55,100 @@ type Store interface {
 	Del(key string) error
 }
 
+// ContextStore defines a permanent key/value store whose operations honor the cancellation
+// and deadline of the given context.
+type ContextStore interface {
+	SetContext(ctx context.Context, key string, value interface{}) error
+	GetContext(ctx context.Context, key string, dstVal interface{}) error
+	DelContext(ctx context.Context, key string) error
+}
+
+// Expirer defines a store whose keys can expire. Getting an expired key returns ErrCacheMiss.
+// A ttl <= 0 means the key never expires.
+type Expirer interface {
+	SetWithTTL(key string, value interface{}, ttl time.Duration) error
+	// TTL returns the time remaining until the key expires, or NoExpiration
+	TTL(key string) (time.Duration, error)
+	// Touch resets the expiration of an existing key without changing its value
+	Touch(key string, ttl time.Duration) error
+}
+
+// Sweeper defines a store which can physically remove all its expired keys
+type Sweeper interface {
+	Sweep() error
+}
+
+// Batcher defines a store which can set, get and delete many keys at once, usually much faster
+// than doing so one key at a time.
+type Batcher interface {
+	SetMulti(items map[string]interface{}) error
+	// GetMulti gets the values of keys into dst, which must be a map with string keys, or a slice
+	// or pointer to a slice with one element per key. Missing and expired keys are left out of a
+	// map and left as the zero value in a slice.
+	GetMulti(keys []string, dst interface{}) error
+	DelMulti(keys []string) error
+}
+
+// CAS defines a store supporting optimistic concurrency through compare-and-swap operations.
+// Versions are opaque values which change whenever a key's value changes, and are never zero.
+// Conflicting operations return ErrVersionMismatch.
+type CAS interface {
+	GetWithVersion(key string, dstVal interface{}) (version uint64, err error)
+	// SetIfVersion sets the value only if the key exists and is still at the given version
+	SetIfVersion(key string, value interface{}, version uint64) (newVersion uint64, err error)
+	// SetIfNotExists sets the value only if the key doesn't exist or has expired
+	SetIfNotExists(key string, value interface{}) (version uint64, err error)
+	// DelIfVersion deletes the key only if it exists and is still at the given version
+	DelIfVersion(key string, version uint64) error
+}
+
+// Tx is a store as seen from within a transaction
+type Tx interface {
+	Set(key string, value interface{}) error
+	Get(key string, dstVal interface{}) error
+	Del(key string) error
+}
+
+// Transactional defines a store which can read and write multiple keys atomically. If fn returns
+// an error the transaction is rolled back and the error returned. Writing within a View transaction
+// returns ErrReadOnly. Stores which can't provide atomic transactions don't implement this interface
+// rather than emulating it, so check for it with a compile time assertion where atomicity matters.
+type Transactional interface {
+	Update(fn func(tx Tx) error) error
+	View(fn func(tx Tx) error) error
+}
+
+// Iterator iterates over the key/value pairs of a store. Call Next() before reading the first pair,
+// and always Close() it when done since it may hold resources like transactions or file handles.
+type Iterator interface {
+	Next() bool
+	Key() string
+	Value(dstVal interface{}) error
+	Err() error
+	Close() error
+}
+
+// Scanner defines a store which can stream its key/value pairs without loading all keys in memory.
+// Scan iterates over the keys with the given prefix within the range [start, end). Empty start or
+// end strings leave that side of the range unbounded. Ordered stores iterate in ascending byte order.
+type Scanner interface {
+	Scan(prefix, start, end string) Iterator
+}
+
 // Clearer defines an interface which store can clear all it's key/value pairs at once.
 type Clearer interface {
 	Clear() error
 }
 
+// RawStore defines a store which can get and set values already encoded by its codec. It lets
+// values be copied between stores sharing a codec without decoding and re-encoding them.
+type RawStore interface {
+	Store
+	GetRaw(key string) ([]byte, error)
+	SetRaw(key string, b []byte) error
+	Codec() codec.Codec
+}
+
 // KeyList defines an interface for announcing all keys currently set
 type KeyList interface {
 	Keys() []string
@@ -60,6 +162,11 @@ type Datastore interface {
 	Transfer(Store) error
 }
 
+// InRange returns true if key has the prefix and is within [start, end) as described in Scanner
+func InRange(key, prefix, start, end string) bool {
+	return strings.HasPrefix(key, prefix) && key >= start && (end == "" || key < end)
+}
+
 // KeyProvider is an interface which can describe it's own key. It's used for getting/setting
 // key/value pairs without a directly supplied key string. Instead, the supplied interface
 // can announce it's own key, and that's used in getting/setting.
@@ -23,3 +23,12 @@ func TestKey(t *testing.T) {
 	assert.Equal(t, "foobar", Key(&b))
 	assert.Equal(t, "1.23", Key(float64(1.23)))
 }
+
+func TestInRange(t *testing.T) {
+	assert.True(t, InRange("foo", "", "", ""))
+	assert.True(t, InRange("foo", "fo", "", ""))
+	assert.False(t, InRange("foo", "ba", "", ""))
+	assert.True(t, InRange("foo", "", "foo", "fop"))
+	assert.False(t, InRange("foo", "", "fop", ""))
+	assert.False(t, InRange("foo", "", "", "foo"))
+}
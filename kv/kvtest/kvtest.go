@@ -0,0 +1,219 @@
+// Package kvtest implements conformance tests shared by the drivers. Each test takes a func
+// returning a new, empty store, which is responsible for closing and removing the store once the
+// test completes, usually with t.Cleanup(). Drivers only keep the tests of their own behavior.
+package kvtest
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradberger/gokv/kv"
+	"github.com/stretchr/testify/assert"
+)
+
+// NewStore returns a new, empty store for a test
+type NewStore func(t *testing.T) kv.Store
+
+type testStruct struct {
+	Foo string
+}
+
+// CAS tests the "kv.CAS" interface of the stores. Stores which implement "kv.Expirer" are also
+// checked to treat expired keys as missing.
+func CAS(t *testing.T, newStore NewStore) {
+	t.Run("Versions", func(t *testing.T) {
+		var v testStruct
+		s := newStore(t)
+		db := s.(kv.CAS)
+
+		ver, err := db.SetIfNotExists("foo", testStruct{"foo"})
+		assert.NoError(t, err)
+		_, err = db.SetIfNotExists("foo", testStruct{"bar"})
+		assert.Equal(t, kv.ErrVersionMismatch, err)
+
+		getVer, err := db.GetWithVersion("foo", &v)
+		assert.NoError(t, err)
+		assert.Equal(t, ver, getVer)
+		assert.EqualValues(t, testStruct{"foo"}, v)
+
+		newVer, err := db.SetIfVersion("foo", testStruct{"bar"}, ver)
+		assert.NoError(t, err)
+		assert.NotEqual(t, ver, newVer)
+		_, err = db.SetIfVersion("foo", testStruct{"foo"}, ver)
+		assert.Equal(t, kv.ErrVersionMismatch, err)
+		_, err = db.SetIfVersion("bar", testStruct{"foo"}, ver)
+		assert.Equal(t, kv.ErrVersionMismatch, err)
+
+		assert.Equal(t, kv.ErrVersionMismatch, db.DelIfVersion("foo", ver))
+		assert.NoError(t, db.DelIfVersion("foo", newVer))
+		assert.Equal(t, kv.ErrVersionMismatch, db.DelIfVersion("foo", newVer))
+		_, err = db.GetWithVersion("foo", &v)
+		assert.Equal(t, kv.ErrNotFound, err)
+
+		// Expired keys don't exist as far as CAS is concerned
+		if exp, ok := s.(kv.Expirer); ok {
+			assert.NoError(t, exp.SetWithTTL("baz", testStruct{"foo"}, time.Millisecond))
+			time.Sleep(5 * time.Millisecond)
+			_, err = db.GetWithVersion("baz", &v)
+			assert.Equal(t, kv.ErrCacheMiss, err)
+			_, err = db.SetIfNotExists("baz", testStruct{"bar"})
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		s := newStore(t)
+		db := s.(kv.CAS)
+		_, err := db.SetIfNotExists("counter", 0)
+		assert.NoError(t, err)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					var n int
+					ver, err := db.GetWithVersion("counter", &n)
+					if !assert.NoError(t, err) {
+						return
+					}
+					if _, err = db.SetIfVersion("counter", n+1, ver); err != kv.ErrVersionMismatch {
+						assert.NoError(t, err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		var n int
+		assert.NoError(t, s.Get("counter", &n))
+		assert.Equal(t, 20, n)
+	})
+}
+
+// move moves amount from one balance to another within the transaction
+func move(tx kv.Tx, from, to string, amount int) error {
+	var a, b int
+	if err := tx.Get(from, &a); err != nil {
+		return err
+	}
+	if err := tx.Get(to, &b); err != nil {
+		return err
+	}
+	if a < amount {
+		return errors.New("insufficient funds")
+	}
+	if err := tx.Set(from, a-amount); err != nil {
+		return err
+	}
+	return tx.Set(to, b+amount)
+}
+
+// Transactional tests the "kv.Transactional" interface of the stores
+func Transactional(t *testing.T, newStore NewStore) {
+	t.Run("Update", func(t *testing.T) {
+		var a, b int
+		s := newStore(t)
+		db := s.(kv.Transactional)
+		assert.NoError(t, s.Set("a", 100))
+		assert.NoError(t, s.Set("b", 0))
+
+		assert.NoError(t, db.Update(func(tx kv.Tx) error {
+			return move(tx, "a", "b", 60)
+		}))
+		assert.EqualError(t, db.Update(func(tx kv.Tx) error {
+			return move(tx, "a", "b", 60)
+		}), "insufficient funds")
+
+		// A failed transaction shouldn't leave partial writes behind
+		assert.Error(t, db.Update(func(tx kv.Tx) error {
+			if err := tx.Set("a", 0); err != nil {
+				return err
+			}
+			assert.NoError(t, tx.Get("a", &a))
+			assert.Equal(t, 0, a)
+			assert.NoError(t, tx.Del("b"))
+			return errors.New("rollback")
+		}))
+
+		assert.NoError(t, s.Get("a", &a))
+		assert.NoError(t, s.Get("b", &b))
+		assert.Equal(t, 40, a)
+		assert.Equal(t, 60, b)
+	})
+
+	t.Run("View", func(t *testing.T) {
+		var a int
+		s := newStore(t)
+		db := s.(kv.Transactional)
+		assert.NoError(t, s.Set("a", 100))
+		assert.NoError(t, db.View(func(tx kv.Tx) error {
+			assert.Equal(t, kv.ErrReadOnly, tx.Set("a", 0))
+			assert.Equal(t, kv.ErrReadOnly, tx.Del("a"))
+			assert.Equal(t, kv.ErrNotFound, tx.Get("b", &a))
+			return tx.Get("a", &a)
+		}))
+		assert.Equal(t, 100, a)
+	})
+}
+
+// ScanOptions describes how the stores passed to Scan iterate
+type ScanOptions struct {
+	// Unordered is set for stores which don't iterate in ascending key order. Keys are sorted
+	// before they are compared.
+	Unordered bool
+
+	// SetExpired sets the key to the value so that it has already expired, to check that expired
+	// keys are skipped. Expiration isn't tested if it's nil.
+	SetExpired func(t *testing.T, s kv.Store, key string, value interface{})
+}
+
+// ExpireSoon is a ScanOptions.SetExpired func for "kv.Expirer" stores with millisecond precision.
+// It sets the key with a TTL of a millisecond and waits for it to expire.
+func ExpireSoon(t *testing.T, s kv.Store, key string, value interface{}) {
+	assert.NoError(t, s.(kv.Expirer).SetWithTTL(key, value, time.Millisecond))
+	time.Sleep(5 * time.Millisecond)
+}
+
+// Scan tests the "kv.Scanner" interface of the stores
+func Scan(t *testing.T, newStore NewStore, opts ScanOptions) {
+	s := newStore(t)
+	db := s.(kv.Scanner)
+	for _, key := range []string{"a1", "a2", "a3", "b1", "c1"} {
+		assert.NoError(t, s.Set(key, testStruct{key}))
+	}
+	if opts.SetExpired != nil {
+		opts.SetExpired(t, s, "a4", testStruct{"a4"})
+	}
+
+	scanKeys := func(it kv.Iterator) []string {
+		keys := []string{}
+		for it.Next() {
+			var v testStruct
+			assert.NoError(t, it.Value(&v))
+			assert.Equal(t, it.Key(), v.Foo)
+			keys = append(keys, it.Key())
+		}
+		assert.NoError(t, it.Err())
+		assert.NoError(t, it.Close())
+		if opts.Unordered {
+			sort.Strings(keys)
+		}
+		return keys
+	}
+	assert.Equal(t, []string{"a1", "a2", "a3", "b1", "c1"}, scanKeys(db.Scan("", "", "")))
+	assert.Equal(t, []string{"a1", "a2", "a3"}, scanKeys(db.Scan("a", "", "")))
+	assert.Equal(t, []string{"a2", "a3"}, scanKeys(db.Scan("a", "a2", "")))
+	assert.Equal(t, []string{"a2"}, scanKeys(db.Scan("a", "a2", "a3")))
+	assert.Equal(t, []string{"a3", "b1"}, scanKeys(db.Scan("", "a3", "c")))
+	assert.Equal(t, []string{}, scanKeys(db.Scan("d", "", "")))
+
+	it := db.Scan("", "", "")
+	assert.NoError(t, it.Close())
+	assert.False(t, it.Next())
+}
@@ -0,0 +1,77 @@
+package kv
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// keyLockStripes is the number of mutexes a KeyLock spreads keys across
+const keyLockStripes = 256
+
+// KeyLock serializes operations on individual keys for drivers whose backends don't offer
+// atomic read-modify-write operations. Keys are hashed onto a fixed set of mutexes, so
+// unrelated keys can occasionally contend. The zero value is ready to use.
+type KeyLock struct {
+	mu [keyLockStripes]sync.Mutex
+}
+
+// Lock locks the given key
+func (l *KeyLock) Lock(key string) {
+	l.mu[stripe(key)].Lock()
+}
+
+// Unlock unlocks the given key
+func (l *KeyLock) Unlock(key string) {
+	l.mu[stripe(key)].Unlock()
+}
+
+// LockMulti locks all the given keys. Stripes are always locked in the same order so
+// concurrent calls with overlapping keys can't deadlock.
+func (l *KeyLock) LockMulti(keys []string) {
+	for _, i := range stripes(keys) {
+		l.mu[i].Lock()
+	}
+}
+
+// UnlockMulti unlocks all the given keys
+func (l *KeyLock) UnlockMulti(keys []string) {
+	for _, i := range stripes(keys) {
+		l.mu[i].Unlock()
+	}
+}
+
+// LockAll locks every key, waiting for operations on any key to finish
+func (l *KeyLock) LockAll() {
+	for i := range l.mu {
+		l.mu[i].Lock()
+	}
+}
+
+// UnlockAll unlocks every key
+func (l *KeyLock) UnlockAll() {
+	for i := range l.mu {
+		l.mu[i].Unlock()
+	}
+}
+
+// stripes returns the sorted, unique stripes of keys
+func stripes(keys []string) []int {
+	seen := make(map[int]bool, len(keys))
+	idx := make([]int, 0, len(keys))
+	for _, key := range keys {
+		i := int(stripe(key))
+		if !seen[i] {
+			seen[i] = true
+			idx = append(idx, i)
+		}
+	}
+	sort.Ints(idx)
+	return idx
+}
+
+func stripe(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() % keyLockStripes
+}
@@ -0,0 +1,67 @@
+package kv
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyLock(t *testing.T) {
+	var l KeyLock
+	var wg sync.WaitGroup
+	ct := map[string]int{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Lock("foo")
+			ct["foo"]++
+			l.Unlock("foo")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 100, ct["foo"])
+	assert.Equal(t, stripe("foo"), stripe("foo"))
+}
+
+func TestKeyLockMulti(t *testing.T) {
+	var l KeyLock
+	var wg sync.WaitGroup
+	ct := 0
+	for i := 0; i < 100; i++ {
+		keys := []string{"foo", "bar", "baz", "foo"}
+		if i%2 == 0 {
+			keys = []string{"baz", "bar", "foo"}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.LockMulti(keys)
+			ct++
+			l.UnlockMulti(keys)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 100, ct)
+	assert.Len(t, stripes([]string{"foo", "foo"}), 1)
+}
+
+func TestKeyLockAll(t *testing.T) {
+	var l KeyLock
+	l.LockAll()
+	locked := make(chan bool)
+	go func() {
+		l.Lock("foo")
+		locked <- true
+		l.Unlock("foo")
+	}()
+	select {
+	case <-locked:
+		t.Fatal("key locked while all keys were locked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	l.UnlockAll()
+	assert.True(t, <-locked)
+}
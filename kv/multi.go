@@ -0,0 +1,96 @@
+package kv
+
+import "reflect"
+
+// FillMulti implements the dst handling of "kv.Batcher".GetMulti() for drivers. The get func
+// is called for each key with its index and a pointer to a new value of the element type of
+// dst (a pointer to the pointed to type when the elements are pointers), which it should
+// decode the key's value into. If get returns ErrNotFound or ErrCacheMiss the key is skipped,
+// any other error is returned immediately.
+func FillMulti(keys []string, dst interface{}, get func(i int, key string, dstVal interface{}) error) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+		v = v.Elem()
+		if v.Len() != len(keys) && v.CanSet() {
+			v.Set(reflect.MakeSlice(v.Type(), len(keys), len(keys)))
+		}
+	}
+
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		if v.IsNil() {
+			return ErrInvalidDstVal
+		}
+	case v.Kind() == reflect.Slice:
+		if v.Len() != len(keys) {
+			return ErrInvalidDstVal
+		}
+	default:
+		return ErrInvalidDstVal
+	}
+
+	elemType := v.Type().Elem()
+	for i, key := range keys {
+		elem := newElem(elemType)
+		if err := get(i, key, elem.Interface()); err != nil {
+			if err == ErrNotFound || err == ErrCacheMiss {
+				continue
+			}
+			return err
+		}
+		if elemType.Kind() != reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if v.Kind() == reflect.Map {
+			v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+		} else {
+			v.Index(i).Set(elem)
+		}
+	}
+	return nil
+}
+
+// newElem returns a pointer to a new value of t, or of the type t points to if it's a pointer
+func newElem(t reflect.Type) reflect.Value {
+	if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem())
+	}
+	return reflect.New(t)
+}
+
+// SetMulti sets all items in the store, using a single batch if the store implements Batcher
+func SetMulti(s Store, items map[string]interface{}) error {
+	if b, ok := s.(Batcher); ok {
+		return b.SetMulti(items)
+	}
+	for key, val := range items {
+		if err := s.Set(key, val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMulti gets all keys from the store into dst, using a single batch if the store implements
+// Batcher. See "kv.Batcher".GetMulti() for the allowed types of dst.
+func GetMulti(s Store, keys []string, dst interface{}) error {
+	if b, ok := s.(Batcher); ok {
+		return b.GetMulti(keys, dst)
+	}
+	return FillMulti(keys, dst, func(i int, key string, dstVal interface{}) error {
+		return s.Get(key, dstVal)
+	})
+}
+
+// DelMulti deletes all keys from the store, using a single batch if the store implements Batcher
+func DelMulti(s Store, keys []string) error {
+	if b, ok := s.(Batcher); ok {
+		return b.DelMulti(keys)
+	}
+	for _, key := range keys {
+		if err := s.Del(key); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package kv
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFillMultiMap(t *testing.T) {
+	m := mapStore{"foo": "bar", "bar": "baz"}
+	dst := map[string]string{}
+	assert.NoError(t, GetMulti(m, []string{"foo", "bar", "baz"}, dst))
+	assert.Equal(t, map[string]string{"foo": "bar", "bar": "baz"}, dst)
+
+	ptrs := map[string]*string{}
+	assert.NoError(t, GetMulti(m, []string{"foo", "baz"}, ptrs))
+	assert.Len(t, ptrs, 1)
+	assert.Equal(t, "bar", *ptrs["foo"])
+}
+
+func TestFillMultiSlice(t *testing.T) {
+	m := mapStore{"foo": "bar", "bar": "baz"}
+	var dst []string
+	assert.NoError(t, GetMulti(m, []string{"foo", "baz", "bar"}, &dst))
+	assert.Equal(t, []string{"bar", "", "baz"}, dst)
+
+	ptrs := make([]*string, 2)
+	assert.NoError(t, GetMulti(m, []string{"baz", "bar"}, ptrs))
+	assert.Nil(t, ptrs[0])
+	assert.Equal(t, "baz", *ptrs[1])
+}
+
+func TestFillMultiErr(t *testing.T) {
+	var s string
+	var nilMap map[string]string
+	keys := []string{"foo"}
+	get := func(i int, key string, dstVal interface{}) error { return nil }
+	assert.Equal(t, ErrInvalidDstVal, FillMulti(keys, &s, get))
+	assert.Equal(t, ErrInvalidDstVal, FillMulti(keys, nilMap, get))
+	assert.Equal(t, ErrInvalidDstVal, FillMulti(keys, []string{}, get))
+	assert.Equal(t, ErrInvalidDstVal, FillMulti(keys, map[int]string{}, get))
+
+	testErr := errors.New("test error")
+	assert.Equal(t, testErr, FillMulti(keys, map[string]string{}, func(i int, key string, dstVal interface{}) error {
+		return testErr
+	}))
+}
+
+func TestSetDelMulti(t *testing.T) {
+	m := mapStore{}
+	assert.NoError(t, SetMulti(m, map[string]interface{}{"foo": "bar", "bar": "baz"}))
+	assert.Len(t, m, 2)
+	assert.NoError(t, DelMulti(m, []string{"foo", "bar", "baz"}))
+	assert.Len(t, m, 0)
+}
@@ -0,0 +1,40 @@
+package kv
+
+import "github.com/bradberger/gokv/codec"
+
+// Options holds the settings drivers have in common. Drivers accept them as Option funcs passed to
+// their constructors.
+type Options struct {
+	// Codec encodes and decodes the values of the store. If it's not set, drivers fall back to
+	// their package level Codec.
+	Codec codec.Codec
+}
+
+// Option sets one of the Options of a store
+type Option func(*Options)
+
+// WithCodec sets the codec a store uses to encode and decode values, so stores of the same
+// driver can use different encodings side by side
+func WithCodec(c codec.Codec) Option {
+	return func(o *Options) {
+		o.Codec = c
+	}
+}
+
+// NewOptions returns the Options set by opts
+func NewOptions(opts ...Option) Options {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// CodecOr returns the codec of the options, or fallback if it's not set. Drivers pass their
+// package level Codec, so changing it still affects stores created without a codec.
+func (o Options) CodecOr(fallback codec.Codec) codec.Codec {
+	if o.Codec.Marshal == nil || o.Codec.Unmarshal == nil {
+		return fallback
+	}
+	return o.Codec
+}
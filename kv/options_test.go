@@ -0,0 +1,22 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions(t *testing.T) {
+	o := NewOptions()
+	assert.True(t, codec.Equal(codec.Gob, o.CodecOr(codec.Gob)))
+
+	o = NewOptions(WithCodec(codec.JSON))
+	assert.True(t, codec.Equal(codec.JSON, o.CodecOr(codec.Gob)))
+
+	o = NewOptions(WithCodec(codec.JSON), WithCodec(codec.XML))
+	assert.True(t, codec.Equal(codec.XML, o.CodecOr(codec.Gob)))
+
+	o = NewOptions(WithCodec(codec.Codec{Marshal: codec.JSON.Marshal}))
+	assert.True(t, codec.Equal(codec.Gob, o.CodecOr(codec.Gob)))
+}
@@ -0,0 +1,49 @@
+package kv
+
+import (
+	"fmt"
+
+	"github.com/bradberger/gokv/codec"
+)
+
+// Reencrypt re-encrypts all values of a store using a codec.Encrypt codec with the primary key of
+// keyring, so older keys can be retired once it returns. Values are re-encrypted as raw bytes without
+// being decoded, expiration times are kept, and values already encrypted with the primary key are
+// left untouched. Keys which are deleted or expire meanwhile are skipped, but values written
+// concurrently may be overwritten with their previous value, so writes should be paused while it runs.
+//
+// codec.Encrypt must be the outermost codec of the store, since the stored bytes are re-encrypted
+// as they are. Stores wrapping it in another codec, like codec.Compress or codec.Auto, fail on the
+// first value with an error wrapping codec.ErrNotEncrypted.
+func Reencrypt(s interface {
+	RawStore
+	KeyList
+}, keyring *codec.Keyring) error {
+	for _, key := range s.Keys() {
+		b, err := s.GetRaw(key)
+		if err == ErrNotFound || err == ErrCacheMiss {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data, exp := codec.SplitExpiry(b)
+		if codec.Expired(exp) {
+			continue
+		}
+		data, changed, err := keyring.Reencrypt(data)
+		if err == codec.ErrNotEncrypted {
+			return fmt.Errorf("kv: reencrypting %q: %w, codec.Encrypt must be the outermost codec", key, err)
+		}
+		if err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		if err := s.SetRaw(key, codec.WithExpiry(data, exp)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
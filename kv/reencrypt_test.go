@@ -0,0 +1,71 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReencrypt(t *testing.T) {
+	var s string
+	k := codec.NewKeyring()
+	assert.NoError(t, k.Add(1, codec.AESGCM, bytes.Repeat([]byte{1}, 32)))
+	r := newRawStore(codec.Encrypt(codec.JSON, k))
+	assert.NoError(t, r.Set("foo", "bar"))
+	b, err := k.Seal([]byte(`"baz"`))
+	assert.NoError(t, err)
+	exp := time.Now().Add(time.Minute).Round(0)
+	assert.NoError(t, r.SetRaw("bar", codec.WithExpiry(b, exp)))
+
+	assert.NoError(t, k.Add(2, codec.XChaCha20Poly1305, bytes.Repeat([]byte{2}, 32)))
+	assert.NoError(t, r.Set("baz", "qux"))
+	unchanged := r.m["baz"]
+	assert.NoError(t, Reencrypt(r, k))
+
+	for _, key := range []string{"foo", "bar", "baz"} {
+		b, keyExp := codec.SplitExpiry(r.m[key])
+		id, err := codec.KeyID(b)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), id)
+		if key == "bar" {
+			assert.True(t, exp.Equal(keyExp))
+		}
+	}
+	assert.Equal(t, unchanged, r.m["baz"])
+
+	// Values can be decrypted once the old key is gone
+	k2 := codec.NewKeyring()
+	assert.NoError(t, k2.Add(2, codec.XChaCha20Poly1305, bytes.Repeat([]byte{2}, 32)))
+	r.c = codec.Encrypt(codec.JSON, k2)
+	assert.NoError(t, r.Get("foo", &s))
+	assert.Equal(t, "bar", s)
+	b, _ = codec.SplitExpiry(r.m["bar"])
+	assert.NoError(t, r.c.Unmarshal(b, &s))
+	assert.Equal(t, "baz", s)
+}
+
+func TestReencryptErr(t *testing.T) {
+	k := codec.NewKeyring()
+	assert.NoError(t, k.Add(1, codec.AESGCM, bytes.Repeat([]byte{1}, 32)))
+	r := newRawStore(codec.JSON)
+	assert.NoError(t, r.Set("foo", "bar"))
+	err := Reencrypt(r, k)
+	assert.True(t, errors.Is(err, codec.ErrNotEncrypted))
+	assert.Contains(t, err.Error(), "outermost")
+
+	// Encrypt must be the outermost codec
+	r = newRawStore(codec.Compress(codec.Encrypt(codec.JSON, k), codec.Gzip, 0))
+	assert.NoError(t, r.Set("foo", "bar"))
+	err = Reencrypt(r, k)
+	assert.True(t, errors.Is(err, codec.ErrNotEncrypted))
+	assert.Contains(t, err.Error(), "outermost")
+
+	// Expired values are skipped
+	r = newRawStore(codec.JSON)
+	assert.NoError(t, r.SetRaw("foo", codec.WithExpiry([]byte(`"bar"`), time.Now().Add(-time.Minute))))
+	assert.NoError(t, Reencrypt(r, k))
+}
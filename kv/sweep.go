@@ -0,0 +1,30 @@
+package kv
+
+import "time"
+
+// SweepEvery calls s.Sweep() at the given interval in a background goroutine until the
+// returned stop func is called. Stop waits for a sweep in progress to finish. Errors from
+// individual sweeps are passed to onErr, which may be nil.
+func SweepEvery(s Sweeper, interval time.Duration, onErr func(error)) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.Sweep(); err != nil && onErr != nil {
+					onErr(err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,34 @@
+package kv
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type sweepCounter struct {
+	n int32
+}
+
+func (s *sweepCounter) Sweep() error {
+	atomic.AddInt32(&s.n, 1)
+	return errors.New("test error")
+}
+
+func TestSweepEvery(t *testing.T) {
+	var errCt int32
+	s := &sweepCounter{}
+	stop := SweepEvery(s, time.Millisecond, func(err error) {
+		atomic.AddInt32(&errCt, 1)
+	})
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	n := atomic.LoadInt32(&s.n)
+	assert.True(t, n > 0)
+	assert.Equal(t, n, atomic.LoadInt32(&errCt))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&s.n))
+}
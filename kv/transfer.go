@@ -0,0 +1,42 @@
+package kv
+
+import "github.com/bradberger/gokv/codec"
+
+// Transfer copies all key/value pairs of src into dst. It's the building block drivers use to
+// implement "kv.Datastore".Transfer(). If both stores implement RawStore with equal codecs the
+// encoded bytes are copied as is. Otherwise each value is decoded into an interface{} and set in
+// dst, which only works with codecs able to decode into an empty interface, like JSON and BSON.
+// Keys which are deleted or expire during the transfer are skipped.
+func Transfer(src interface {
+	Store
+	KeyList
+}, dst Store) error {
+	rawSrc, srcOk := src.(RawStore)
+	rawDst, dstOk := dst.(RawStore)
+	raw := srcOk && dstOk && codec.Equal(rawSrc.Codec(), rawDst.Codec())
+
+	for _, key := range src.Keys() {
+		if raw {
+			b, err := rawSrc.GetRaw(key)
+			if err == nil {
+				err = rawDst.SetRaw(key, b)
+			}
+			if err != nil && err != ErrNotFound && err != ErrCacheMiss {
+				return err
+			}
+			continue
+		}
+
+		var v interface{}
+		if err := src.Get(key, &v); err != nil {
+			if err == ErrNotFound || err == ErrCacheMiss {
+				continue
+			}
+			return err
+		}
+		if err := dst.Set(key, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package kv
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/stretchr/testify/assert"
+)
+
+// rawStore is a RawStore holding values encoded with its codec
+type rawStore struct {
+	c codec.Codec
+	m map[string][]byte
+}
+
+func newRawStore(c codec.Codec) *rawStore {
+	return &rawStore{c, map[string][]byte{}}
+}
+
+func (r *rawStore) Set(key string, value interface{}) error {
+	b, err := r.c.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return r.SetRaw(key, b)
+}
+
+func (r *rawStore) Get(key string, dstVal interface{}) error {
+	b, err := r.GetRaw(key)
+	if err != nil {
+		return err
+	}
+	return r.c.Unmarshal(b, dstVal)
+}
+
+func (r *rawStore) Del(key string) error {
+	delete(r.m, key)
+	return nil
+}
+
+func (r *rawStore) GetRaw(key string) ([]byte, error) {
+	b, ok := r.m[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return b, nil
+}
+
+func (r *rawStore) SetRaw(key string, b []byte) error {
+	r.m[key] = b
+	return nil
+}
+
+func (r *rawStore) Codec() codec.Codec {
+	return r.c
+}
+
+func (r *rawStore) Keys() []string {
+	keys := make([]string, 0, len(r.m))
+	for key := range r.m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestTransferRaw(t *testing.T) {
+	src, dst := newRawStore(codec.Gob), newRawStore(codec.Gob)
+	assert.NoError(t, src.Set("foo", "bar"))
+	assert.NoError(t, src.Set("bar", "baz"))
+	assert.NoError(t, Transfer(src, dst))
+	assert.Equal(t, src.m, dst.m)
+}
+
+func TestTransferDecode(t *testing.T) {
+	var s string
+	src, dst := newRawStore(codec.JSON), mapStore{}
+	assert.NoError(t, src.Set("foo", "bar"))
+	assert.NoError(t, Transfer(src, dst))
+	assert.NoError(t, dst.Get("foo", &s))
+	assert.Equal(t, "bar", s)
+
+	// Gob can't decode into an empty interface, and different codecs prevent a raw copy
+	src, jsonDst := newRawStore(codec.Gob), newRawStore(codec.JSON)
+	assert.NoError(t, src.Set("foo", "bar"))
+	assert.Error(t, Transfer(src, jsonDst))
+}
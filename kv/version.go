@@ -0,0 +1,15 @@
+package kv
+
+import "hash/fnv"
+
+// Version returns a "kv.CAS" version derived from an encoded value, for drivers which don't keep
+// a version counter. Since the version is a hash of the value, setting a key back to an identical
+// value restores its previous version, which is harmless because the value is the same.
+func Version(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	if v := h.Sum64(); v != 0 {
+		return v
+	}
+	return 1
+}
@@ -0,0 +1,13 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersion(t *testing.T) {
+	assert.Equal(t, Version([]byte("foo")), Version([]byte("foo")))
+	assert.NotEqual(t, Version([]byte("foo")), Version([]byte("bar")))
+	assert.NotEqual(t, uint64(0), Version(nil))
+}
@@ -0,0 +1,96 @@
+package kv
+
+import (
+	"strings"
+	"sync"
+)
+
+// watchBufferSize is the number of events buffered for each watcher. Once a watcher's buffer is
+// full further events for it are dropped, so slow consumers never block writers.
+const watchBufferSize = 128
+
+// EventType is the type of change an Event describes
+type EventType int
+
+const (
+	// EventPut indicates a key was set
+	EventPut EventType = iota
+	// EventDelete indicates a key was deleted, or removed after expiring
+	EventDelete
+)
+
+// Event describes a change to a key. For EventPut events, Value holds the value as encoded by
+// the store's codec. It's nil for EventDelete events.
+type Event struct {
+	Type  EventType
+	Key   string
+	Value []byte
+}
+
+// Watcher defines a store which notifies about changes to its keys. Watch returns a channel of
+// events for all keys with the given prefix, which can be a full key to watch a single key. The
+// channel is closed once cancel is called. Events are dropped rather than blocking writers if the
+// channel isn't drained quickly enough.
+type Watcher interface {
+	Watch(prefix string) (events <-chan Event, cancel func())
+}
+
+// Hub is an in-process notification hub which drivers use to implement Watcher by publishing an
+// event after each successful write. The zero value is ready to use.
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[*subscription]bool
+}
+
+type subscription struct {
+	prefix string
+	events chan Event
+}
+
+// Watch implements the "kv.Watcher".Watch() interface
+func (h *Hub) Watch(prefix string) (<-chan Event, func()) {
+	sub := &subscription{prefix, make(chan Event, watchBufferSize)}
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[*subscription]bool)
+	}
+	h.subs[sub] = true
+	h.mu.Unlock()
+
+	var once sync.Once
+	return sub.events, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, sub)
+			close(sub.events)
+			h.mu.Unlock()
+		})
+	}
+}
+
+// Publish sends the event to all watchers of a matching prefix without blocking
+func (h *Hub) Publish(e Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		if !strings.HasPrefix(e.Key, sub.prefix) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+		}
+	}
+}
+
+// Put publishes an EventPut event for the key
+func (h *Hub) Put(key string, value []byte) {
+	h.Publish(Event{EventPut, key, value})
+}
+
+// Delete publishes an EventDelete event for each key
+func (h *Hub) Delete(keys ...string) {
+	for _, key := range keys {
+		h.Publish(Event{Type: EventDelete, Key: key})
+	}
+}
@@ -0,0 +1,40 @@
+package kv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHub(t *testing.T) {
+	var h Hub
+	all, cancelAll := h.Watch("")
+	foo, cancelFoo := h.Watch("foo")
+
+	h.Put("foobar", []byte("baz"))
+	h.Delete("bar")
+
+	assert.Equal(t, Event{EventPut, "foobar", []byte("baz")}, <-all)
+	assert.Equal(t, Event{Type: EventDelete, Key: "bar"}, <-all)
+	assert.Equal(t, Event{EventPut, "foobar", []byte("baz")}, <-foo)
+
+	cancelFoo()
+	cancelFoo()
+	_, ok := <-foo
+	assert.False(t, ok)
+
+	h.Delete("foo")
+	assert.Equal(t, Event{Type: EventDelete, Key: "foo"}, <-all)
+	cancelAll()
+	assert.Len(t, h.subs, 0)
+}
+
+func TestHubSlowConsumer(t *testing.T) {
+	var h Hub
+	events, cancel := h.Watch("")
+	defer cancel()
+	for i := 0; i < watchBufferSize*2; i++ {
+		h.Put("foo", nil)
+	}
+	assert.Len(t, events, watchBufferSize)
+}
@@ -0,0 +1,353 @@
+package gokv
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/bradberger/gokv/codec"
+	"github.com/bradberger/gokv/kv"
+	"stathat.com/c/consistent"
+)
+
+// DefaultRebalanceStateKey is the key the progress of Rebalance is saved under if
+// RebalanceOptions.StateKey isn't set
+const DefaultRebalanceStateKey = "gokv.rebalance"
+
+// rebalanceCheckpointEvery is how many keys are scanned between saves of the rebalance state
+const rebalanceCheckpointEvery = 100
+
+// RebalanceOptions configures Client.Rebalance
+type RebalanceOptions struct {
+	// Move deletes keys from the nodes which no longer own them once their owners have them.
+	// Otherwise keys are only copied to their owners.
+	Move bool
+
+	// Retired holds nodes which were removed from the client, by name. Their keys are copied to
+	// their current owners, and deleted from them if Move is set.
+	Retired map[string]kv.Store
+
+	// Rate is the maximum number of keys scanned per second. Zero means no limit.
+	Rate int
+
+	// Progress is called after each key is scanned. It may be nil.
+	Progress func(RebalanceProgress)
+
+	// State stores the progress so an interrupted rebalance resumes where it stopped rather than
+	// scanning all nodes again. It should be a store outside of the client. The progress is saved
+	// every 100 keys and whenever Rebalance returns early, and deleted once it completes. It may be
+	// nil, in which case every rebalance starts from scratch.
+	State kv.Store
+
+	// StateKey is the key of the progress in State. Defaults to DefaultRebalanceStateKey.
+	StateKey string
+}
+
+// RebalanceProgress reports the progress of Client.Rebalance
+type RebalanceProgress struct {
+	// Node is the name of the node being scanned
+	Node string
+	// NodeKeys is the number of keys of the node being scanned
+	NodeKeys int
+	// NodeScanned is the number of keys of the node scanned so far, including keys scanned before
+	// the rebalance was resumed
+	NodeScanned int
+	// Nodes is the number of nodes to scan, including retired nodes
+	Nodes int
+	// NodesDone is the number of nodes scanned completely
+	NodesDone int
+	// Copied is the number of keys copied to a node
+	Copied int
+	// Deleted is the number of keys deleted from a node which no longer owns them
+	Deleted int
+}
+
+// rebalanceState is the progress of a rebalance saved to RebalanceOptions.State. It's only resumed
+// by a rebalance of the same topology.
+type rebalanceState struct {
+	Nodes    []string `json:"nodes"`
+	Retired  []string `json:"retired"`
+	Replicas int      `json:"replicas"`
+	Done     []string `json:"done"`
+	Node     string   `json:"node,omitempty"`
+	Key      string   `json:"key,omitempty"`
+}
+
+// sameTopology reports whether the state was saved by a rebalance of the same topology
+func (s *rebalanceState) sameTopology(o *rebalanceState) bool {
+	return equalStrings(s.Nodes, o.Nodes) && equalStrings(s.Retired, o.Retired) && s.Replicas == o.Replicas
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Rebalance moves keys to the nodes which own them after nodes were added or removed. Nodes which
+// implement "kv.KeyList" are scanned one key at a time, and each key is copied to those of its
+// owners which don't have it, in sorted order so an interrupted rebalance can be resumed. Nodes
+// which can't list their keys, like memcached, are left as they are.
+//
+// Keys are assigned to owners according to the nodes and replication factor at the time of the
+// call, so the client should be rebalanced again if its nodes change before it completes. Keys
+// which already exist on an owner are never overwritten, but a key written while it's being copied
+// may be overwritten by its previous value. Values are copied as encoded bytes if both nodes use the
+// same codec, keeping their expiration where possible, and decoded into an interface{} otherwise
+// like "kv".Transfer() does.
+//
+// If ctx is done Rebalance saves its progress and returns the context error.
+func (c *Client) Rebalance(ctx context.Context, opts RebalanceOptions) error {
+	c.mu.RLock()
+	nodes := make(map[string]kv.Store, len(c.nodes)+len(opts.Retired))
+	for name, node := range c.nodes {
+		nodes[name] = node
+	}
+	state := &rebalanceState{Nodes: c.ch.Members(), Replicas: c.replicas()}
+	c.mu.RUnlock()
+	sort.Strings(state.Nodes)
+	if len(state.Nodes) == 0 {
+		return consistent.ErrEmptyCircle
+	}
+	for name, node := range opts.Retired {
+		if _, ok := nodes[name]; !ok {
+			nodes[name] = node
+			state.Retired = append(state.Retired, name)
+		}
+	}
+	sort.Strings(state.Retired)
+
+	// The ring is rebuilt from the members so it can't change while keys are moved
+	ring := consistent.New()
+	ring.Set(state.Nodes)
+
+	if opts.StateKey == "" {
+		opts.StateKey = DefaultRebalanceStateKey
+	}
+	if opts.State != nil {
+		saved, err := loadRebalanceState(opts.State, opts.StateKey)
+		if err != nil {
+			return err
+		}
+		if saved != nil && saved.sameTopology(state) {
+			state = saved
+		}
+	}
+
+	var tick <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(opts.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	done := make(map[string]bool, len(state.Done))
+	for _, name := range state.Done {
+		done[name] = true
+	}
+	progress := RebalanceProgress{Nodes: len(state.Nodes) + len(state.Retired), NodesDone: len(state.Done)}
+	names := append(append([]string{}, state.Nodes...), state.Retired...)
+	for _, name := range names {
+		if done[name] {
+			continue
+		}
+		list, ok := nodes[name].(kv.KeyList)
+		if !ok {
+			state.Done = append(state.Done, name)
+			progress.NodesDone++
+			continue
+		}
+		keys := list.Keys()
+		sort.Strings(keys)
+		progress.Node, progress.NodeKeys, progress.NodeScanned = name, len(keys), 0
+		for _, key := range keys {
+			if name == state.Node && key <= state.Key {
+				progress.NodeScanned++
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return saveRebalanceState(opts, state, ctx.Err())
+			default:
+			}
+			if tick != nil {
+				select {
+				case <-ctx.Done():
+					return saveRebalanceState(opts, state, ctx.Err())
+				case <-tick:
+				}
+			}
+			owners, err := ring.GetN(key, state.Replicas)
+			if err != nil {
+				return saveRebalanceState(opts, state, err)
+			}
+			if err := rebalanceKey(nodes, name, key, owners, opts.Move, &progress); err != nil {
+				return saveRebalanceState(opts, state, err)
+			}
+			state.Node, state.Key = name, key
+			progress.NodeScanned++
+			if progress.NodeScanned%rebalanceCheckpointEvery == 0 {
+				if err := saveRebalanceState(opts, state, nil); err != nil {
+					return err
+				}
+			}
+			if opts.Progress != nil {
+				opts.Progress(progress)
+			}
+		}
+		state.Done = append(state.Done, name)
+		state.Node, state.Key = "", ""
+		progress.NodesDone++
+		if err := saveRebalanceState(opts, state, nil); err != nil {
+			return err
+		}
+	}
+
+	if opts.State != nil {
+		if err := opts.State.Del(opts.StateKey); err != nil && err != kv.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebalanceKey copies the key from the named node to those of its owners which don't have it,
+// and deletes it from the node if it isn't an owner and move is set
+func rebalanceKey(nodes map[string]kv.Store, name, key string, owners []string, move bool, progress *RebalanceProgress) error {
+	owner := false
+	for _, o := range owners {
+		if o == name {
+			owner = true
+			continue
+		}
+		copied, err := copyKey(nodes[name], nodes[o], key)
+		if err == kv.ErrNotFound || err == kv.ErrCacheMiss {
+			// The key was deleted or expired meanwhile
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if copied {
+			progress.Copied++
+		}
+	}
+	if owner || !move {
+		return nil
+	}
+	if err := nodes[name].Del(key); err != nil && err != kv.ErrNotFound {
+		return err
+	}
+	progress.Deleted++
+	return nil
+}
+
+// copyKey copies the key from src to dst unless dst already has it, and reports whether it was copied
+func copyKey(src, dst kv.Store, key string) (bool, error) {
+	if ok, err := hasKey(dst, key); ok || err != nil {
+		return false, err
+	}
+
+	rawSrc, srcOk := src.(kv.RawStore)
+	rawDst, dstOk := dst.(kv.RawStore)
+	if srcOk && dstOk && codec.Equal(rawSrc.Codec(), rawDst.Codec()) {
+		b, err := rawSrc.GetRaw(key)
+		if err != nil {
+			return false, err
+		}
+		if _, exp := codec.SplitExpiry(b); codec.Expired(exp) {
+			return false, kv.ErrCacheMiss
+		}
+		return true, rawDst.SetRaw(key, b)
+	}
+
+	var v interface{}
+	if err := src.Get(key, &v); err != nil {
+		return false, err
+	}
+	return true, setWithTTL(src, dst, key, v)
+}
+
+// hasKey reports whether the store has the key without decoding its value, which may not decode
+// into every type. Stores which are neither a "kv.Expirer" nor a "kv.RawStore" are read into an
+// empty struct, and any error but ErrNotFound and ErrCacheMiss means the key exists but its value
+// doesn't fit.
+func hasKey(s kv.Store, key string) (bool, error) {
+	var err error
+	switch s := s.(type) {
+	case kv.Expirer:
+		_, err = s.TTL(key)
+	case kv.RawStore:
+		var b []byte
+		if b, err = s.GetRaw(key); err == nil {
+			if _, exp := codec.SplitExpiry(b); codec.Expired(exp) {
+				err = kv.ErrCacheMiss
+			}
+		}
+	default:
+		if err = s.Get(key, &struct{}{}); err != nil && err != kv.ErrNotFound && err != kv.ErrCacheMiss {
+			return true, nil
+		}
+	}
+	if err == kv.ErrNotFound || err == kv.ErrCacheMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// setWithTTL sets the value in dst with the remaining TTL of the key in src, if both can expire keys
+func setWithTTL(src, dst kv.Store, key string, v interface{}) error {
+	expSrc, srcOk := src.(kv.Expirer)
+	expDst, dstOk := dst.(kv.Expirer)
+	if srcOk && dstOk {
+		ttl, err := expSrc.TTL(key)
+		if err != nil {
+			return err
+		}
+		if ttl != kv.NoExpiration {
+			return expDst.SetWithTTL(key, v, ttl)
+		}
+	}
+	return dst.Set(key, v)
+}
+
+// loadRebalanceState returns the saved rebalance state, or nil if there's none
+func loadRebalanceState(s kv.Store, key string) (*rebalanceState, error) {
+	var b []byte
+	if err := s.Get(key, &b); err != nil {
+		if err == kv.ErrNotFound || err == kv.ErrCacheMiss {
+			return nil, nil
+		}
+		return nil, err
+	}
+	state := &rebalanceState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		// A state which can't be read is discarded, since rebalancing from scratch is always safe
+		return nil, nil
+	}
+	return state, nil
+}
+
+// saveRebalanceState saves the state if the options have a State store, and returns err or the
+// error saving the state
+func saveRebalanceState(opts RebalanceOptions, state *rebalanceState, err error) error {
+	if opts.State == nil {
+		return err
+	}
+	b, jsonErr := json.Marshal(state)
+	if jsonErr == nil {
+		jsonErr = opts.State.Set(opts.StateKey, b)
+	}
+	if err != nil {
+		return err
+	}
+	return jsonErr
+}
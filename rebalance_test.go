@@ -0,0 +1,216 @@
+package gokv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bradberger/gokv/drivers/memory"
+	"github.com/bradberger/gokv/kv"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+type testStruct struct {
+	Foo string
+}
+
+// newRebalanceClient returns a client with two nodes holding n keys, each on a single node
+func newRebalanceClient(t *testing.T, n int) (*Client, []*memory.Store) {
+	nodes := []*memory.Store{memory.New(memory.Options{}), memory.New(memory.Options{}), memory.New(memory.Options{})}
+	c := New()
+	assert.NoError(t, c.AddNode("node-01", nodes[0]))
+	assert.NoError(t, c.AddNode("node-02", nodes[1]))
+	assert.NoError(t, c.ReplicateToN(1))
+	for i := 0; i < n; i++ {
+		assert.NoError(t, c.Set(fmt.Sprintf("key-%02d", i), "foo"))
+	}
+	return c, nodes
+}
+
+// assertBalanced checks that each of the n keys can be read and lives on a single node
+func assertBalanced(t *testing.T, c *Client, nodes []*memory.Store, n int) {
+	var s string
+	for i := 0; i < n; i++ {
+		assert.NoError(t, c.Get(fmt.Sprintf("key-%02d", i), &s))
+	}
+	assert.Equal(t, n, len(nodes[0].Keys())+len(nodes[1].Keys())+len(nodes[2].Keys()))
+}
+
+func TestRebalance(t *testing.T) {
+	var s string
+	c, nodes := newRebalanceClient(t, 50)
+	assert.NoError(t, c.AddNode("node-03", nodes[2]))
+
+	// Keys owned by the new node are missed until the client is rebalanced
+	missing := 0
+	for i := 0; i < 50; i++ {
+		if c.Get(fmt.Sprintf("key-%02d", i), &s) == kv.ErrNotFound {
+			missing++
+		}
+	}
+	assert.NotZero(t, missing)
+
+	var last RebalanceProgress
+	calls := 0
+	assert.NoError(t, c.Rebalance(context.Background(), RebalanceOptions{Move: true, Progress: func(p RebalanceProgress) {
+		last = p
+		calls++
+	}}))
+	// Keys moved to the new node are scanned again once it's its turn
+	assert.Equal(t, 50+missing, calls)
+	assert.Equal(t, missing, last.Copied)
+	assert.Equal(t, missing, last.Deleted)
+	assert.Equal(t, 3, last.Nodes)
+	assert.Equal(t, 2, last.NodesDone)
+	assert.Equal(t, last.NodeKeys, last.NodeScanned)
+	assert.Len(t, nodes[2].Keys(), missing)
+	assertBalanced(t, c, nodes, 50)
+
+	// Rebalancing a balanced client changes nothing
+	assert.NoError(t, c.Rebalance(context.Background(), RebalanceOptions{Move: true, Progress: func(p RebalanceProgress) {
+		last = p
+	}}))
+	assert.Equal(t, 0, last.Copied+last.Deleted)
+}
+
+func TestRebalanceCopy(t *testing.T) {
+	c, nodes := newRebalanceClient(t, 20)
+	assert.NoError(t, c.AddNode("node-03", nodes[2]))
+	assert.NoError(t, c.Rebalance(context.Background(), RebalanceOptions{}))
+	assert.Equal(t, 20, len(nodes[0].Keys())+len(nodes[1].Keys()))
+	assert.NotEmpty(t, nodes[2].Keys())
+}
+
+func TestRebalanceRetired(t *testing.T) {
+	c, nodes := newRebalanceClient(t, 20)
+	assert.NoError(t, c.RemoveNode("node-02"))
+	assert.NoError(t, c.Rebalance(context.Background(), RebalanceOptions{Move: true, Retired: map[string]kv.Store{"node-02": nodes[1]}}))
+	assert.Len(t, nodes[0].Keys(), 20)
+	assert.Len(t, nodes[1].Keys(), 0)
+}
+
+func TestRebalanceZeroCopy(t *testing.T) {
+	var v testStruct
+	nodes := []*memory.Store{memory.New(memory.Options{ZeroCopy: true}), memory.New(memory.Options{ZeroCopy: true})}
+	c := New()
+	assert.NoError(t, c.AddNode("node-01", nodes[0]))
+	assert.NoError(t, c.ReplicateToN(1))
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, c.Set(fmt.Sprintf("key-%02d", i), &testStruct{"foo"}))
+	}
+	assert.NoError(t, c.AddNode("node-02", nodes[1]))
+
+	// Keys already on their owner are kept, even though they can't be read into a []byte
+	var owned string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		if owners, _, err := c.owners(key); err == nil && owners[0] == nodes[1] {
+			owned = key
+			break
+		}
+	}
+	assert.NotEmpty(t, owned)
+	assert.NoError(t, nodes[1].Set(owned, &testStruct{"bar"}))
+
+	assert.NoError(t, c.Rebalance(context.Background(), RebalanceOptions{Move: true}))
+	assert.Equal(t, 20, len(nodes[0].Keys())+len(nodes[1].Keys()))
+	assert.NotEmpty(t, nodes[1].Keys())
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key-%02d", i)
+		assert.NoError(t, c.Get(key, &v))
+		if key == owned {
+			assert.Equal(t, testStruct{"bar"}, v)
+		} else {
+			assert.Equal(t, testStruct{"foo"}, v)
+		}
+	}
+}
+
+func TestHasKey(t *testing.T) {
+	// Plain stores are checked with a Get, and values which don't decode still exist
+	m := memory.New(memory.Options{ZeroCopy: true})
+	s := struct{ kv.Store }{m}
+	ok, err := hasKey(s, "foo")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, m.Set("foo", "bar"))
+	ok, err = hasKey(s, "foo")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = hasKey(m, "foo")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, m.SetWithTTL("foo", "bar", time.Nanosecond))
+	time.Sleep(time.Millisecond)
+	ok, err = hasKey(m, "foo")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestRebalanceExpiry(t *testing.T) {
+	var s string
+	c, nodes := newRebalanceClient(t, 0)
+	assert.NoError(t, c.RemoveNode("node-02"))
+	assert.NoError(t, nodes[1].SetWithTTL("foo", "bar", time.Hour))
+	assert.NoError(t, c.Rebalance(context.Background(), RebalanceOptions{Move: true, Retired: map[string]kv.Store{"node-02": nodes[1]}}))
+	assert.NoError(t, c.Get("foo", &s))
+	ttl, err := nodes[0].TTL("foo")
+	assert.NoError(t, err)
+	assert.True(t, ttl > 59*time.Minute && ttl <= time.Hour)
+}
+
+func TestRebalanceResume(t *testing.T) {
+	c, nodes := newRebalanceClient(t, 50)
+	assert.NoError(t, c.AddNode("node-03", nodes[2]))
+	state := memory.New(memory.Options{})
+
+	// Interrupt the rebalance after a few keys
+	ctx, cancel := context.WithCancel(context.Background())
+	scanned := 0
+	err := c.Rebalance(ctx, RebalanceOptions{Move: true, State: state, Progress: func(p RebalanceProgress) {
+		if scanned++; scanned == 5 {
+			cancel()
+		}
+	}})
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, state.Exists(DefaultRebalanceStateKey))
+
+	// The next rebalance continues after the last scanned key
+	var first *RebalanceProgress
+	assert.NoError(t, c.Rebalance(context.Background(), RebalanceOptions{Move: true, State: state, Progress: func(p RebalanceProgress) {
+		if first == nil {
+			first = &p
+		}
+		scanned++
+	}}))
+	assert.Equal(t, 6, first.NodeScanned)
+	assert.Equal(t, 50+len(nodes[2].Keys()), scanned)
+	assert.False(t, state.Exists(DefaultRebalanceStateKey))
+	assertBalanced(t, c, nodes, 50)
+
+	// Progress saved for another topology is discarded
+	assert.NoError(t, state.Set("rebalance", []byte(`{"nodes":["node-01"],"replicas":1,"done":["node-01"]}`)))
+	scanned = 0
+	assert.NoError(t, c.Rebalance(context.Background(), RebalanceOptions{State: state, StateKey: "rebalance", Progress: func(p RebalanceProgress) {
+		scanned++
+	}}))
+	assert.Equal(t, 50, scanned)
+}
+
+func TestRebalanceRate(t *testing.T) {
+	c, _ := newRebalanceClient(t, 10)
+	start := time.Now()
+	assert.NoError(t, c.Rebalance(context.Background(), RebalanceOptions{Rate: 200}))
+	assert.True(t, time.Since(start) >= 45*time.Millisecond)
+}
+
+func TestRebalanceErr(t *testing.T) {
+	assert.Error(t, New().Rebalance(context.Background(), RebalanceOptions{}))
+
+	// Nodes which can't list their keys are skipped
+	c := New()
+	assert.NoError(t, c.AddNode("node-01", struct{ kv.Store }{memory.New(memory.Options{})}))
+	assert.NoError(t, c.Rebalance(context.Background(), RebalanceOptions{}))
+}